@@ -0,0 +1,202 @@
+package api
+
+import (
+	"crudl_service/src/db"
+	"crudl_service/src/service"
+	"crudl_service/src/types"
+	"errors"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// GetProfile returns the caller's account
+//
+//	@Summary		Get own profile
+//	@Description	Account and profile of the caller.
+//	@Tags			account
+//	@Produce		json
+//	@Success		200	{object}	types.UserProfile	"Profile"
+//	@Failure		401	{object}	service.Problem		"Unauthorized"
+//	@Router			/me [get]
+func (a *App) GetProfile(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	profile, err := a.repo.GetProfile(r.Context(), principal.UserID)
+	if err != nil {
+		writeError(w, r, err, "User not found")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, profile)
+}
+
+// PatchProfile partially updates the caller's profile
+//
+//	@Summary		Update own profile
+//	@Description	Apply an RFC 7396 JSON Merge Patch to the display name, email, default currency and timezone of the caller. A null member clears the field.
+//	@Tags			account
+//	@Accept			json
+//	@Produce		json
+//	@Param			profile	body		types.Profile		true	"Merge patch of the profile"
+//	@Success		200		{object}	types.UserProfile	"Updated profile"
+//	@Failure		400		{object}	service.Problem		"Bad request"
+//	@Failure		401		{object}	service.Problem		"Unauthorized"
+//	@Failure		409		{object}	service.Problem		"Email address is taken"
+//	@Failure		415		{object}	service.Problem		"Unsupported media type"
+//	@Router			/me [patch]
+func (a *App) PatchProfile(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	if !requireMergePatch(w, r) {
+		return
+	}
+	patch, ok := readMergePatch(w, r)
+	if !ok {
+		return
+	}
+	var updated *types.UserProfile
+	err := a.repo.WithTx(r.Context(), func(tx db.Repository) error {
+		existing, err := tx.GetProfile(r.Context(), principal.UserID)
+		if errors.Is(err, db.ErrNotFound) {
+			return service.NewProblem(http.StatusNotFound, service.CodeNotFound, "User not found")
+		}
+		if err != nil {
+			return err
+		}
+		var profile types.Profile
+		if err := applyMergePatch(&existing.Profile, patch, &profile); err != nil {
+			return err
+		}
+		if err := service.Validate(&profile); err != nil {
+			return err
+		}
+		normalizeProfile(&profile)
+
+		updated, err = tx.UpdateProfile(r.Context(), principal.UserID, &profile)
+		if errors.Is(err, db.ErrConflict) {
+			return service.NewProblem(http.StatusConflict, service.CodeEmailTaken, "Email address is used by another account")
+		}
+		if err != nil {
+			return err
+		}
+		return recordAudit(tx, r, db.AuditProfileUpdated, principal.UserID, existing.Profile, updated.Profile)
+	})
+	if err != nil {
+		writeError(w, r, err, "Failed to update profile")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, updated)
+}
+
+// normalizeProfile brings validated profile fields into their stored form;
+// blank fields are cleared.
+func normalizeProfile(profile *types.Profile) {
+	for _, field := range []**string{&profile.DisplayName, &profile.Email, &profile.DefaultCurrency, &profile.Timezone} {
+		if *field == nil {
+			continue
+		}
+		value := strings.TrimSpace(**field)
+		if value == "" {
+			*field = nil
+			continue
+		}
+		*field = &value
+	}
+	if profile.Email != nil {
+		email := service.NormalizeEmail(*profile.Email)
+		profile.Email = &email
+	}
+	if profile.DefaultCurrency != nil {
+		currency, _ := service.NormalizeCurrency(*profile.DefaultCurrency)
+		profile.DefaultCurrency = &currency
+	}
+}
+
+// DeleteAccount deletes the caller's account
+//
+//	@Summary		Delete own account
+//	@Description	Delete the caller's account, sessions, API keys and two-factor data after confirming the password. Subscriptions are deleted as well, or kept without any link to the user when subscriptions is "anonymize". Requires a session token.
+//	@Tags			account
+//	@Accept			json
+//	@Param			account	body	types.DeleteAccountRequest	true	"Password and what to do with subscriptions"
+//	@Success		204		"Account deleted"
+//	@Failure		400		{object}	service.Problem	"Bad request"
+//	@Failure		401		{object}	service.Problem	"Unauthorized"
+//	@Failure		403		{object}	service.Problem	"Wrong password"
+//	@Failure		429		{object}	service.Problem	"Too many failed attempts"
+//	@Router			/me [delete]
+func (a *App) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+	var request types.DeleteAccountRequest
+	if !service.ReadUserData(w, r, &request) {
+		return
+	}
+	user, err := a.repo.GetUser(r.Context(), principal.UserID)
+	if err != nil {
+		writeError(w, r, err, "User not found")
+		return
+	}
+	ip := clientIP(r)
+	userKey, ipKey := userLoginKey(user.Username), "ip:"+ip
+	if a.loginLocked(w, r, userKey, ipKey) {
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(request.Password)) != nil {
+		a.recordLoginFailure(r, user.ID, user.Username, userKey, ipKey)
+		writeProblem(w, r, http.StatusForbidden, service.CodeInvalidCredentials, "Password is incorrect")
+		return
+	}
+
+	anonymize := request.Subscriptions == types.SubscriptionsAnonymize
+	err = a.repo.WithTx(r.Context(), func(tx db.Repository) error {
+		if err := tx.DeleteUser(r.Context(), user.ID, anonymize); err != nil {
+			return err
+		}
+		audit := newAuditEvent(r, db.AuditAccountDeleted, user.ID)
+		// Like the rest of the user's events, this one keeps no address.
+		audit.IP = ""
+		audit.Detail = "subscriptions deleted"
+		if anonymize {
+			audit.Detail = "subscriptions anonymized"
+		}
+		return tx.RecordAuditEvent(r.Context(), audit)
+	})
+	if err != nil {
+		writeError(w, r, err, "Failed to delete account")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ExportAccount exports everything stored about the caller
+//
+//	@Summary		Export own data
+//	@Description	Everything the service stores about the caller: profile, subscriptions, API keys, sessions and audit events. Secrets such as password and token hashes are left out. Requires a session token.
+//	@Tags			account
+//	@Produce		json
+//	@Success		200	{object}	types.UserExport	"Exported data"
+//	@Failure		401	{object}	service.Problem		"Unauthorized"
+//	@Failure		403	{object}	service.Problem		"Forbidden"
+//	@Router			/me/export [get]
+func (a *App) ExportAccount(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+	export, err := a.repo.ExportUser(r.Context(), principal.UserID)
+	if err != nil {
+		writeError(w, r, err, "User not found")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Disposition", `attachment; filename="crudl-export.json"`)
+	writeJSON(w, r, http.StatusOK, export)
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"bytes"
+	"crudl_service/src/service"
+	"crudl_service/src/types"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func sendAsUser(t *testing.T, app *App, method, path, body, userID string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := asUser(t, app, httptest.NewRequest(method, path, bytes.NewBufferString(body)), userID)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	app.Router().ServeHTTP(w, req)
+	return w
+}
+
+func TestPatchProfile(t *testing.T) {
+	app, repo := newRBACApp()
+
+	w := sendAsUser(t, app, "PATCH", "/me",
+		`{"display_name":" Alice ","email":"Alice@Example.COM","default_currency":"usd","timezone":"Europe/Moscow"}`, aliceID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var profile types.UserProfile
+	json.Unmarshal(w.Body.Bytes(), &profile)
+	if profile.Username != "alice" || *profile.DisplayName != "Alice" || *profile.Email != "Alice@example.com" ||
+		*profile.DefaultCurrency != "USD" || *profile.Timezone != "Europe/Moscow" {
+		t.Errorf("Expected a normalized profile, got %+v", profile)
+	}
+
+	w = sendAsUser(t, app, "PATCH", "/me", `{"display_name":null,"timezone":"UTC"}`, aliceID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	stored := repo.profiles[aliceID]
+	if stored.DisplayName != nil || *stored.Timezone != "UTC" || *stored.Email != "Alice@example.com" {
+		t.Errorf("Expected null to clear only the display name, got %+v", stored)
+	}
+
+	w = sendAsUser(t, app, "GET", "/me", ``, aliceID)
+	json.Unmarshal(w.Body.Bytes(), &profile)
+	if w.Code != http.StatusOK || profile.ID != aliceID || *profile.Timezone != "UTC" {
+		t.Errorf("Expected the stored profile, got %d %+v", w.Code, profile)
+	}
+}
+
+func TestPatchProfile_InvalidRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+		field    string
+	}{
+		{"Bad email", `{"email":"alice"}`, http.StatusBadRequest, "email"},
+		{"Bad timezone", `{"timezone":"Moscow"}`, http.StatusBadRequest, "timezone"},
+		{"Bad currency", `{"default_currency":"XYZ"}`, http.StatusBadRequest, "default_currency"},
+		{"Unknown field", `{"username":"root"}`, http.StatusBadRequest, ""},
+		{"Email taken", `{"email":"BOB@example.com"}`, http.StatusConflict, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newRBACApp()
+			sendAsUser(t, app, "PATCH", "/me", `{"email":"bob@example.com"}`, bobID)
+
+			w := sendAsUser(t, app, "PATCH", "/me", tt.body, aliceID)
+
+			if w.Code != tt.expected {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expected, w.Code, w.Body.String())
+			}
+			var problem service.Problem
+			json.Unmarshal(w.Body.Bytes(), &problem)
+			if tt.expected == http.StatusConflict && problem.Code != service.CodeEmailTaken {
+				t.Errorf("Expected code %s, got %s", service.CodeEmailTaken, problem.Code)
+			}
+			if tt.field != "" && (len(problem.Errors) != 1 || problem.Errors[0].Field != tt.field) {
+				t.Errorf("Expected a violation for %s, got %+v", tt.field, problem.Errors)
+			}
+		})
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	tests := []struct {
+		mode     string
+		survives bool
+	}{
+		{types.SubscriptionsDelete, false},
+		{types.SubscriptionsAnonymize, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			app, repo := newLoginApp()
+			seedSubscription(repo, 1, aliceID)
+			seedSubscription(repo, 2, bobID)
+			token := asUser(t, app, httptest.NewRequest("GET", "/me", nil), aliceID).Header.Get("Authorization")
+
+			w := sendAsUser(t, app, "DELETE", "/me", `{"password":"correct horse","subscriptions":"`+tt.mode+`"}`, aliceID)
+
+			if w.Code != http.StatusNoContent {
+				t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, w.Code, w.Body.String())
+			}
+			if _, ok := repo.users[aliceID]; ok {
+				t.Error("Expected the user to be deleted")
+			}
+			sub, ok := repo.subscriptions[1]
+			if ok != tt.survives || (ok && sub.UserId != "") {
+				t.Errorf("Expected subscription kept=%v without a user, got %+v", tt.survives, sub)
+			}
+			if _, ok := repo.subscriptions[2]; !ok {
+				t.Error("Expected other users' subscriptions to survive")
+			}
+			if len(repo.auditEvents) != 1 || repo.auditEvents[0].Event != "account_deleted" {
+				t.Errorf("Expected the deletion to be audited, got %+v", repo.auditEvents)
+			}
+
+			req := httptest.NewRequest("GET", "/me", nil)
+			req.Header.Set("Authorization", token)
+			w = httptest.NewRecorder()
+			app.Router().ServeHTTP(w, req)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("Expected the old token to be rejected, got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestDeleteAccount_Rejected(t *testing.T) {
+	app, repo := newLoginApp()
+	key := createAPIKey(t, app, aliceID, types.APIKeyScopeReadWrite)
+
+	w := sendAsUser(t, app, "DELETE", "/me", `{"password":"wrong horse","subscriptions":"delete"}`, aliceID)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d for a wrong password, got %d", http.StatusForbidden, w.Code)
+	}
+	w = sendAsUser(t, app, "DELETE", "/me", `{"password":"correct horse","subscriptions":"keep"}`, aliceID)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an unknown mode, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	req := withAPIKey(httptest.NewRequest("DELETE", "/me", bytes.NewBufferString(`{"password":"correct horse","subscriptions":"delete"}`)), key.Key)
+	w = httptest.NewRecorder()
+	app.Router().ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d with an API key, got %d", http.StatusForbidden, w.Code)
+	}
+	if _, ok := repo.users[aliceID]; !ok {
+		t.Error("Expected the user to survive")
+	}
+}
+
+func TestExportAccount(t *testing.T) {
+	app, repo := newRBACApp()
+	seedSubscription(repo, 1, aliceID)
+	seedSubscription(repo, 2, bobID)
+	createAPIKey(t, app, aliceID, types.APIKeyScopeRead)
+
+	w := sendAsUser(t, app, "GET", "/me/export", ``, aliceID)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if w.Header().Get("Cache-Control") != "no-store" {
+		t.Error("Expected the export not to be cached")
+	}
+	var export types.UserExport
+	json.Unmarshal(w.Body.Bytes(), &export)
+	if export.User.ID != aliceID || len(export.Subscriptions) != 1 || export.Subscriptions[0].Id != 1 || len(export.APIKeys) != 1 {
+		t.Errorf("Expected alice's data only, got %+v", export)
+	}
+	if bytes.Contains(w.Body.Bytes(), []byte("password")) {
+		t.Error("Expected the export to leave out the password")
+	}
+}
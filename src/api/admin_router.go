@@ -0,0 +1,233 @@
+package api
+
+import (
+	"crudl_service/src/db"
+	"crudl_service/src/service"
+	"crudl_service/src/types"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+// SetExchangeRates loads exchange rates for a month
+//
+//	@Summary		Load exchange rates
+//	@Description	Create or replace exchange rates effective from the given month, for admins
+//	@Tags			admin
+//	@Accept			json
+//	@Param			month	path	string						true	"Month in MM-YYYY format"
+//	@Param			rates	body	types.ExchangeRatesRequest	true	"Rates for the month"
+//	@Success		204		"Rates stored"
+//	@Failure		400		{object}	service.Problem	"Bad request"
+//	@Failure		403		{object}	service.Problem	"Forbidden"
+//	@Failure		500		{object}	service.Problem	"Internal server error"
+//	@Router			/admin/exchange_rates/{month} [put]
+func (a *App) SetExchangeRates(w http.ResponseWriter, r *http.Request) {
+	month := chi.URLParam(r, "month")
+	if _, err := time.Parse(service.MonthFormat, month); err != nil {
+		service.WriteProblem(w, r, service.InvalidParameter("month", "Expected MM-YYYY"))
+		return
+	}
+	var request types.ExchangeRatesRequest
+	if !service.ReadUserData(w, r, &request) {
+		return
+	}
+	for i := range request.Rates {
+		rate := &request.Rates[i]
+		rate.BaseCurrency, _ = service.NormalizeCurrency(rate.BaseCurrency)
+		rate.QuoteCurrency, _ = service.NormalizeCurrency(rate.QuoteCurrency)
+		rate.Month = month
+	}
+
+	if err := a.repo.SetExchangeRates(r.Context(), month, request.Rates); err != nil {
+		writeError(w, r, err, "Failed to store exchange rates")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListExchangeRates lists exchange rates loaded for a month
+//
+//	@Summary		List exchange rates
+//	@Description	Exchange rates loaded for the given month, for admins
+//	@Tags			admin
+//	@Produce		json
+//	@Param			month	path		string						true	"Month in MM-YYYY format"
+//	@Success		200		{object}	types.ExchangeRatesRequest	"Rates for the month"
+//	@Failure		400		{object}	service.Problem				"Bad request"
+//	@Failure		403		{object}	service.Problem				"Forbidden"
+//	@Failure		500		{object}	service.Problem				"Internal server error"
+//	@Router			/admin/exchange_rates/{month} [get]
+func (a *App) ListExchangeRates(w http.ResponseWriter, r *http.Request) {
+	month := chi.URLParam(r, "month")
+	if _, err := time.Parse(service.MonthFormat, month); err != nil {
+		service.WriteProblem(w, r, service.InvalidParameter("month", "Expected MM-YYYY"))
+		return
+	}
+	rates, err := a.repo.ListExchangeRates(r.Context(), month)
+	if err != nil {
+		writeError(w, r, err, "Failed to retrieve exchange rates")
+		return
+	}
+	if rates == nil {
+		rates = []types.ExchangeRate{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(types.ExchangeRatesRequest{Rates: rates}); err != nil {
+		log.WithError(err).Error("Failed to encode exchange rates")
+	}
+}
+
+// uuidPattern matches the textual form of user IDs.
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// userIDParam reads the {user_id} path parameter, writing a problem response
+// when it is not a UUID.
+func userIDParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID := chi.URLParam(r, "user_id")
+	if !uuidPattern.MatchString(userID) {
+		service.WriteProblem(w, r, service.InvalidParameter("user_id", "Expected a UUID"))
+		return "", false
+	}
+	return userID, true
+}
+
+func userInfo(user *db.User) types.UserInfo {
+	return types.UserInfo{ID: user.ID, Username: user.Username, Role: user.Role, DisabledAt: user.DisabledAt}
+}
+
+// ListUsers lists user accounts
+//
+//	@Summary		List users
+//	@Description	Users ordered by username, for admins
+//	@Tags			admin
+//	@Produce		json
+//	@Param			after	query		string	false	"Return users after this username"
+//	@Param			limit	query		int		false	"Page size, 20 by default and at most 100"
+//	@Success		200		{object}	types.UserListResponse	"Page of users"
+//	@Failure		400		{object}	service.Problem			"Bad request"
+//	@Failure		403		{object}	service.Problem			"Forbidden"
+//	@Router			/admin/users [get]
+func (a *App) ListUsers(w http.ResponseWriter, r *http.Request) {
+	limit := defaultListLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			service.WriteProblem(w, r, service.InvalidParameter("limit", "Expected a positive integer"))
+			return
+		}
+		limit = min(n, maxListLimit)
+	}
+
+	users, err := a.repo.ListUsers(r.Context(), r.URL.Query().Get("after"), limit+1)
+	if err != nil {
+		writeError(w, r, err, "Failed to retrieve users")
+		return
+	}
+	response := types.UserListResponse{Data: []types.UserInfo{}}
+	if len(users) > limit {
+		users = users[:limit]
+		response.HasMore = true
+		response.NextAfter = &users[limit-1].Username
+	}
+	for i := range users {
+		response.Data = append(response.Data, userInfo(&users[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.WithError(err).Error("Failed to encode user list")
+	}
+}
+
+// UpdateUserAccess changes a user's role or disables the account
+//
+//	@Summary		Update user access
+//	@Description	Change the role of a user or disable and re-enable the account. Any change signs the user out everywhere.
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			user_id	path		string							true	"User ID"
+//	@Param			access	body		types.UpdateUserAccessRequest	true	"Fields to change"
+//	@Success		200		{object}	types.UserInfo					"Updated user"
+//	@Failure		400		{object}	service.Problem					"Bad request"
+//	@Failure		403		{object}	service.Problem					"Forbidden"
+//	@Failure		404		{object}	service.Problem					"Not found"
+//	@Router			/admin/users/{user_id} [patch]
+func (a *App) UpdateUserAccess(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+	var request types.UpdateUserAccessRequest
+	if !service.ReadUserData(w, r, &request) {
+		return
+	}
+	if userID == principal.UserID {
+		writeProblem(w, r, http.StatusForbidden, service.CodeForbidden, "Admins cannot change their own access")
+		return
+	}
+	user, err := a.repo.GetUser(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err, "User not found")
+		return
+	}
+
+	before := userInfo(user)
+	if request.Role != nil {
+		user.Role = *request.Role
+	}
+	if request.Disabled != nil && *request.Disabled != (user.DisabledAt != nil) {
+		user.DisabledAt = nil
+		if *request.Disabled {
+			now := time.Now().UTC()
+			user.DisabledAt = &now
+		}
+	}
+	err = a.repo.WithTx(r.Context(), func(tx db.Repository) error {
+		if err := tx.UpdateUserAccess(r.Context(), user); err != nil {
+			return err
+		}
+		if err := tx.RevokeUserTokens(r.Context(), user.ID); err != nil {
+			return err
+		}
+		return recordAudit(tx, r, db.AuditAccessChanged, user.ID, before, userInfo(user))
+	})
+	if err != nil {
+		writeError(w, r, err, "Failed to update user")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(userInfo(user)); err != nil {
+		log.WithError(err).Error("Failed to encode user")
+	}
+}
+
+// ListUserSubscriptions lists any user's subscriptions
+//
+//	@Summary		List a user's subscriptions
+//	@Description	Same filters, sorting and paging as /subscriptionList, for the given user. Admins read and change single subscriptions through /subscription/{id}.
+//	@Tags			admin
+//	@Produce		json
+//	@Param			user_id	path		string	true	"User ID"
+//	@Success		200		{object}	types.SubscriptionListResponse	"Page of subscriptions"
+//	@Failure		400		{object}	service.Problem					"Bad request"
+//	@Failure		403		{object}	service.Problem					"Forbidden"
+//	@Router			/admin/users/{user_id}/subscriptions [get]
+func (a *App) ListUserSubscriptions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+	a.listSubscriptions(w, r, userID, false)
+}
@@ -0,0 +1,330 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crudl_service/src/db"
+	"crudl_service/src/types"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newAdminRequest(method, month string, body []byte) *http.Request {
+	req := httptest.NewRequest(method, "/admin/exchange_rates/"+month, bytes.NewBuffer(body))
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("month", month)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
+func TestExchangeRates_RequireAdmin(t *testing.T) {
+	app, repo := newRBACApp()
+	readKey := createAPIKey(t, app, adminID, types.APIKeyScopeRead)
+	body := `{"rates":[{"base_currency":"USD","quote_currency":"RUB","rate":92.5}]}`
+	tests := []struct {
+		name     string
+		method   string
+		request  func(req *http.Request) *http.Request
+		expected int
+	}{
+		{"Anonymous", "GET", func(req *http.Request) *http.Request { return req }, http.StatusUnauthorized},
+		{"Static token header", "PUT", func(req *http.Request) *http.Request {
+			req.Header.Set("X-Admin-Token", "change-me-too")
+			return req
+		}, http.StatusUnauthorized},
+		{"Regular user", "PUT", func(req *http.Request) *http.Request { return asUser(t, app, req, aliceID) }, http.StatusForbidden},
+		{"Read-only admin key", "PUT", func(req *http.Request) *http.Request { return withAPIKey(req, readKey.Key) }, http.StatusForbidden},
+		{"Read-only admin key reads", "GET", func(req *http.Request) *http.Request { return withAPIKey(req, readKey.Key) }, http.StatusOK},
+		{"Admin", "PUT", func(req *http.Request) *http.Request { return asUser(t, app, req, adminID) }, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.request(httptest.NewRequest(tt.method, "/admin/exchange_rates/03-2024", bytes.NewBufferString(body)))
+			w := httptest.NewRecorder()
+
+			app.Router().ServeHTTP(w, req)
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d: %s", tt.expected, w.Code, w.Body.String())
+			}
+			if stored := len(repo.rates["03-2024"]) > 0; stored != (tt.expected == http.StatusNoContent) {
+				t.Errorf("Expected rates stored only by the admin, got %v", stored)
+			}
+		})
+	}
+}
+
+func TestSetExchangeRates_Valid(t *testing.T) {
+	repo := newMockRepository()
+	app := newTestApp(repo)
+
+	body, _ := json.Marshal(types.ExchangeRatesRequest{Rates: []types.ExchangeRate{
+		{BaseCurrency: "usd", QuoteCurrency: "RUB", Rate: 92.5},
+	}})
+	w := httptest.NewRecorder()
+
+	app.SetExchangeRates(w, newAdminRequest("PUT", "03-2024", body))
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	stored := repo.rates["03-2024"]
+	if len(stored) != 1 || stored[0].BaseCurrency != "USD" || stored[0].Month != "03-2024" {
+		t.Errorf("Unexpected stored rates: %+v", stored)
+	}
+}
+
+func TestSetExchangeRates_InvalidRates(t *testing.T) {
+	tests := []struct {
+		name  string
+		month string
+		rates []types.ExchangeRate
+	}{
+		{"Bad month", "2024-03", []types.ExchangeRate{{BaseCurrency: "USD", QuoteCurrency: "RUB", Rate: 1}}},
+		{"Empty", "03-2024", nil},
+		{"Unknown currency", "03-2024", []types.ExchangeRate{{BaseCurrency: "XYZ", QuoteCurrency: "RUB", Rate: 1}}},
+		{"Same currency", "03-2024", []types.ExchangeRate{{BaseCurrency: "USD", QuoteCurrency: "usd", Rate: 1}}},
+		{"Non-positive rate", "03-2024", []types.ExchangeRate{{BaseCurrency: "USD", QuoteCurrency: "RUB", Rate: 0}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(newMockRepository())
+			body, _ := json.Marshal(types.ExchangeRatesRequest{Rates: tt.rates})
+			w := httptest.NewRecorder()
+
+			app.SetExchangeRates(w, newAdminRequest("PUT", tt.month, body))
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+			}
+		})
+	}
+}
+
+const (
+	adminID = "00000000-0000-4000-8000-000000000001"
+	aliceID = "00000000-0000-4000-8000-000000000002"
+	bobID   = "00000000-0000-4000-8000-000000000003"
+)
+
+// newRBACApp returns an app whose mock repository knows an admin and two
+// regular users.
+func newRBACApp() (*App, *mockRepository) {
+	repo := newMockRepository()
+	repo.users[adminID] = &db.User{ID: adminID, Username: "admin", Role: types.RoleAdmin}
+	repo.users[aliceID] = &db.User{ID: aliceID, Username: "alice", Role: types.RoleUser}
+	repo.users[bobID] = &db.User{ID: bobID, Username: "bob", Role: types.RoleUser}
+	return newTestApp(repo), repo
+}
+
+// asUser authenticates req with a fresh access token of userID.
+func asUser(t *testing.T, app *App, req *http.Request, userID string) *http.Request {
+	t.Helper()
+	user, _ := app.repo.GetUser(context.Background(), userID)
+	token, err := app.generateJWT(user.ID, user.Role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func withUserIDParam(req *http.Request, userID string) *http.Request {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("user_id", userID)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
+func TestRequireAdmin(t *testing.T) {
+	app, _ := newRBACApp()
+	tests := []struct {
+		name     string
+		userID   string
+		forged   string
+		expected int
+	}{
+		{"Admin", adminID, "", http.StatusOK},
+		{"Regular user", aliceID, "", http.StatusForbidden},
+		{"Forged role header", aliceID, types.RoleAdmin, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := asUser(t, app, httptest.NewRequest("GET", "/admin/users", nil), tt.userID)
+			if tt.forged != "" {
+				req.Header.Set("User-Role", tt.forged)
+			}
+			w := httptest.NewRecorder()
+
+			app.RequireAdmin(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})(w, req)
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
+
+func TestRequireAdmin_UsesCurrentRole(t *testing.T) {
+	app, repo := newRBACApp()
+	req := asUser(t, app, httptest.NewRequest("GET", "/admin/users", nil), adminID)
+	repo.users[adminID].Role = types.RoleUser
+	w := httptest.NewRecorder()
+
+	app.RequireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Handler should not be called for a demoted admin")
+	})(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestValidateJWT_DisabledUser(t *testing.T) {
+	app, repo := newRBACApp()
+	req := asUser(t, app, httptest.NewRequest("GET", "/subscriptionList", nil), aliceID)
+	now := time.Now()
+	repo.users[aliceID].DisabledAt = &now
+	w := httptest.NewRecorder()
+
+	app.ValidateJWT(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Handler should not be called for a disabled user")
+	})(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestRequireAdminWrite(t *testing.T) {
+	app, _ := newRBACApp()
+	for scope, expected := range map[string]int{types.APIKeyScopeRead: http.StatusForbidden, types.APIKeyScopeReadWrite: http.StatusOK} {
+		t.Run(scope, func(t *testing.T) {
+			key := createAPIKey(t, app, adminID, scope)
+			w := httptest.NewRecorder()
+
+			app.RequireAdminWrite(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})(w, withAPIKey(httptest.NewRequest("PATCH", "/admin/users/"+aliceID, nil), key.Key))
+
+			if w.Code != expected {
+				t.Errorf("Expected status %d, got %d", expected, w.Code)
+			}
+		})
+	}
+}
+
+func TestAdminCanReadAnySubscription(t *testing.T) {
+	app, repo := newRBACApp()
+	seedSubscription(repo, 1, aliceID)
+
+	for userID, expected := range map[string]int{adminID: http.StatusOK, bobID: http.StatusForbidden} {
+		req := asUser(t, app, withIDParam(httptest.NewRequest("GET", "/subscription/1", nil), "1"), userID)
+		w := httptest.NewRecorder()
+
+		app.ValidateJWT(app.ReadSubscription)(w, req)
+
+		if w.Code != expected {
+			t.Errorf("User %s: expected status %d, got %d", userID, expected, w.Code)
+		}
+	}
+}
+
+func TestListUsers_Paging(t *testing.T) {
+	app, _ := newRBACApp()
+
+	req := asUser(t, app, httptest.NewRequest("GET", "/admin/users?limit=2", nil), adminID)
+	w := httptest.NewRecorder()
+	app.RequireAdmin(app.ListUsers)(w, req)
+
+	var page types.UserListResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Failed to unmarshal users: %s", w.Body.String())
+	}
+	if len(page.Data) != 2 || !page.HasMore || page.NextAfter == nil || *page.NextAfter != "alice" {
+		t.Fatalf("Unexpected first page: %+v", page)
+	}
+
+	req = asUser(t, app, httptest.NewRequest("GET", "/admin/users?limit=2&after=alice", nil), adminID)
+	w = httptest.NewRecorder()
+	app.RequireAdmin(app.ListUsers)(w, req)
+
+	json.Unmarshal(w.Body.Bytes(), &page)
+	if len(page.Data) != 1 || page.Data[0].Username != "bob" || page.HasMore {
+		t.Errorf("Unexpected second page: %+v", page)
+	}
+}
+
+func TestUpdateUserAccess_DisableSignsOut(t *testing.T) {
+	app, repo := newRBACApp()
+	session := login(t, app, aliceID)
+
+	req := asUser(t, app, httptest.NewRequest("PATCH", "/admin/users/"+aliceID, bytes.NewBufferString(`{"disabled":true}`)), adminID)
+	w := httptest.NewRecorder()
+	app.RequireAdmin(app.UpdateUserAccess)(w, withUserIDParam(req, aliceID))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if repo.users[aliceID].DisabledAt == nil {
+		t.Error("Expected the account to be disabled")
+	}
+	if authorized(app, session.Token) {
+		t.Error("Expected the user's access tokens to be revoked")
+	}
+	if w := refresh(app, session.RefreshToken); w.Code == http.StatusOK {
+		t.Error("Expected the user's refresh tokens to be revoked")
+	}
+}
+
+func TestUpdateUserAccess_Rejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		userID   string
+		body     string
+		expected int
+	}{
+		{"Own account", adminID, `{"role":"user"}`, http.StatusForbidden},
+		{"Unknown role", aliceID, `{"role":"root"}`, http.StatusBadRequest},
+		{"Unknown user", "00000000-0000-4000-8000-0000000000ff", `{"disabled":true}`, http.StatusNotFound},
+		{"Malformed user ID", "alice", `{"disabled":true}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newRBACApp()
+			req := asUser(t, app, httptest.NewRequest("PATCH", "/admin/users/"+tt.userID, bytes.NewBufferString(tt.body)), adminID)
+			w := httptest.NewRecorder()
+			app.RequireAdmin(app.UpdateUserAccess)(w, withUserIDParam(req, tt.userID))
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
+
+func TestLoginUser_DisabledAccount(t *testing.T) {
+	app, repo := newRBACApp()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	disabledAt := time.Now()
+	repo.users[aliceID].Password = string(hash)
+	repo.users[aliceID].DisabledAt = &disabledAt
+
+	req := httptest.NewRequest("POST", "/login", bytes.NewBufferString(`{"username":"alice","password":"correct horse"}`))
+	w := httptest.NewRecorder()
+	app.LoginUser(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+}
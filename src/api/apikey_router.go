@@ -0,0 +1,211 @@
+package api
+
+import (
+	"crudl_service/src/db"
+	"crudl_service/src/service"
+	"crudl_service/src/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	// apiKeyPrefix starts every API key, which tells them apart from JWTs.
+	apiKeyPrefix = "crudl_"
+	// apiKeyDisplayLength is how much of a key is kept in clear to identify it.
+	apiKeyDisplayLength = len(apiKeyPrefix) + 6
+)
+
+// authenticateAPIKey authenticates r with the API key key and calls next
+// with the key owner as principal.
+func (a *App) authenticateAPIKey(w http.ResponseWriter, r *http.Request, key string, next http.HandlerFunc) {
+	owner, err := a.repo.AuthenticateAPIKey(r.Context(), hashToken(key))
+	if errors.Is(err, db.ErrNotFound) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeProblem(w, r, http.StatusUnauthorized, service.CodeUnauthorized, "Invalid API key")
+		return
+	}
+	if err != nil {
+		writeError(w, r, err, "Failed to validate API key")
+		return
+	}
+	next(w, r.WithContext(ContextWithPrincipal(r.Context(), &Principal{
+		UserID:     owner.UserID,
+		Roles:      []string{owner.Role},
+		AuthMethod: AuthMethodAPIKey,
+		Scope:      owner.Scope,
+	})))
+}
+
+// writeJSON responds with v encoded as JSON.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, r, err, "Failed to marshal response")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// apiKeyID reads the {id} path parameter, writing a problem response when it
+// is not an integer.
+func apiKeyID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := service.GetIDRequest(r)
+	if err != nil {
+		service.WriteProblem(w, r, service.InvalidParameter("id", "API key ID must be an integer"))
+		return 0, false
+	}
+	return id, true
+}
+
+// CreateAPIKey creates a personal API key
+//
+//	@Summary		Create API key
+//	@Description	Create a long-lived API key for scripts and integrations. The key is only returned once. Requires a session token.
+//	@Tags			api_keys
+//	@Accept			json
+//	@Produce		json
+//	@Param			key	body		types.CreateAPIKeyRequest	true	"Name and scope"
+//	@Success		201	{object}	types.CreateAPIKeyResponse	"Created key"
+//	@Failure		400	{object}	service.Problem				"Bad request"
+//	@Failure		401	{object}	service.Problem				"Unauthorized"
+//	@Failure		403	{object}	service.Problem				"Forbidden"
+//	@Router			/api_keys [post]
+func (a *App) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+	var request types.CreateAPIKeyRequest
+	if !service.ReadUserData(w, r, &request) {
+		return
+	}
+	secret, err := newOpaqueToken(32)
+	if err != nil {
+		writeError(w, r, err, "Key generation failed")
+		return
+	}
+	key := apiKeyPrefix + secret
+	response := types.CreateAPIKeyResponse{
+		APIKey: types.APIKey{Name: request.Name, Prefix: key[:apiKeyDisplayLength], Scope: request.Scope},
+		Key:    key,
+	}
+	err = a.repo.WithTx(r.Context(), func(tx db.Repository) error {
+		if err := tx.CreateAPIKey(r.Context(), principal.UserID, hashToken(key), &response.APIKey); err != nil {
+			return err
+		}
+		return recordAudit(tx, r, db.AuditAPIKeyCreated, principal.UserID, nil, response.APIKey, "created_at", "last_used_at")
+	})
+	if err != nil {
+		writeError(w, r, err, "Failed to create API key")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, r, http.StatusCreated, response)
+}
+
+// ListAPIKeys lists the caller's API keys
+//
+//	@Summary		List API keys
+//	@Description	Active API keys of the caller, newest first. Requires a session token.
+//	@Tags			api_keys
+//	@Produce		json
+//	@Success		200	{array}		types.APIKey	"API keys"
+//	@Failure		401	{object}	service.Problem	"Unauthorized"
+//	@Failure		403	{object}	service.Problem	"Forbidden"
+//	@Router			/api_keys [get]
+func (a *App) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+	keys, err := a.repo.ListAPIKeys(r.Context(), principal.UserID)
+	if err != nil {
+		writeError(w, r, err, "Failed to list API keys")
+		return
+	}
+	if keys == nil {
+		keys = []types.APIKey{}
+	}
+	writeJSON(w, r, http.StatusOK, keys)
+}
+
+// RenameAPIKey renames an API key
+//
+//	@Summary		Rename API key
+//	@Description	Change the name of one of the caller's API keys. Requires a session token.
+//	@Tags			api_keys
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int							true	"API key ID"
+//	@Param			name	body		types.RenameAPIKeyRequest	true	"New name"
+//	@Success		200		{object}	types.APIKey				"Renamed key"
+//	@Failure		400		{object}	service.Problem				"Bad request"
+//	@Failure		403		{object}	service.Problem				"Forbidden"
+//	@Failure		404		{object}	service.Problem				"Not found"
+//	@Router			/api_keys/{id} [patch]
+func (a *App) RenameAPIKey(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+	id, ok := apiKeyID(w, r)
+	if !ok {
+		return
+	}
+	var request types.RenameAPIKeyRequest
+	if !service.ReadUserData(w, r, &request) {
+		return
+	}
+	var key *types.APIKey
+	err := a.repo.WithTx(r.Context(), func(tx db.Repository) error {
+		var err error
+		if key, err = tx.RenameAPIKey(r.Context(), principal.UserID, id, request.Name); err != nil {
+			return err
+		}
+		return recordAudit(tx, r, db.AuditAPIKeyRenamed, principal.UserID, nil, key, "created_at", "last_used_at")
+	})
+	if err != nil {
+		writeError(w, r, err, "Failed to rename API key")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, key)
+}
+
+// RevokeAPIKey revokes an API key
+//
+//	@Summary		Revoke API key
+//	@Description	Revoke one of the caller's API keys. Requires a session token.
+//	@Tags			api_keys
+//	@Param			id	path	int	true	"API key ID"
+//	@Success		204	"Key revoked"
+//	@Failure		400	{object}	service.Problem	"Bad request"
+//	@Failure		403	{object}	service.Problem	"Forbidden"
+//	@Failure		404	{object}	service.Problem	"Not found"
+//	@Router			/api_keys/{id} [delete]
+func (a *App) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+	id, ok := apiKeyID(w, r)
+	if !ok {
+		return
+	}
+	err := a.repo.WithTx(r.Context(), func(tx db.Repository) error {
+		if err := tx.RevokeAPIKey(r.Context(), principal.UserID, id); err != nil {
+			return err
+		}
+		audit := newAuditEvent(r, db.AuditAPIKeyRevoked, principal.UserID)
+		audit.Detail = fmt.Sprintf("API key %d", id)
+		return tx.RecordAuditEvent(r.Context(), audit)
+	})
+	if err != nil {
+		writeError(w, r, err, "Failed to revoke API key")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"bytes"
+	"crudl_service/src/service"
+	"crudl_service/src/types"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createAPIKey creates an API key of userID through the router and returns
+// the response.
+func createAPIKey(t *testing.T, app *App, userID, scope string) types.CreateAPIKeyResponse {
+	t.Helper()
+	body, _ := json.Marshal(types.CreateAPIKeyRequest{Name: "ci", Scope: scope})
+	req := asUser(t, app, httptest.NewRequest("POST", "/api_keys", bytes.NewBuffer(body)), userID)
+	w := httptest.NewRecorder()
+	app.Router().ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	var response types.CreateAPIKeyResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return response
+}
+
+func withAPIKey(req *http.Request, key string) *http.Request {
+	req.Header.Set("X-API-Key", key)
+	return req
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	app, repo := newRBACApp()
+
+	key := createAPIKey(t, app, aliceID, types.APIKeyScopeRead)
+
+	if !strings.HasPrefix(key.Key, apiKeyPrefix) || !strings.HasPrefix(key.Key, key.Prefix) {
+		t.Errorf("Expected a %s key starting with its prefix, got %+v", apiKeyPrefix, key)
+	}
+	if _, ok := repo.apiKeys[hashToken(key.Key)]; !ok {
+		t.Error("Expected the key to be stored hashed")
+	}
+	if _, ok := repo.apiKeys[key.Key]; ok {
+		t.Error("Expected the key not to be stored in clear")
+	}
+}
+
+func TestCreateAPIKey_InvalidScope(t *testing.T) {
+	app, _ := newRBACApp()
+
+	req := asUser(t, app, httptest.NewRequest("POST", "/api_keys", bytes.NewBufferString(`{"name":"ci","scope":"admin"}`)), aliceID)
+	w := httptest.NewRecorder()
+	app.Router().ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestAPIKey_Authenticates(t *testing.T) {
+	app, repo := newRBACApp()
+	seedSubscription(repo, 1, aliceID)
+	key := createAPIKey(t, app, aliceID, types.APIKeyScopeRead)
+
+	for _, header := range []string{"X-API-Key", "Authorization"} {
+		t.Run(header, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/subscription/1", nil)
+			req.Header.Set(header, "Bearer "+key.Key)
+			if header == "X-API-Key" {
+				req.Header.Set(header, key.Key)
+			}
+			w := httptest.NewRecorder()
+			app.Router().ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+		})
+	}
+	if repo.apiKeys[hashToken(key.Key)].LastUsedAt == nil {
+		t.Error("Expected the last use to be recorded")
+	}
+}
+
+func TestAPIKey_ReadScopeCannotWrite(t *testing.T) {
+	app, repo := newRBACApp()
+	seedSubscription(repo, 1, aliceID)
+	key := createAPIKey(t, app, aliceID, types.APIKeyScopeRead)
+
+	w := httptest.NewRecorder()
+	app.Router().ServeHTTP(w, withAPIKey(httptest.NewRequest("DELETE", "/subscription/1", nil), key.Key))
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+	var problem service.Problem
+	json.Unmarshal(w.Body.Bytes(), &problem)
+	if problem.Code != service.CodeInsufficientScope {
+		t.Errorf("Expected code %s, got %s", service.CodeInsufficientScope, problem.Code)
+	}
+	if sub, ok := repo.subscriptions[1]; !ok || sub.DeletedAt != nil {
+		t.Error("Expected the subscription to survive")
+	}
+
+	key = createAPIKey(t, app, aliceID, types.APIKeyScopeReadWrite)
+	w = httptest.NewRecorder()
+	app.Router().ServeHTTP(w, withAPIKey(httptest.NewRequest("DELETE", "/subscription/1", nil), key.Key))
+	if sub := repo.subscriptions[1]; sub.DeletedAt == nil || w.Code != http.StatusOK {
+		t.Errorf("Expected a read_write key to delete, got %d", w.Code)
+	}
+}
+
+func TestAPIKey_CannotManageKeys(t *testing.T) {
+	app, _ := newRBACApp()
+	key := createAPIKey(t, app, aliceID, types.APIKeyScopeReadWrite)
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/api_keys", nil),
+		httptest.NewRequest("POST", "/api_keys", bytes.NewBufferString(`{"name":"more","scope":"read_write"}`)),
+		httptest.NewRequest("DELETE", "/api_keys/1", nil),
+		httptest.NewRequest("POST", "/logout", bytes.NewBufferString(`{"refresh_token":"x"}`)),
+	} {
+		w := httptest.NewRecorder()
+		app.Router().ServeHTTP(w, withAPIKey(req, key.Key))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: expected status %d, got %d", req.Method, req.URL.Path, http.StatusForbidden, w.Code)
+		}
+	}
+}
+
+func TestAPIKey_Revoked(t *testing.T) {
+	app, _ := newRBACApp()
+	key := createAPIKey(t, app, aliceID, types.APIKeyScopeRead)
+
+	req := asUser(t, app, httptest.NewRequest("DELETE", "/api_keys/1", nil), aliceID)
+	w := httptest.NewRecorder()
+	app.Router().ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	app.Router().ServeHTTP(w, withAPIKey(httptest.NewRequest("GET", "/subscriptionList", nil), key.Key))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked key to be rejected, got %d", w.Code)
+	}
+}
+
+func TestAPIKey_OtherUsersKeys(t *testing.T) {
+	app, _ := newRBACApp()
+	createAPIKey(t, app, aliceID, types.APIKeyScopeRead)
+
+	req := asUser(t, app, httptest.NewRequest("PATCH", "/api_keys/1", bytes.NewBufferString(`{"name":"mine"}`)), bobID)
+	w := httptest.NewRecorder()
+	app.Router().ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	req = asUser(t, app, httptest.NewRequest("GET", "/api_keys", nil), bobID)
+	w = httptest.NewRecorder()
+	app.Router().ServeHTTP(w, req)
+	if body := strings.TrimSpace(w.Body.String()); body != "[]" {
+		t.Errorf("Expected no keys for bob, got %s", body)
+	}
+}
+
+func TestAPIKey_DisabledUser(t *testing.T) {
+	app, repo := newRBACApp()
+	key := createAPIKey(t, app, aliceID, types.APIKeyScopeRead)
+	now := time.Now()
+	repo.users[aliceID].DisabledAt = &now
+
+	w := httptest.NewRecorder()
+	app.Router().ServeHTTP(w, withAPIKey(httptest.NewRequest("GET", "/subscriptionList", nil), key.Key))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"crudl_service/src/db"
+	"crudl_service/src/service"
+	"crudl_service/src/types"
+	"net/http"
+	"strconv"
+)
+
+// newAuditEvent describes event about userID, caused by the authenticated
+// caller of r if there is one.
+func newAuditEvent(r *http.Request, event, userID string) *db.AuditEvent {
+	audit := &db.AuditEvent{
+		Event:     event,
+		UserID:    userID,
+		RequestID: RequestIDFromContext(r.Context()),
+		IP:        clientIP(r),
+	}
+	if principal, ok := PrincipalFromContext(r.Context()); ok {
+		audit.ActorID = principal.UserID
+	}
+	return audit
+}
+
+// recordAudit records event about userID through repo, with the changes
+// from before to after, either of which may be nil; see service.Diff.
+func recordAudit(repo db.Repository, r *http.Request, event, userID string, before, after any, ignore ...string) error {
+	changes, err := service.Diff(before, after, ignore...)
+	if err != nil {
+		return err
+	}
+	audit := newAuditEvent(r, event, userID)
+	audit.Changes = changes
+	return repo.RecordAuditEvent(r.Context(), audit)
+}
+
+// auditSubscription records event about a subscription that changed from
+// before to after through repo. Either may be nil, but not both. updated_at
+// is left out as it changes with every write.
+func auditSubscription(repo db.Repository, r *http.Request, event string, before, after *types.UserSubscription) error {
+	sub := after
+	if sub == nil {
+		sub = before
+	}
+	changes, err := service.Diff(before, after, "updated_at")
+	if err != nil {
+		return err
+	}
+	audit := newAuditEvent(r, event, sub.UserId)
+	audit.SubscriptionID = sub.Id
+	audit.Changes = changes
+	return repo.RecordAuditEvent(r.Context(), audit)
+}
+
+// ListAuditEvents lists the audit trail of the authenticated user
+//
+//	@Summary		List own audit events
+//	@Description	Changes to the caller's subscriptions and account and the caller's logins, newest first
+//	@Tags			account
+//	@Produce		json
+//	@Param			event	query		string	false	"Only events of this kind, such as subscription_updated or login_failed"
+//	@Param			before	query		int		false	"Return events older than this ID, from next_before of the previous page"
+//	@Param			limit	query		int		false	"Page size, 20 by default and at most 100"
+//	@Success		200		{object}	types.AuditEventListResponse	"Page of audit events"
+//	@Failure		400		{object}	service.Problem					"Bad request"
+//	@Failure		401		{object}	service.Problem					"Unauthorized"
+//	@Router			/audit [get]
+func (a *App) ListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	query, err := parseAuditQuery(r)
+	if err != nil {
+		writeError(w, r, err, "Invalid audit parameters")
+		return
+	}
+	query.UserID = principal.UserID
+	a.listAuditEvents(w, r, query)
+}
+
+// ListAllAuditEvents lists the audit trail of every user
+//
+//	@Summary		List audit events
+//	@Description	Audit events of all users, newest first, for admins
+//	@Tags			admin
+//	@Produce		json
+//	@Param			user_id		query		string	false	"Only events about this user"
+//	@Param			actor_id	query		string	false	"Only events caused by this user"
+//	@Param			event		query		string	false	"Only events of this kind"
+//	@Param			before		query		int		false	"Return events older than this ID, from next_before of the previous page"
+//	@Param			limit		query		int		false	"Page size, 20 by default and at most 100"
+//	@Success		200			{object}	types.AuditEventListResponse	"Page of audit events"
+//	@Failure		400			{object}	service.Problem					"Bad request"
+//	@Failure		403			{object}	service.Problem					"Forbidden"
+//	@Router			/admin/audit [get]
+func (a *App) ListAllAuditEvents(w http.ResponseWriter, r *http.Request) {
+	query, err := parseAuditQuery(r)
+	if err != nil {
+		writeError(w, r, err, "Invalid audit parameters")
+		return
+	}
+	for name, dst := range map[string]*string{"user_id": &query.UserID, "actor_id": &query.ActorID} {
+		if v := r.URL.Query().Get(name); v != "" {
+			if !uuidPattern.MatchString(v) {
+				service.WriteProblem(w, r, service.InvalidParameter(name, "Expected a UUID"))
+				return
+			}
+			*dst = v
+		}
+	}
+	a.listAuditEvents(w, r, query)
+}
+
+func (a *App) listAuditEvents(w http.ResponseWriter, r *http.Request, query *types.AuditEventQuery) {
+	pageSize := query.Limit
+	query.Limit = pageSize + 1
+	events, err := a.repo.ListAuditEvents(r.Context(), query)
+	if err != nil {
+		writeError(w, r, err, "Failed to retrieve audit events")
+		return
+	}
+	response := types.AuditEventListResponse{Data: []types.AuditEventInfo{}}
+	if len(events) > pageSize {
+		events = events[:pageSize]
+		response.HasMore = true
+		response.NextBefore = &events[pageSize-1].ID
+	}
+	response.Data = append(response.Data, events...)
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// parseAuditQuery reads the query parameters shared by the audit endpoints.
+func parseAuditQuery(r *http.Request) (*types.AuditEventQuery, error) {
+	params := r.URL.Query()
+	query := &types.AuditEventQuery{Event: params.Get("event"), Limit: defaultListLimit}
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, service.InvalidParameter("limit", "Must be a positive integer")
+		}
+		query.Limit = min(n, maxListLimit)
+	}
+	if v := params.Get("before"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, service.InvalidParameter("before", "Must be a positive integer")
+		}
+		query.Before = id
+	}
+	return query, nil
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"bytes"
+	"crudl_service/src/db"
+	"crudl_service/src/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// auditPage fetches path as userID and decodes the page of audit events.
+func auditPage(t *testing.T, app *App, path, userID string) types.AuditEventListResponse {
+	t.Helper()
+	w := sendAsUser(t, app, "GET", path, ``, userID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var page types.AuditEventListResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	return page
+}
+
+func TestAudit_SubscriptionChanges(t *testing.T) {
+	app, repo := newRBACApp()
+	app.requireIfMatch = false
+	router := app.Router()
+
+	req := asUser(t, app, httptest.NewRequest("POST", "/subscription", bytes.NewBufferString(
+		`{"service_name":"Netflix","price":999,"start_date":"2024-01-01"}`)), aliceID)
+	req.Header.Set("X-Request-ID", "req-1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("X-Request-ID"); got != "req-1" {
+		t.Errorf("Expected the request ID to be echoed, got %q", got)
+	}
+	path := fmt.Sprintf("/subscription/%d", repo.nextID-1)
+
+	req = asUser(t, app, httptest.NewRequest("PATCH", path, bytes.NewBufferString(`{"price":1500}`)), adminID)
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w := sendAsUser(t, app, "DELETE", path, ``, aliceID); w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if w := sendAsUser(t, app, "POST", path+"/restore", ``, aliceID); w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	events := repo.auditEvents
+	if len(events) != 4 {
+		t.Fatalf("Expected 4 audit events, got %+v", events)
+	}
+	for i, expected := range []struct{ event, actor string }{
+		{db.AuditSubscriptionCreated, aliceID},
+		{db.AuditSubscriptionUpdated, adminID},
+		{db.AuditSubscriptionDeleted, aliceID},
+		{db.AuditSubscriptionRestored, aliceID},
+	} {
+		event := events[i]
+		if event.Event != expected.event || event.ActorID != expected.actor || event.UserID != aliceID ||
+			event.SubscriptionID != repo.nextID-1 || event.RequestID == "" || event.IP == "" {
+			t.Errorf("Expected %s by %s about alice's subscription, got %+v", expected.event, expected.actor, event)
+		}
+	}
+	if events[0].RequestID != "req-1" || !strings.Contains(string(events[0].Changes), `"after":{`) {
+		t.Errorf("Expected the creation to record the new subscription, got %+v", events[0])
+	}
+	if got := string(events[1].Changes); got != `{"before":{"price":999,"version":1},"after":{"price":1500,"version":2}}` {
+		t.Errorf("Expected the update to record the changed members, got %s", got)
+	}
+	if got := string(events[2].Changes); !strings.Contains(got, `"before":{"deleted_at":null`) {
+		t.Errorf("Expected the deletion to record deleted_at, got %s", got)
+	}
+}
+
+func TestAudit_AuthEvents(t *testing.T) {
+	app, repo := newLoginApp()
+	req := httptest.NewRequest("POST", "/register", bytes.NewBufferString(`{"username":"carol","password":"correct horse"}`))
+	w := httptest.NewRecorder()
+	app.Router().ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	attemptLogin(app, "alice", "wrong password", "10.0.0.1:1234")
+	attemptLogin(app, "nobody", "wrong password", "10.0.0.1:1234")
+	attemptLogin(app, "alice", "correct horse", "10.0.0.1:1234")
+
+	registered := repo.auditEventsOf(db.AuditRegistered)
+	if len(registered) != 1 || registered[0].UserID == "" || registered[0].ActorID != registered[0].UserID {
+		t.Errorf("Expected the registration to be audited as done by the new user, got %+v", registered)
+	}
+	failures := repo.auditEventsOf(db.AuditLoginFailed)
+	if len(failures) != 2 || failures[0].UserID != aliceID || failures[1].UserID != "" || failures[1].Username != "nobody" {
+		t.Errorf("Expected both failed logins to be audited, got %+v", failures)
+	}
+	logins := repo.auditEventsOf(db.AuditLogin)
+	if len(logins) != 1 || logins[0].UserID != aliceID || logins[0].ActorID != aliceID || logins[0].IP != "10.0.0.1" {
+		t.Errorf("Expected the login to be audited, got %+v", logins)
+	}
+}
+
+func TestAudit_FailureRejectsChange(t *testing.T) {
+	app, repo := newLoginApp()
+	app.requireIfMatch = false
+	seedSubscription(repo, 1, aliceID)
+	repo.auditErr = errors.New("audit_events is append-only")
+
+	if w := sendAsUser(t, app, "DELETE", "/subscription/1", ``, aliceID); w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status %d when the change cannot be audited, got %d", http.StatusInternalServerError, w.Code)
+	}
+	w := attemptLogin(app, "alice", "correct horse", "10.0.0.1:1234")
+	if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "token") {
+		t.Errorf("Expected no tokens when the login cannot be audited, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestListAuditEvents(t *testing.T) {
+	app, repo := newRBACApp()
+	for i := 0; i < 3; i++ {
+		repo.RecordAuditEvent(t.Context(), &db.AuditEvent{Event: db.AuditSubscriptionUpdated, UserID: aliceID, ActorID: aliceID})
+	}
+	repo.RecordAuditEvent(t.Context(), &db.AuditEvent{Event: db.AuditLogin, UserID: aliceID, ActorID: aliceID})
+	repo.RecordAuditEvent(t.Context(), &db.AuditEvent{Event: db.AuditAccessChanged, UserID: bobID, ActorID: adminID})
+
+	page := auditPage(t, app, "/audit?limit=3", aliceID)
+	if len(page.Data) != 3 || page.Data[0].ID != 4 || !page.HasMore || page.NextBefore == nil || *page.NextBefore != 2 {
+		t.Fatalf("Expected alice's newest 3 events and a next page, got %+v", page)
+	}
+	page = auditPage(t, app, fmt.Sprintf("/audit?limit=3&before=%d", *page.NextBefore), aliceID)
+	if len(page.Data) != 1 || page.Data[0].ID != 1 || page.HasMore {
+		t.Errorf("Expected alice's oldest event on the last page, got %+v", page)
+	}
+	if page := auditPage(t, app, "/audit?event=login", aliceID); len(page.Data) != 1 || page.Data[0].Event != db.AuditLogin {
+		t.Errorf("Expected only the login, got %+v", page.Data)
+	}
+	if page := auditPage(t, app, "/audit", bobID); len(page.Data) != 1 || *page.Data[0].ActorID != adminID {
+		t.Errorf("Expected only bob's event, got %+v", page.Data)
+	}
+	if page := auditPage(t, app, "/admin/audit", adminID); len(page.Data) != 5 {
+		t.Errorf("Expected admins to see every event, got %+v", page.Data)
+	}
+	if page := auditPage(t, app, "/admin/audit?actor_id="+adminID, adminID); len(page.Data) != 1 || *page.Data[0].UserID != bobID {
+		t.Errorf("Expected the events caused by the admin, got %+v", page.Data)
+	}
+}
+
+func TestListAuditEvents_InvalidParams(t *testing.T) {
+	app, _ := newRBACApp()
+	for _, path := range []string{"/audit?limit=0", "/audit?before=x", "/audit?before=-1", "/admin/audit?user_id=alice"} {
+		t.Run(path, func(t *testing.T) {
+			userID := aliceID
+			if strings.HasPrefix(path, "/admin") {
+				userID = adminID
+			}
+			if w := sendAsUser(t, app, "GET", path, ``, userID); w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+			}
+		})
+	}
+}
+
+func TestRequestID_ReplacesUnusableIDs(t *testing.T) {
+	var seen string
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-ID", "no spaces allowed")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if seen == "" || seen == "no spaces allowed" || w.Header().Get("X-Request-ID") != seen {
+		t.Errorf("Expected a generated request ID, got %q and header %q", seen, w.Header().Get("X-Request-ID"))
+	}
+}
@@ -1,11 +1,18 @@
 package api
 
 import (
+	"context"
+	"crudl_service/src/db"
+	"crudl_service/src/service"
 	"crudl_service/src/types"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -15,126 +22,316 @@ import (
 
 type Claims struct {
 	UserID string `json:"user_id"`
+	// Role is types.RoleUser or types.RoleAdmin at the time the token was
+	// issued. It only informs clients: ValidateJWT authorizes with the role
+	// the user has now.
+	Role string `json:"role,omitempty"`
 	jwt.RegisteredClaims
 }
 
+func init() {
+	// Issue times are compared with tokens_valid_after, which has microsecond
+	// precision; whole seconds would let tokens issued in the second of a
+	// logout from all sessions through.
+	jwt.TimePrecision = time.Microsecond
+}
+
+// dummyPasswordHash is compared against when the username does not exist, so
+// that unknown and known usernames take the same time to reject.
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
 func (a *App) LoginUser(w http.ResponseWriter, r *http.Request) {
 	var request types.UserLoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	if !service.ReadUserData(w, r, &request) {
 		return
 	}
+	request.Username = service.NormalizeUsername(request.Username)
+	ip := clientIP(r)
+	userKey, ipKey := userLoginKey(request.Username), "ip:"+ip
 
-	user, err := a.repo.GetUserByUsername(request.Username)
-	if err != nil {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+	if a.loginLocked(w, r, userKey, ipKey) {
 		return
 	}
-	if err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(request.Password)); err != nil {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+
+	user, err := a.repo.GetUserByUsername(r.Context(), request.Username)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		writeError(w, r, err, "Login failed")
 		return
 	}
+	hash := dummyPasswordHash()
+	if user != nil {
+		hash = []byte(user.Password)
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(request.Password)) != nil || user == nil {
+		userID := ""
+		if user != nil {
+			userID = user.ID
+		}
+		a.recordLoginFailure(r, userID, request.Username, userKey, ipKey)
+		writeProblem(w, r, http.StatusUnauthorized, service.CodeInvalidCredentials, "Invalid username or password")
+		return
+	}
+	if user.DisabledAt != nil {
+		writeProblem(w, r, http.StatusForbidden, service.CodeAccountDisabled, "Account is disabled")
+		return
+	}
+	if user.TOTPEnabledAt != nil {
+		// Failures are only forgotten once the second factor succeeds too.
+		a.startMFAChallenge(w, r, user.ID)
+		return
+	}
+	if err := a.repo.ClearLoginFailures(r.Context(), userKey); err != nil {
+		log.WithError(err).Warn("Failed to reset login failures")
+	}
+	if !a.auditLogin(w, r, user.ID) {
+		return
+	}
+
+	a.issueTokens(w, r, user.ID, user.Role, http.StatusOK)
+}
+
+// auditLogin records a successful login of userID, answering with an error
+// itself when that fails: logins are not let through unaudited.
+func (a *App) auditLogin(w http.ResponseWriter, r *http.Request, userID string) bool {
+	audit := newAuditEvent(r, db.AuditLogin, userID)
+	audit.ActorID = userID
+	if err := a.repo.RecordAuditEvent(r.Context(), audit); err != nil {
+		writeError(w, r, err, "Login failed")
+		return false
+	}
+	return true
+}
 
-	token, err := a.generateJWT(user.ID)
+// loginLocked reports whether any of keys is locked by the login throttle,
+// answering 429 itself when it is.
+func (a *App) loginLocked(w http.ResponseWriter, r *http.Request, keys ...string) bool {
+	lockedUntil, err := a.repo.LoginLockedUntil(r.Context(), keys...)
 	if err != nil {
-		log.WithError(err).Error("Failed to generate JWT")
-		http.Error(w, "Token generation failed", http.StatusInternalServerError)
-		return
+		writeError(w, r, err, "Login failed")
+		return true
 	}
+	if retryAfter := time.Until(lockedUntil); retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		writeProblem(w, r, http.StatusTooManyRequests, service.CodeTooManyAttempts, "Too many failed login attempts, try again later")
+		return true
+	}
+	return false
+}
 
-	a.sendAuthResponse(w, token, user.ID, http.StatusOK)
+// userLoginKey is the login throttle key of username.
+func userLoginKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+// recordLoginFailure audits a failed login as username, whose ID is userID
+// when the user exists, and counts it against the username and the client
+// address, locking either of them once it reaches its throttle and auditing
+// lockouts. Errors are logged: the login fails either way. The failure is
+// recorded even if the client disconnects, so that aborting requests does not
+// dodge the throttle.
+func (a *App) recordLoginFailure(r *http.Request, userID, username, userKey, ipKey string) {
+	ctx := context.WithoutCancel(r.Context())
+	failure := newAuditEvent(r, db.AuditLoginFailed, userID)
+	failure.Username = username
+	if err := a.repo.RecordAuditEvent(ctx, failure); err != nil {
+		log.WithError(err).Warn("Failed to audit login failure")
+	}
+	for _, key := range []struct {
+		name     string
+		throttle service.LoginThrottle
+	}{{userKey, a.userThrottle}, {ipKey, a.ipThrottle}} {
+		failures, err := a.repo.RecordLoginFailure(ctx, key.name, key.throttle.Window)
+		if err != nil {
+			log.WithError(err).Warn("Failed to record login failure")
+			continue
+		}
+		delay := key.throttle.Delay(failures)
+		if delay == 0 {
+			continue
+		}
+		if err := a.repo.LockLogin(ctx, key.name, time.Now().Add(delay)); err != nil {
+			log.WithError(err).Warn("Failed to lock login")
+			continue
+		}
+		if key.throttle.LockedOut(failures) {
+			log.WithFields(log.Fields{"key": key.name, "failures": failures}).Warn("Login locked out")
+			lockout := newAuditEvent(r, db.AuditLoginLockout, "")
+			lockout.Username = username
+			lockout.Detail = fmt.Sprintf("%s locked for %s after %d failed logins", key.name, delay, failures)
+			if err := a.repo.RecordAuditEvent(ctx, lockout); err != nil {
+				log.WithError(err).Warn("Failed to audit login lockout")
+			}
+		}
+	}
+}
+
+// clientIP returns the address of the client that sent r.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
 
 func (a *App) RegisterUser(w http.ResponseWriter, r *http.Request) {
 	var request types.UserRegisterRequest
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	if !service.ReadUserData(w, r, &request, a.checkPassword("password", &request.Password)) {
 		return
 	}
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
 	if err != nil {
-		log.WithError(err).Error("Failed to hash password")
-		http.Error(w, "Password hashing failed", http.StatusInternalServerError)
+		writeError(w, r, err, "Password hashing failed")
 		return
 	}
 
-	userID, err := a.repo.CreateUser(request.Username, string(hashedPassword))
-	if err != nil {
-		log.WithError(err).Error("Failed to create user")
-		http.Error(w, "User creation failed", http.StatusInternalServerError)
+	username := service.NormalizeUsername(request.Username)
+	var userID string
+	err = a.repo.WithTx(r.Context(), func(tx db.Repository) error {
+		var err error
+		if userID, err = tx.CreateUser(r.Context(), username, string(hashedPassword)); err != nil {
+			return err
+		}
+		audit := newAuditEvent(r, db.AuditRegistered, userID)
+		audit.ActorID = userID
+		return tx.RecordAuditEvent(r.Context(), audit)
+	})
+	if errors.Is(err, db.ErrConflict) {
+		writeProblem(w, r, http.StatusConflict, service.CodeUsernameTaken, "Username is already taken")
 		return
 	}
-
-	token, err := a.generateJWT(userID)
 	if err != nil {
-		log.WithError(err).Error("Failed to generate JWT")
-		http.Error(w, "Token generation failed", http.StatusInternalServerError)
+		writeError(w, r, err, "User creation failed")
 		return
 	}
 
-	a.sendAuthResponse(w, token, userID, http.StatusCreated)
+	a.issueTokens(w, r, userID, types.RoleUser, http.StatusCreated)
 }
 
-func (a *App) sendAuthResponse(w http.ResponseWriter, token, userID string, status int) {
-	body, err := json.Marshal(types.AuthResponse{Token: token, UserID: userID})
+func (a *App) generateJWT(userID, role string) (string, error) {
+	jti, err := newOpaqueToken(16)
 	if err != nil {
-		log.WithError(err).Error("Failed to marshal auth response")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	w.Write(body)
-}
-
-func (a *App) generateJWT(userID string) (string, error) {
-	if a.jwtSecret == "" {
-		return "", fmt.Errorf("JWT secret is not configured")
+		return "", err
 	}
+	now := time.Now()
 	claims := Claims{
 		UserID: userID,
+		Role:   role,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(now.Add(a.accessTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(a.jwtSecret))
+	return a.keys.Sign(claims)
 }
 
 func (a *App) ValidateJWT(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tokenString := extractToken(r)
 		if tokenString == "" {
-			http.Error(w, "Missing token", http.StatusUnauthorized)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeProblem(w, r, http.StatusUnauthorized, service.CodeUnauthorized, "Missing token")
+			return
+		}
+		if strings.HasPrefix(tokenString, apiKeyPrefix) {
+			a.authenticateAPIKey(w, r, tokenString, next)
 			return
 		}
 		claims, err := a.parseToken(tokenString)
 		if err != nil {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeProblem(w, r, http.StatusUnauthorized, service.CodeUnauthorized, "Invalid token")
+			return
+		}
+		role, err := a.repo.AuthenticateAccessToken(r.Context(), claims.ID, claims.UserID, claims.IssuedAt.Time)
+		if errors.Is(err, db.ErrNotFound) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeProblem(w, r, http.StatusUnauthorized, service.CodeUnauthorized, "Token has been revoked")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err, "Failed to validate token")
+			return
+		}
+		next(w, r.WithContext(ContextWithPrincipal(r.Context(), &Principal{
+			UserID:         claims.UserID,
+			Roles:          []string{role},
+			TokenID:        claims.ID,
+			TokenExpiresAt: claims.ExpiresAt.Time,
+			AuthMethod:     AuthMethodJWT,
+			Scope:          types.APIKeyScopeReadWrite,
+		})))
+	}
+}
+
+// RequireWriteScope authenticates the request like ValidateJWT and rejects
+// read-only API keys.
+func (a *App) RequireWriteScope(next http.HandlerFunc) http.HandlerFunc {
+	return a.ValidateJWT(requireWriteScope(next))
+}
+
+// requireWriteScope rejects authenticated requests without read_write scope.
+func requireWriteScope(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := requirePrincipal(w, r)
+		if !ok {
+			return
+		}
+		if principal.Scope != types.APIKeyScopeReadWrite {
+			writeProblem(w, r, http.StatusForbidden, service.CodeInsufficientScope, "API key is read-only")
 			return
 		}
-		r.Header.Set("User-ID", claims.UserID)
 		next(w, r)
 	}
 }
 
+// RequireAdmin authenticates the request like ValidateJWT and only lets
+// through users with the admin role.
+func (a *App) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return a.ValidateJWT(func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			writeProblem(w, r, http.StatusForbidden, service.CodeForbidden, "Admin role required")
+			return
+		}
+		next(w, r)
+	})
+}
+
+// RequireAdminWrite authenticates the request like RequireAdmin and rejects
+// read-only API keys.
+func (a *App) RequireAdminWrite(next http.HandlerFunc) http.HandlerFunc {
+	return a.RequireAdmin(requireWriteScope(next))
+}
+
 func (a *App) parseToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
-		return []byte(a.jwtSecret), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, a.keys.Keyfunc, jwt.WithValidMethods(a.keys.ValidMethods()))
 	if err != nil {
 		return nil, err
 	}
 	if !token.Valid {
 		return nil, fmt.Errorf("token is not valid")
 	}
+	if claims.ID == "" || claims.IssuedAt == nil {
+		return nil, fmt.Errorf("token has no jti or iat claim")
+	}
 	return claims, nil
 }
 
+// extractToken returns the credential of r: an access token or API key from
+// the Authorization header, or an API key from the X-API-Key header.
 func extractToken(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
 		return ""
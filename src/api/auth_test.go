@@ -2,6 +2,11 @@ package api
 
 import (
 	"bytes"
+	"context"
+	"crudl_service/src/db"
+	"crudl_service/src/service"
+	"crudl_service/src/types"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -10,7 +15,9 @@ import (
 )
 
 func newAuthTestApp() *App {
-	return &App{jwtSecret: "test-secret-key"}
+	app := newTestApp(newMockRepository())
+	app.keys = service.NewHMACKeySet("test-secret-key")
+	return app
 }
 
 func TestLoginUser_InvalidRequest(t *testing.T) {
@@ -41,16 +48,18 @@ func TestRegisterUser_InvalidRequest(t *testing.T) {
 
 func TestValidateJWT_ValidToken(t *testing.T) {
 	app := newAuthTestApp()
+	app.repo.(*mockRepository).users["user123"] = &db.User{ID: "user123", Username: "user123", Role: types.RoleUser}
 
-	token, _ := app.generateJWT("user123")
+	token, _ := app.generateJWT("user123", types.RoleUser)
 
 	req := httptest.NewRequest("GET", "/protected", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
 	w := httptest.NewRecorder()
 
 	handler := app.ValidateJWT(func(w http.ResponseWriter, r *http.Request) {
-		if r.Header.Get("User-ID") != "user123" {
-			t.Errorf("Expected User-ID 'user123', got '%s'", r.Header.Get("User-ID"))
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok || principal.UserID != "user123" || principal.AuthMethod != AuthMethodJWT || principal.TokenID == "" {
+			t.Errorf("Expected a JWT principal for user123, got %+v", principal)
 		}
 		w.WriteHeader(http.StatusOK)
 	})
@@ -97,7 +106,7 @@ func TestValidateJWT_MissingToken(t *testing.T) {
 func TestGenerateJWT(t *testing.T) {
 	app := newAuthTestApp()
 
-	token, err := app.generateJWT("user123")
+	token, err := app.generateJWT("user123", types.RoleUser)
 	if err != nil {
 		t.Fatalf("Failed to generate JWT: %v", err)
 	}
@@ -107,7 +116,7 @@ func TestGenerateJWT(t *testing.T) {
 
 	claims := &Claims{}
 	parsed, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (any, error) {
-		return []byte(app.jwtSecret), nil
+		return []byte("test-secret-key"), nil
 	})
 	if err != nil || !parsed.Valid {
 		t.Fatalf("Failed to parse JWT: %v", err)
@@ -142,3 +151,70 @@ func TestExtractToken(t *testing.T) {
 		})
 	}
 }
+
+func TestRegisterUser_ValidatesCredentials(t *testing.T) {
+	app := newTestApp(newMockRepository())
+
+	req := httptest.NewRequest("POST", "/register", bytes.NewBufferString(`{"username":"","password":"short"}`))
+	w := httptest.NewRecorder()
+
+	app.RegisterUser(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	var problem service.Problem
+	json.Unmarshal(w.Body.Bytes(), &problem)
+	if len(problem.Errors) != 2 {
+		t.Errorf("Expected username and password violations, got %+v", problem.Errors)
+	}
+}
+
+func TestRegisterUser_DuplicateUsername(t *testing.T) {
+	app, repo := newRBACApp()
+
+	w := postJSON(t, app, "/register", `{"username":"  ALICE ","password":"correct horse"}`, "")
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("Expected status %d, got %d", http.StatusConflict, w.Code)
+	}
+	var problem service.Problem
+	json.Unmarshal(w.Body.Bytes(), &problem)
+	if problem.Code != service.CodeUsernameTaken {
+		t.Errorf("Expected code %s, got %s", service.CodeUsernameTaken, problem.Code)
+	}
+	if len(repo.users) != 3 {
+		t.Errorf("Expected no user to be created, got %d users", len(repo.users))
+	}
+}
+
+func TestRegisterUser_NormalizesUsername(t *testing.T) {
+	app, repo := newRBACApp()
+
+	w := postJSON(t, app, "/register", `{"username":" Carol.Smith ","password":"correct horse"}`, "")
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	if _, err := repo.GetUserByUsername(context.Background(), "carol.smith"); err != nil {
+		t.Error("Expected the username to be stored normalized")
+	}
+	if w := attemptLogin(app, "CAROL.SMITH", "correct horse", "10.0.0.1:1234"); w.Code != http.StatusOK {
+		t.Errorf("Expected logins to ignore case, got %d", w.Code)
+	}
+}
+
+func TestRegisterUser_InvalidUsername(t *testing.T) {
+	app := newTestApp(newMockRepository())
+
+	w := postJSON(t, app, "/register", `{"username":"bad name!","password":"correct horse"}`, "")
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	var problem service.Problem
+	json.Unmarshal(w.Body.Bytes(), &problem)
+	if len(problem.Errors) != 1 || problem.Errors[0].Code != "invalid_username" {
+		t.Errorf("Expected an invalid_username violation, got %+v", problem.Errors)
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"crudl_service/src/db"
+	"crudl_service/src/service"
+	"errors"
+	"net/http"
+
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+// PostgreSQL error codes the API maps to client errors.
+const (
+	pqUniqueViolation = "23505"
+	pqCheckViolation  = "23514"
+)
+
+// StatusClientClosedRequest is the non-standard status of requests whose
+// client went away before the response, as used by nginx. It mostly shows up
+// in access logs, since nobody reads the response.
+const StatusClientClosedRequest = 499
+
+// ErrShutdown is the cancellation cause of requests still running when the
+// server gives up waiting for them on shutdown.
+var ErrShutdown = errors.New("server is shutting down")
+
+// writeProblem responds with a problem of the given status and code.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	service.WriteProblem(w, r, service.NewProblem(status, code, detail))
+}
+
+// writeError responds with the problem details matching err, see problemFor.
+func writeError(w http.ResponseWriter, r *http.Request, err error, detail string) {
+	service.WriteProblem(w, r, problemFor(err, detail))
+}
+
+// problemFor maps err to problem details:
+//   - problems are returned unchanged;
+//   - repository and constraint errors get their client status;
+//   - transactions that kept conflicting yield 409;
+//   - timeouts and shutdown yield 503, and a client that went away 499;
+//   - anything else is logged and reported as an internal error described
+//     by detail.
+func problemFor(err error, detail string) *service.Problem {
+	var problem *service.Problem
+	if errors.As(err, &problem) {
+		return problem
+	}
+	if errors.Is(err, db.ErrNotFound) {
+		return service.NewProblem(http.StatusNotFound, service.CodeNotFound, detail)
+	}
+	if errors.Is(err, db.ErrConflict) {
+		return service.NewProblem(http.StatusConflict, service.CodeConflict, "Resource already exists")
+	}
+	if errors.Is(err, db.ErrTxConflict) {
+		log.WithError(err).Warn(detail)
+		return service.NewProblem(http.StatusConflict, service.CodeConflict, "Resource was changed concurrently, try again")
+	}
+	if errors.Is(err, db.ErrTimeout) {
+		log.WithError(err).Warn(detail)
+		return service.NewProblem(http.StatusServiceUnavailable, service.CodeUnavailable, "The request took too long, try again later")
+	}
+	if errors.Is(err, db.ErrCanceled) {
+		if errors.Is(err, ErrShutdown) {
+			return service.NewProblem(http.StatusServiceUnavailable, service.CodeUnavailable, "Server is shutting down, try again later")
+		}
+		return service.NewProblem(StatusClientClosedRequest, service.CodeRequestCanceled, "Request was canceled by the client")
+	}
+	if errors.Is(err, db.ErrUnknownUser) {
+		return service.NewProblem(http.StatusNotFound, service.CodeNotFound, "User not found")
+	}
+	if errors.Is(err, db.ErrExchangeRateNotFound) {
+		return service.NewProblem(http.StatusUnprocessableEntity, service.CodeExchangeRateMissing, err.Error())
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case pqUniqueViolation:
+			return service.NewProblem(http.StatusConflict, service.CodeConflict, "Resource already exists")
+		case pqCheckViolation:
+			return service.NewProblem(http.StatusUnprocessableEntity, service.CodeValidationFailed,
+				"Value violates constraint "+pqErr.Constraint)
+		}
+	}
+	log.WithError(err).Error(detail)
+	return service.NewProblem(http.StatusInternalServerError, service.CodeInternal, detail)
+}
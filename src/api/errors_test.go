@@ -0,0 +1,64 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crudl_service/src/db"
+	"crudl_service/src/service"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestProblemFor(t *testing.T) {
+	custom := service.NewProblem(http.StatusTeapot, "teapot", "short and stout")
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"Problem passes through", fmt.Errorf("wrapped: %w", custom), http.StatusTeapot, "teapot"},
+		{"Not found sentinel", db.ErrNotFound, http.StatusNotFound, service.CodeNotFound},
+		{"Not found type", &db.NotFoundError{}, http.StatusNotFound, service.CodeNotFound},
+		{"Transaction conflict", fmt.Errorf("%w: %w", db.ErrTxConflict, errors.New("pq: could not serialize access")), http.StatusConflict, service.CodeConflict},
+		{"Timeout", fmt.Errorf("%w: %w", db.ErrTimeout, errors.New("pq: canceling statement")), http.StatusServiceUnavailable, service.CodeUnavailable},
+		{"Client gone", fmt.Errorf("%w: %w", db.ErrCanceled, context.Canceled), StatusClientClosedRequest, service.CodeRequestCanceled},
+		{"Shutdown", fmt.Errorf("%w: %w", db.ErrCanceled, ErrShutdown), http.StatusServiceUnavailable, service.CodeUnavailable},
+		{"Unknown user", db.ErrUnknownUser, http.StatusNotFound, service.CodeNotFound},
+		{"Missing rate", fmt.Errorf("%w: USD to EUR", db.ErrExchangeRateNotFound), http.StatusUnprocessableEntity, service.CodeExchangeRateMissing},
+		{"Conflict", &db.ConflictError{Constraint: "users_username_lower"}, http.StatusConflict, service.CodeConflict},
+		{"Unique violation", &pq.Error{Code: pqUniqueViolation}, http.StatusConflict, service.CodeConflict},
+		{"Check violation", &pq.Error{Code: pqCheckViolation, Constraint: "price_positive"}, http.StatusUnprocessableEntity, service.CodeValidationFailed},
+		{"Anything else", errors.New("boom"), http.StatusInternalServerError, service.CodeInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problem := problemFor(tt.err, "detail")
+			if problem.Status != tt.status || problem.Code != tt.code {
+				t.Errorf("Expected %d %s, got %d %s", tt.status, tt.code, problem.Status, problem.Code)
+			}
+		})
+	}
+}
+
+func TestSumUserSubscriptions_ClientGone(t *testing.T) {
+	app := newTestApp(newMockRepository())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequestWithContext(ctx, "POST", "/sum_subscriptions",
+		bytes.NewBufferString(`{"start_date":"2024-01-01","end_date":"2024-12-31"}`))
+	w := httptest.NewRecorder()
+
+	app.SumUserSubscriptions(w, withPrincipal(req, "user123"))
+
+	if w.Code != StatusClientClosedRequest {
+		t.Errorf("Expected status %d, got %d", StatusClientClosedRequest, w.Code)
+	}
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crudl_service/src/db"
+	"crudl_service/src/service"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newLoginApp returns an app with quick throttles and alice's password set
+// to "correct horse".
+func newLoginApp() (*App, *mockRepository) {
+	app, repo := newRBACApp()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	repo.users[aliceID].Password = string(hash)
+	app.userThrottle = service.LoginThrottle{FreeAttempts: 2, BaseDelay: time.Minute, MaxDelay: 4 * time.Minute, Window: time.Hour}
+	app.ipThrottle = service.LoginThrottle{FreeAttempts: 10, BaseDelay: time.Minute, MaxDelay: 4 * time.Minute, Window: time.Hour}
+	return app, repo
+}
+
+func attemptLogin(app *App, username, password, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/login", bytes.NewBufferString(
+		fmt.Sprintf(`{"username":%q,"password":%q}`, username, password)))
+	req.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	app.LoginUser(w, req)
+	return w
+}
+
+func TestLoginUser_Success(t *testing.T) {
+	app, repo := newLoginApp()
+	attemptLogin(app, "alice", "wrong password", "10.0.0.1:1234")
+
+	if w := attemptLogin(app, "alice", "correct horse", "10.0.0.1:1234"); w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if _, ok := repo.loginFailures["user:alice"]; ok {
+		t.Error("Expected a successful login to reset the username's failures")
+	}
+}
+
+func TestLoginUser_BackoffAndLockout(t *testing.T) {
+	app, repo := newLoginApp()
+
+	for i := 0; i < 2; i++ {
+		if w := attemptLogin(app, "alice", "wrong password", "10.0.0.1:1234"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+		}
+	}
+	if w := attemptLogin(app, "alice", "wrong password", "10.0.0.1:1234"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected the third failure to be rejected normally, got %d", w.Code)
+	}
+
+	w := attemptLogin(app, "ALICE", "correct horse", "10.0.0.2:1234")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status %d while locked, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if w.Header().Get("Retry-After") != "60" {
+		t.Errorf("Expected Retry-After 60, got %q", w.Header().Get("Retry-After"))
+	}
+	var problem service.Problem
+	json.Unmarshal(w.Body.Bytes(), &problem)
+	if problem.Code != service.CodeTooManyAttempts {
+		t.Errorf("Expected code %s, got %s", service.CodeTooManyAttempts, problem.Code)
+	}
+	if lockouts := repo.auditEventsOf(db.AuditLoginLockout); len(lockouts) != 0 {
+		t.Errorf("Expected no lockout before the maximum delay, got %+v", lockouts)
+	}
+	if failures := repo.auditEventsOf(db.AuditLoginFailed); len(failures) != 3 || failures[0].Username != "alice" || failures[0].UserID == "" {
+		t.Errorf("Expected every failed login to be audited, got %+v", failures)
+	}
+
+	for i := 0; i < 2; i++ {
+		repo.loginFailures["user:alice"].lockedUntil = time.Time{}
+		attemptLogin(app, "alice", "wrong password", "10.0.0.1:1234")
+	}
+	if got := time.Until(repo.loginFailures["user:alice"].lockedUntil); got < 3*time.Minute {
+		t.Errorf("Expected the delay to double up to the maximum, got %v", got)
+	}
+	if lockouts := repo.auditEventsOf(db.AuditLoginLockout); len(lockouts) != 1 || lockouts[0].Username != "alice" {
+		t.Errorf("Expected a lockout audit event, got %+v", lockouts)
+	}
+}
+
+func TestLoginUser_PerIPThrottle(t *testing.T) {
+	app, _ := newLoginApp()
+
+	for i := 0; i < 11; i++ {
+		attemptLogin(app, fmt.Sprintf("user%d", i), "wrong password", "10.0.0.1:1234")
+	}
+
+	if w := attemptLogin(app, "alice", "correct horse", "10.0.0.1:1234"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the address to be throttled, got %d", w.Code)
+	}
+	if w := attemptLogin(app, "alice", "correct horse", "10.0.0.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("Expected other addresses to log in, got %d", w.Code)
+	}
+}
+
+func TestLoginUser_UnknownUserIsThrottled(t *testing.T) {
+	app, repo := newLoginApp()
+
+	w := attemptLogin(app, "nobody", "wrong password", "10.0.0.1:1234")
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if repo.loginFailures["user:nobody"] == nil {
+		t.Error("Expected failures against unknown usernames to be counted")
+	}
+}
+
+func TestLoginUser_LookupFailureIsNotAFailedLogin(t *testing.T) {
+	app, repo := newLoginApp()
+	repo.usersErr = fmt.Errorf("%w: %w", db.ErrTimeout, context.DeadlineExceeded)
+
+	w := attemptLogin(app, "alice", "correct horse", "10.0.0.1:1234")
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d: %s", http.StatusServiceUnavailable, w.Code, w.Body.String())
+	}
+	if len(repo.loginFailures) != 0 || len(repo.auditEventsOf(db.AuditLoginFailed)) != 0 {
+		t.Errorf("Expected no failed login to be recorded, got %+v", repo.loginFailures)
+	}
+}
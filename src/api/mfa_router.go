@@ -0,0 +1,310 @@
+package api
+
+import (
+	"context"
+	"crudl_service/src/db"
+	"crudl_service/src/service"
+	"crudl_service/src/types"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// totpIssuer names the service in authenticator apps.
+	totpIssuer = "CRUDL Service"
+	// mfaChallengeTTL is how long the second step of a login may take.
+	mfaChallengeTTL = 5 * time.Minute
+	// recoveryCodeCount is how many recovery codes an enrollment yields.
+	recoveryCodeCount = 10
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCode returns a random code formatted as "xxxxx-xxxxx".
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+	return code[:5] + "-" + code[5:10], nil
+}
+
+// hashRecoveryCode hashes code ignoring case, spaces and dashes, so that it
+// may be typed as displayed or not.
+func hashRecoveryCode(code string) string {
+	code = strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+	return hashToken(code)
+}
+
+// startMFAChallenge answers a login whose password was accepted with a
+// challenge that /login/mfa exchanges for tokens given a valid code.
+func (a *App) startMFAChallenge(w http.ResponseWriter, r *http.Request, userID string) {
+	token, err := newOpaqueToken(32)
+	if err != nil {
+		writeError(w, r, err, "Token generation failed")
+		return
+	}
+	if err := a.repo.CreateMFAChallenge(r.Context(), userID, hashToken(token), time.Now().Add(mfaChallengeTTL)); err != nil {
+		writeError(w, r, err, "Login failed")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, r, http.StatusOK, types.MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int64(mfaChallengeTTL / time.Second),
+	})
+}
+
+// verifySecondFactor checks code, a TOTP code or an unused recovery code, for
+// user and uses it up.
+func (a *App) verifySecondFactor(ctx context.Context, user *db.User, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if step, ok := service.VerifyTOTP(user.TOTPSecret, code, time.Now()); ok {
+		return a.repo.UseTOTPStep(ctx, user.ID, step)
+	}
+	if len(code) == service.TOTPDigits {
+		return false, nil
+	}
+	return a.repo.UseRecoveryCode(ctx, user.ID, hashRecoveryCode(code))
+}
+
+// LoginMFA completes a two-step login. Wrong codes count against the login
+// throttle of the user and against the challenge, which stops working after
+// a few of them.
+func (a *App) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var request types.MFALoginRequest
+	if !service.ReadUserData(w, r, &request) {
+		return
+	}
+	challengeHash := hashToken(request.MFAToken)
+	userID, err := a.repo.GetMFAChallenge(r.Context(), challengeHash)
+	if errors.Is(err, db.ErrNotFound) {
+		writeProblem(w, r, http.StatusUnauthorized, service.CodeUnauthorized, "Invalid or expired MFA token")
+		return
+	}
+	if err != nil {
+		writeError(w, r, err, "Login failed")
+		return
+	}
+	user, err := a.repo.GetUser(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err, "Login failed")
+		return
+	}
+	ip := clientIP(r)
+	userKey, ipKey := userLoginKey(user.Username), "ip:"+ip
+	if a.loginLocked(w, r, userKey, ipKey) {
+		return
+	}
+	if user.DisabledAt != nil {
+		writeProblem(w, r, http.StatusForbidden, service.CodeAccountDisabled, "Account is disabled")
+		return
+	}
+
+	ok, err := a.verifySecondFactor(r.Context(), user, request.Code)
+	if err != nil {
+		writeError(w, r, err, "Login failed")
+		return
+	}
+	if !ok {
+		if err := a.repo.FailMFAChallenge(r.Context(), challengeHash); err != nil {
+			log.WithError(err).Warn("Failed to record MFA failure")
+		}
+		a.recordLoginFailure(r, user.ID, user.Username, userKey, ipKey)
+		writeProblem(w, r, http.StatusUnauthorized, service.CodeInvalidCredentials, "Invalid code")
+		return
+	}
+	if err := a.repo.CompleteMFAChallenge(r.Context(), challengeHash); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			writeProblem(w, r, http.StatusUnauthorized, service.CodeUnauthorized, "Invalid or expired MFA token")
+			return
+		}
+		writeError(w, r, err, "Login failed")
+		return
+	}
+	if err := a.repo.ClearLoginFailures(r.Context(), userKey); err != nil {
+		log.WithError(err).Warn("Failed to reset login failures")
+	}
+	if !a.auditLogin(w, r, user.ID) {
+		return
+	}
+
+	a.issueTokens(w, r, user.ID, user.Role, http.StatusOK)
+}
+
+// EnrollTOTP starts TOTP enrollment
+//
+//	@Summary		Enroll TOTP
+//	@Description	Generate a TOTP secret for the caller. It protects logins only after /me/mfa/totp/verify accepts a code. Requires a session token.
+//	@Tags			auth
+//	@Produce		json
+//	@Success		201	{object}	types.TOTPEnrollmentResponse	"Secret and provisioning URI"
+//	@Failure		401	{object}	service.Problem					"Unauthorized"
+//	@Failure		403	{object}	service.Problem					"Forbidden"
+//	@Failure		409	{object}	service.Problem					"TOTP already enabled"
+//	@Router			/me/mfa/totp [post]
+func (a *App) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+	user, err := a.repo.GetUser(r.Context(), principal.UserID)
+	if err != nil {
+		writeError(w, r, err, "Failed to enroll TOTP")
+		return
+	}
+	secret, err := service.NewTOTPSecret()
+	if err != nil {
+		writeError(w, r, err, "Failed to enroll TOTP")
+		return
+	}
+	err = a.repo.SetTOTPSecret(r.Context(), user.ID, secret)
+	if errors.Is(err, db.ErrNotFound) {
+		writeProblem(w, r, http.StatusConflict, service.CodeConflict, "TOTP is already enabled")
+		return
+	}
+	if err != nil {
+		writeError(w, r, err, "Failed to enroll TOTP")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, r, http.StatusCreated, types.TOTPEnrollmentResponse{
+		Secret:          secret,
+		ProvisioningURI: service.TOTPProvisioningURI(secret, totpIssuer, user.Username),
+	})
+}
+
+// VerifyTOTP activates TOTP
+//
+//	@Summary		Activate TOTP
+//	@Description	Confirm enrollment with a code from the authenticator app. Returns one-time recovery codes, which are shown only once. Requires a session token.
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			code	body		types.MFACodeRequest			true	"TOTP code"
+//	@Success		200		{object}	types.RecoveryCodesResponse		"Recovery codes"
+//	@Failure		400		{object}	service.Problem					"Bad request"
+//	@Failure		403		{object}	service.Problem					"Forbidden"
+//	@Failure		409		{object}	service.Problem					"Nothing to activate"
+//	@Router			/me/mfa/totp/verify [post]
+func (a *App) VerifyTOTP(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+	var request types.MFACodeRequest
+	if !service.ReadUserData(w, r, &request) {
+		return
+	}
+	user, err := a.repo.GetUser(r.Context(), principal.UserID)
+	if err != nil {
+		writeError(w, r, err, "Failed to activate TOTP")
+		return
+	}
+	if user.TOTPSecret == "" || user.TOTPEnabledAt != nil {
+		writeProblem(w, r, http.StatusConflict, service.CodeConflict, "No pending TOTP enrollment")
+		return
+	}
+	step, ok := service.VerifyTOTP(user.TOTPSecret, strings.TrimSpace(request.Code), time.Now())
+	if !ok {
+		service.WriteProblem(w, r, service.ValidationProblem(service.FieldError{
+			Field: "code", Code: "invalid_code", Message: "Code does not match",
+		}))
+		return
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		if codes[i], err = newRecoveryCode(); err != nil {
+			writeError(w, r, err, "Failed to activate TOTP")
+			return
+		}
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	err = a.repo.WithTx(r.Context(), func(tx db.Repository) error {
+		if err := tx.EnableTOTP(r.Context(), user.ID, step, hashes); err != nil {
+			return err
+		}
+		return tx.RecordAuditEvent(r.Context(), newAuditEvent(r, db.AuditMFAEnabled, user.ID))
+	})
+	if errors.Is(err, db.ErrNotFound) {
+		writeProblem(w, r, http.StatusConflict, service.CodeConflict, "No pending TOTP enrollment")
+		return
+	}
+	if err != nil {
+		writeError(w, r, err, "Failed to activate TOTP")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, r, http.StatusOK, types.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTOTP turns TOTP off
+//
+//	@Summary		Disable TOTP
+//	@Description	Turn off two-factor authentication, given a TOTP or recovery code. Requires a session token.
+//	@Tags			auth
+//	@Accept			json
+//	@Param			code	body	types.MFACodeRequest	true	"TOTP or recovery code"
+//	@Success		204		"TOTP disabled"
+//	@Failure		400		{object}	service.Problem	"Bad request"
+//	@Failure		403		{object}	service.Problem	"Forbidden"
+//	@Failure		409		{object}	service.Problem	"TOTP not enabled"
+//	@Failure		429		{object}	service.Problem	"Too many failed attempts"
+//	@Router			/me/mfa/totp [delete]
+func (a *App) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+	var request types.MFACodeRequest
+	if !service.ReadUserData(w, r, &request) {
+		return
+	}
+	user, err := a.repo.GetUser(r.Context(), principal.UserID)
+	if err != nil {
+		writeError(w, r, err, "Failed to disable TOTP")
+		return
+	}
+	if user.TOTPEnabledAt == nil {
+		writeProblem(w, r, http.StatusConflict, service.CodeConflict, "TOTP is not enabled")
+		return
+	}
+	ip := clientIP(r)
+	userKey, ipKey := userLoginKey(user.Username), "ip:"+ip
+	if a.loginLocked(w, r, userKey, ipKey) {
+		return
+	}
+	ok, err = a.verifySecondFactor(r.Context(), user, request.Code)
+	if err != nil {
+		writeError(w, r, err, "Failed to disable TOTP")
+		return
+	}
+	if !ok {
+		a.recordLoginFailure(r, user.ID, user.Username, userKey, ipKey)
+		service.WriteProblem(w, r, service.ValidationProblem(service.FieldError{
+			Field: "code", Code: "invalid_code", Message: "Code does not match",
+		}))
+		return
+	}
+	err = a.repo.WithTx(r.Context(), func(tx db.Repository) error {
+		if err := tx.DisableTOTP(r.Context(), user.ID); err != nil {
+			return err
+		}
+		return tx.RecordAuditEvent(r.Context(), newAuditEvent(r, db.AuditMFADisabled, user.ID))
+	})
+	if err != nil {
+		writeError(w, r, err, "Failed to disable TOTP")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
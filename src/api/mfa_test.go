@@ -0,0 +1,190 @@
+package api
+
+import (
+	"bytes"
+	"crudl_service/src/service"
+	"crudl_service/src/types"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// enrollTOTP enables TOTP for userID through the API and returns the secret
+// and recovery codes.
+func enrollTOTP(t *testing.T, app *App, userID string) (string, []string) {
+	t.Helper()
+	w := postJSON(t, app, "/me/mfa/totp", "", userID)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d on enrollment, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	var enrollment types.TOTPEnrollmentResponse
+	json.Unmarshal(w.Body.Bytes(), &enrollment)
+
+	code, _ := service.TOTPCode(enrollment.Secret, service.TOTPStep(time.Now()))
+	w = postJSON(t, app, "/me/mfa/totp/verify", fmt.Sprintf(`{"code":%q}`, code), userID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d on verification, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var recovery types.RecoveryCodesResponse
+	json.Unmarshal(w.Body.Bytes(), &recovery)
+	return enrollment.Secret, recovery.RecoveryCodes
+}
+
+// passwordStep logs alice in with her password and returns the MFA
+// challenge.
+func passwordStep(t *testing.T, app *App) types.MFAChallengeResponse {
+	t.Helper()
+	w := attemptLogin(app, "alice", "correct horse", "10.0.0.1:1234")
+	var challenge types.MFAChallengeResponse
+	json.Unmarshal(w.Body.Bytes(), &challenge)
+	if w.Code != http.StatusOK || !challenge.MFARequired || challenge.MFAToken == "" {
+		t.Fatalf("Expected an MFA challenge, got %d: %s", w.Code, w.Body.String())
+	}
+	return challenge
+}
+
+func mfaStep(t *testing.T, app *App, token, code string) *httptest.ResponseRecorder {
+	t.Helper()
+	body, _ := json.Marshal(types.MFALoginRequest{MFAToken: token, Code: code})
+	return postJSON(t, app, "/login/mfa", string(body), "")
+}
+
+func TestTOTPEnrollment(t *testing.T) {
+	app, repo := newLoginApp()
+
+	secret, codes := enrollTOTP(t, app, aliceID)
+
+	if repo.users[aliceID].TOTPEnabledAt == nil || repo.users[aliceID].TOTPSecret != secret {
+		t.Error("Expected TOTP to be enabled")
+	}
+	if len(codes) != recoveryCodeCount || len(repo.recoveryCodes[aliceID]) != recoveryCodeCount {
+		t.Errorf("Expected %d recovery codes, got %v", recoveryCodeCount, codes)
+	}
+	if _, ok := repo.recoveryCodes[aliceID][codes[0]]; ok {
+		t.Error("Expected recovery codes to be stored hashed")
+	}
+	if w := postJSON(t, app, "/me/mfa/totp", "", aliceID); w.Code != http.StatusConflict {
+		t.Errorf("Expected re-enrollment to conflict, got %d", w.Code)
+	}
+}
+
+func TestTOTPEnrollment_WrongCode(t *testing.T) {
+	app, repo := newLoginApp()
+	postJSON(t, app, "/me/mfa/totp", "", aliceID)
+
+	w := postJSON(t, app, "/me/mfa/totp/verify", `{"code":"000000"}`, aliceID)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if repo.users[aliceID].TOTPEnabledAt != nil {
+		t.Error("Expected TOTP to stay disabled")
+	}
+	if w := attemptLogin(app, "alice", "correct horse", "10.0.0.1:1234"); w.Code != http.StatusOK || !authorizedResponse(app, w) {
+		t.Error("Expected a pending enrollment not to affect logins")
+	}
+}
+
+// authorizedResponse reports whether w carries a usable access token.
+func authorizedResponse(app *App, w *httptest.ResponseRecorder) bool {
+	var response types.AuthResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return response.Token != "" && authorized(app, response.Token)
+}
+
+func TestLoginMFA_TOTP(t *testing.T) {
+	app, repo := newLoginApp()
+	secret, _ := enrollTOTP(t, app, aliceID)
+	repo.totpSteps[aliceID] = 0
+
+	challenge := passwordStep(t, app)
+	code, _ := service.TOTPCode(secret, service.TOTPStep(time.Now()))
+	w := mfaStep(t, app, challenge.MFAToken, code)
+
+	if w.Code != http.StatusOK || !authorizedResponse(app, w) {
+		t.Fatalf("Expected tokens, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := mfaStep(t, app, challenge.MFAToken, code); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the challenge to be single-use, got %d", w.Code)
+	}
+
+	challenge = passwordStep(t, app)
+	if w := mfaStep(t, app, challenge.MFAToken, code); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a replayed code to be rejected, got %d", w.Code)
+	}
+}
+
+func TestLoginMFA_RecoveryCode(t *testing.T) {
+	app, _ := newLoginApp()
+	_, codes := enrollTOTP(t, app, aliceID)
+
+	challenge := passwordStep(t, app)
+	if w := mfaStep(t, app, challenge.MFAToken, " "+codes[0]+" "); w.Code != http.StatusOK {
+		t.Fatalf("Expected a recovery code to be accepted, got %d", w.Code)
+	}
+
+	challenge = passwordStep(t, app)
+	if w := mfaStep(t, app, challenge.MFAToken, codes[0]); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a used recovery code to be rejected, got %d", w.Code)
+	}
+}
+
+func TestLoginMFA_ChallengeExhausted(t *testing.T) {
+	app, repo := newLoginApp()
+	app.userThrottle.FreeAttempts = 100
+	secret, _ := enrollTOTP(t, app, aliceID)
+	repo.totpSteps[aliceID] = 0
+
+	challenge := passwordStep(t, app)
+	for i := 0; i < 5; i++ {
+		if w := mfaStep(t, app, challenge.MFAToken, "000000"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+		}
+	}
+	code, _ := service.TOTPCode(secret, service.TOTPStep(time.Now()))
+	if w := mfaStep(t, app, challenge.MFAToken, code); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the challenge to stop working, got %d", w.Code)
+	}
+	if repo.loginFailures["user:alice"].failures != 5 {
+		t.Errorf("Expected wrong codes to count as login failures, got %d", repo.loginFailures["user:alice"].failures)
+	}
+}
+
+func TestLoginMFA_ChallengeIsNotAnAccessToken(t *testing.T) {
+	app, _ := newLoginApp()
+	enrollTOTP(t, app, aliceID)
+
+	challenge := passwordStep(t, app)
+
+	if authorized(app, challenge.MFAToken) {
+		t.Error("Expected the MFA token not to authenticate requests")
+	}
+}
+
+func TestDisableTOTP(t *testing.T) {
+	app, repo := newLoginApp()
+	_, codes := enrollTOTP(t, app, aliceID)
+
+	req := asUser(t, app, httptest.NewRequest("DELETE", "/me/mfa/totp", bytes.NewBufferString(`{"code":"000000"}`)), aliceID)
+	w := httptest.NewRecorder()
+	app.Router().ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected a wrong code to be rejected, got %d", w.Code)
+	}
+
+	req = asUser(t, app, httptest.NewRequest("DELETE", "/me/mfa/totp", bytes.NewBufferString(fmt.Sprintf(`{"code":%q}`, codes[1]))), aliceID)
+	w = httptest.NewRecorder()
+	app.Router().ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if repo.users[aliceID].TOTPEnabledAt != nil {
+		t.Error("Expected TOTP to be disabled")
+	}
+	if w := attemptLogin(app, "alice", "correct horse", "10.0.0.1:1234"); !authorizedResponse(app, w) {
+		t.Error("Expected the password alone to log in again")
+	}
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"crudl_service/src/db"
+	"crudl_service/src/notify"
+	"crudl_service/src/service"
+	"crudl_service/src/types"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// checkPassword returns a request check of the new password at *password
+// against the password policy, reported under field.
+func (a *App) checkPassword(field string, password *string) service.Check {
+	return func() error {
+		if *password == "" {
+			return nil
+		}
+		return a.passwords.Check(field, *password)
+	}
+}
+
+// ChangePassword changes the caller's password
+//
+//	@Summary		Change password
+//	@Description	Replace the caller's password, given the current one. Every session of the user ends, including the current one. Requires a session token.
+//	@Tags			auth
+//	@Accept			json
+//	@Param			password	body	types.ChangePasswordRequest	true	"Current and new password"
+//	@Success		204			"Password changed"
+//	@Failure		400			{object}	service.Problem	"Bad request"
+//	@Failure		401			{object}	service.Problem	"Unauthorized"
+//	@Failure		403			{object}	service.Problem	"Wrong current password"
+//	@Failure		429			{object}	service.Problem	"Too many failed attempts"
+//	@Router			/me/password [post]
+func (a *App) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+	var request types.ChangePasswordRequest
+	if !service.ReadUserData(w, r, &request, a.checkPassword("new_password", &request.NewPassword)) {
+		return
+	}
+	user, err := a.repo.GetUser(r.Context(), principal.UserID)
+	if err != nil {
+		writeError(w, r, err, "Failed to change password")
+		return
+	}
+	ip := clientIP(r)
+	userKey, ipKey := userLoginKey(user.Username), "ip:"+ip
+	if a.loginLocked(w, r, userKey, ipKey) {
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(request.CurrentPassword)) != nil {
+		a.recordLoginFailure(r, user.ID, user.Username, userKey, ipKey)
+		writeProblem(w, r, http.StatusForbidden, service.CodeInvalidCredentials, "Current password is incorrect")
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		writeError(w, r, err, "Password hashing failed")
+		return
+	}
+	err = a.repo.WithTx(r.Context(), func(tx db.Repository) error {
+		if err := tx.UpdatePassword(r.Context(), user.ID, string(hashedPassword)); err != nil {
+			return err
+		}
+		if err := tx.RevokeUserTokens(r.Context(), user.ID); err != nil {
+			return err
+		}
+		return tx.RecordAuditEvent(r.Context(), newAuditEvent(r, db.AuditPasswordChanged, user.ID))
+	})
+	if err != nil {
+		writeError(w, r, err, "Failed to change password")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RequestPasswordReset sends a password reset token
+//
+//	@Summary		Request password reset
+//	@Description	Send a single-use password reset token to the user through the configured notifier. The response is the same whether or not the user exists.
+//	@Tags			auth
+//	@Accept			json
+//	@Param			request	body	types.PasswordResetRequest	true	"Username"
+//	@Success		202		"Reset requested"
+//	@Failure		400		{object}	service.Problem	"Bad request"
+//	@Router			/password/reset/request [post]
+func (a *App) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var request types.PasswordResetRequest
+	if !service.ReadUserData(w, r, &request) {
+		return
+	}
+	if err := a.sendResetToken(r.Context(), request.Username); err != nil {
+		log.WithError(err).Error("Failed to send password reset token")
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// sendResetToken issues a reset token for username and delivers it. Unknown
+// and disabled users are silently skipped.
+func (a *App) sendResetToken(ctx context.Context, username string) error {
+	user, err := a.repo.GetUserByUsername(ctx, username)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.DisabledAt != nil {
+		return nil
+	}
+	token, err := newOpaqueToken(32)
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(a.resetTTL)
+	if err := a.repo.CreatePasswordResetToken(ctx, user.ID, hashToken(token), expiresAt); err != nil {
+		return err
+	}
+	return a.notifier.Notify(notify.Message{
+		To:      user.Username,
+		Subject: "Password reset",
+		Body: fmt.Sprintf("Send this token with a new password to POST /password/reset before %s: %s",
+			expiresAt.UTC().Format(time.RFC3339), token),
+	})
+}
+
+// ResetPassword sets a new password with a reset token
+//
+//	@Summary		Reset password
+//	@Description	Set a new password using a token from /password/reset/request. The token is single-use, and every session of the user ends.
+//	@Tags			auth
+//	@Accept			json
+//	@Param			reset	body	types.PasswordResetConfirmRequest	true	"Reset token and new password"
+//	@Success		204		"Password reset"
+//	@Failure		400		{object}	service.Problem	"Bad request or invalid token"
+//	@Router			/password/reset [post]
+func (a *App) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var request types.PasswordResetConfirmRequest
+	if !service.ReadUserData(w, r, &request, a.checkPassword("new_password", &request.NewPassword)) {
+		return
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		writeError(w, r, err, "Password hashing failed")
+		return
+	}
+	err = a.repo.WithTx(r.Context(), func(tx db.Repository) error {
+		userID, err := tx.ConsumePasswordResetToken(r.Context(), hashToken(request.Token), string(hashedPassword))
+		if errors.Is(err, db.ErrNotFound) {
+			return service.ValidationProblem(service.FieldError{
+				Field: "token", Code: "invalid_token", Message: "Reset token is invalid, expired or already used",
+			})
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.RevokeUserTokens(r.Context(), userID); err != nil {
+			return err
+		}
+		// Whoever holds the reset token acts as the user.
+		audit := newAuditEvent(r, db.AuditPasswordReset, userID)
+		audit.ActorID = userID
+		return tx.RecordAuditEvent(r.Context(), audit)
+	})
+	if err != nil {
+		writeError(w, r, err, "Failed to reset password")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"bytes"
+	"crudl_service/src/notify"
+	"crudl_service/src/service"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// recordingNotifier keeps the messages it is asked to deliver.
+type recordingNotifier struct {
+	messages []notify.Message
+}
+
+func (n *recordingNotifier) Notify(msg notify.Message) error {
+	n.messages = append(n.messages, msg)
+	return nil
+}
+
+func postJSON(t *testing.T, app *App, path, body, userID string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("POST", path, bytes.NewBufferString(body))
+	if userID != "" {
+		req = asUser(t, app, req, userID)
+	}
+	w := httptest.NewRecorder()
+	app.Router().ServeHTTP(w, req)
+	return w
+}
+
+func passwordMatches(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func TestRegisterUser_PasswordPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		code     string
+	}{
+		{"Breached", "Password123", "breached_password"},
+		{"Over 72 bytes", strings.Repeat("я", 40), "too_long"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(newMockRepository())
+			body, _ := json.Marshal(map[string]string{"username": "alice", "password": tt.password})
+			w := postJSON(t, app, "/register", string(body), "")
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+			}
+			var problem service.Problem
+			json.Unmarshal(w.Body.Bytes(), &problem)
+			if len(problem.Errors) != 1 || problem.Errors[0].Code != tt.code {
+				t.Errorf("Expected %s, got %+v", tt.code, problem.Errors)
+			}
+		})
+	}
+}
+
+func TestPasswordLength_CountsBytes(t *testing.T) {
+	app := newTestApp(newMockRepository())
+	password := strings.Repeat("я", 30)
+	body, _ := json.Marshal(map[string]string{"username": "alice", "password": password})
+	if w := postJSON(t, app, "/register", string(body), ""); w.Code != http.StatusCreated {
+		t.Fatalf("Expected a 60-byte password to be accepted, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := postJSON(t, app, "/login", string(body), ""); w.Code != http.StatusOK {
+		t.Errorf("Expected to log in with the 60-byte password, got %d: %s", w.Code, w.Body.String())
+	}
+
+	body, _ = json.Marshal(map[string]string{"username": "alice", "password": strings.Repeat("я", 40)})
+	w := postJSON(t, app, "/login", string(body), "")
+	var problem service.Problem
+	json.Unmarshal(w.Body.Bytes(), &problem)
+	if w.Code != http.StatusBadRequest || len(problem.Errors) != 1 || problem.Errors[0].Code != "too_long" {
+		t.Errorf("Expected a 40-character, 80-byte password to be too long, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	app, repo := newLoginApp()
+	session := login(t, app, aliceID)
+
+	w := postJSON(t, app, "/me/password", `{"current_password":"correct horse","new_password":"battery staple"}`, aliceID)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+	if !passwordMatches(repo.users[aliceID].Password, "battery staple") {
+		t.Error("Expected the new password to be stored")
+	}
+	if authorized(app, session.Token) {
+		t.Error("Expected existing sessions to end")
+	}
+}
+
+func TestChangePassword_WrongCurrentPassword(t *testing.T) {
+	app, repo := newLoginApp()
+
+	w := postJSON(t, app, "/me/password", `{"current_password":"wrong","new_password":"battery staple"}`, aliceID)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+	if !passwordMatches(repo.users[aliceID].Password, "correct horse") {
+		t.Error("Expected the password to stay unchanged")
+	}
+	if repo.loginFailures["user:alice"] == nil {
+		t.Error("Expected the failure to count against the login throttle")
+	}
+}
+
+func TestChangePassword_RequiresSession(t *testing.T) {
+	app, _ := newLoginApp()
+	key := createAPIKey(t, app, aliceID, "read_write")
+
+	req := withAPIKey(httptest.NewRequest("POST", "/me/password",
+		bytes.NewBufferString(`{"current_password":"correct horse","new_password":"battery staple"}`)), key.Key)
+	w := httptest.NewRecorder()
+	app.Router().ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	app, repo := newLoginApp()
+	notifier := app.notifier.(*recordingNotifier)
+	session := login(t, app, aliceID)
+
+	if w := postJSON(t, app, "/password/reset/request", `{"username":"alice"}`, ""); w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d", http.StatusAccepted, w.Code)
+	}
+	if len(notifier.messages) != 1 || notifier.messages[0].To != "alice" {
+		t.Fatalf("Expected a message to alice, got %+v", notifier.messages)
+	}
+	body := notifier.messages[0].Body
+	token := body[strings.LastIndex(body, " ")+1:]
+
+	confirm, _ := json.Marshal(map[string]string{"token": token, "new_password": "battery staple"})
+	if w := postJSON(t, app, "/password/reset", string(confirm), ""); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+	if !passwordMatches(repo.users[aliceID].Password, "battery staple") {
+		t.Error("Expected the new password to be stored")
+	}
+	if authorized(app, session.Token) {
+		t.Error("Expected existing sessions to end")
+	}
+
+	if w := postJSON(t, app, "/password/reset", string(confirm), ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a used token to be rejected, got %d", w.Code)
+	}
+}
+
+func TestPasswordReset_ExpiredToken(t *testing.T) {
+	app, repo := newLoginApp()
+	repo.resetTokens[hashToken("expired")] = &mockResetToken{userID: aliceID, expiresAt: time.Now().Add(-time.Minute)}
+
+	w := postJSON(t, app, "/password/reset", `{"token":"expired","new_password":"battery staple"}`, "")
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if !passwordMatches(repo.users[aliceID].Password, "correct horse") {
+		t.Error("Expected the password to stay unchanged")
+	}
+}
+
+func TestPasswordReset_UnknownUser(t *testing.T) {
+	app, _ := newLoginApp()
+
+	w := postJSON(t, app, "/password/reset/request", `{"username":"nobody"}`, "")
+
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Expected status %d, got %d", http.StatusAccepted, w.Code)
+	}
+	if messages := app.notifier.(*recordingNotifier).messages; len(messages) != 0 {
+		t.Errorf("Expected no message, got %+v", messages)
+	}
+}
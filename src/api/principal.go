@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"crudl_service/src/service"
+	"crudl_service/src/types"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Authentication methods recorded on a Principal.
+const (
+	AuthMethodJWT    = "jwt"
+	AuthMethodAPIKey = "api_key"
+)
+
+// Principal is the authenticated caller of a request. Authentication
+// middleware stores it in the request context; handlers must read identity
+// from it and never from request headers, which the client controls.
+type Principal struct {
+	UserID string
+	Roles  []string
+	// TokenID is the jti of the access token, and TokenExpiresAt its expiry.
+	TokenID        string
+	TokenExpiresAt time.Time
+	AuthMethod     string
+	// Scope is types.APIKeyScopeRead or types.APIKeyScopeReadWrite. Sessions
+	// always have read_write scope; API keys have the scope they were
+	// created with.
+	Scope string
+}
+
+// HasRole reports whether the principal was granted role.
+func (p *Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+type principalKey struct{}
+
+// ContextWithPrincipal returns a copy of ctx carrying p.
+func ContextWithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal stored in ctx, if any.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// requirePrincipal returns the caller of r, answering 401 itself when the
+// request was not authenticated, e.g. because a handler was mounted without
+// authentication middleware.
+func requirePrincipal(w http.ResponseWriter, r *http.Request) (*Principal, bool) {
+	p, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeProblem(w, r, http.StatusUnauthorized, service.CodeUnauthorized, "Authentication required")
+		return nil, false
+	}
+	return p, true
+}
+
+// requireSession returns the caller of r like requirePrincipal, but answers
+// 403 when the request was authenticated with an API key rather than a
+// session token.
+func requireSession(w http.ResponseWriter, r *http.Request) (*Principal, bool) {
+	p, ok := requirePrincipal(w, r)
+	if !ok {
+		return nil, false
+	}
+	if p.AuthMethod != AuthMethodJWT {
+		writeProblem(w, r, http.StatusForbidden, service.CodeForbidden, "This endpoint requires a session token")
+		return nil, false
+	}
+	return p, true
+}
+
+// isAdmin reports whether the request was authenticated with the admin role.
+func isAdmin(r *http.Request) bool {
+	p, ok := PrincipalFromContext(r.Context())
+	return ok && p.HasRole(types.RoleAdmin)
+}
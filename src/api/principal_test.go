@@ -0,0 +1,153 @@
+package api
+
+import (
+	"bytes"
+	"crudl_service/src/types"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// protectedRoutes lists every endpoint that requires a user token, with a
+// body valid enough to get past request validation.
+var protectedRoutes = []struct {
+	method, path, body string
+}{
+	{"POST", "/logout", `{"refresh_token":"x"}`},
+	{"POST", "/logout-all", ``},
+	{"GET", "/me", ``},
+	{"PATCH", "/me", `{"display_name":"Admin"}`},
+	{"DELETE", "/me", `{"password":"x","subscriptions":"delete"}`},
+	{"GET", "/me/export", ``},
+	{"POST", "/subscription", `{"service_name":"Netflix","price":999,"start_date":"2024-01-01"}`},
+	{"GET", "/subscription/1", ``},
+	{"PUT", "/subscription/1", `{"service_name":"Netflix","price":999,"start_date":"2024-01-01"}`},
+	{"PATCH", "/subscription/1", `{"price":1}`},
+	{"DELETE", "/subscription/1", ``},
+	{"POST", "/subscription/1/restore", ``},
+	{"GET", "/subscriptionList", ``},
+	{"GET", "/trash", ``},
+	{"GET", "/audit", ``},
+	{"POST", "/sum_subscriptions", `{"start_date":"2024-01-01","end_date":"2024-12-31"}`},
+	{"PUT", "/admin/exchange_rates/03-2024", `{"rates":[{"base_currency":"USD","quote_currency":"RUB","rate":92.5}]}`},
+	{"GET", "/admin/exchange_rates/03-2024", ``},
+	{"GET", "/admin/users", ``},
+	{"PATCH", "/admin/users/" + aliceID, `{"disabled":true}`},
+	{"GET", "/admin/users/" + aliceID + "/subscriptions", ``},
+	{"GET", "/admin/audit", ``},
+}
+
+func forgedRequest(method, path, body string) *http.Request {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-ID", adminID)
+	req.Header.Set("User-Role", types.RoleAdmin)
+	return req
+}
+
+func TestForgedIdentityHeaders_WithoutToken(t *testing.T) {
+	app, repo := newRBACApp()
+	seedSubscription(repo, 1, adminID)
+	router := app.Router()
+
+	for _, route := range protectedRoutes {
+		t.Run(route.method+" "+route.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, forgedRequest(route.method, route.path, route.body))
+
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+			}
+		})
+	}
+}
+
+func TestForgedIdentityHeaders_WithUserToken(t *testing.T) {
+	for _, route := range protectedRoutes {
+		t.Run(route.method+" "+route.path, func(t *testing.T) {
+			app, repo := newRBACApp()
+			seedSubscription(repo, 1, adminID)
+
+			w := httptest.NewRecorder()
+			app.Router().ServeHTTP(w, asUser(t, app, forgedRequest(route.method, route.path, route.body), aliceID))
+
+			switch route.path {
+			case "/subscription/1", "/admin/users", "/admin/users/" + aliceID, "/admin/users/" + aliceID + "/subscriptions", "/admin/audit",
+				"/admin/exchange_rates/03-2024":
+				if w.Code != http.StatusForbidden {
+					t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+				}
+			}
+			if sub, ok := repo.subscriptions[1]; !ok || sub.Price != 999 || sub.DeletedAt != nil || repo.users[adminID] == nil || repo.users[aliceID].DisabledAt != nil {
+				t.Error("Expected forged headers not to grant access to other users' data")
+			}
+			if repo.validAfter[adminID].After(time.Now()) {
+				t.Error("Expected forged headers not to sign out other users")
+			}
+			for _, sub := range repo.subscriptions {
+				if sub.Id != 1 && sub.UserId != aliceID {
+					t.Errorf("Expected subscriptions to be created for the token's user, got %s", sub.UserId)
+				}
+			}
+		})
+	}
+}
+
+func TestForgedIdentityHeaders_ListAndSum(t *testing.T) {
+	app, repo := newRBACApp()
+	seedSubscription(repo, 1, adminID)
+	seedSubscription(repo, 2, aliceID)
+	router := app.Router()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, asUser(t, app, forgedRequest("GET", "/subscriptionList", ""), aliceID))
+	var page types.SubscriptionListResponse
+	json.Unmarshal(w.Body.Bytes(), &page)
+	if len(page.Data) != 1 || page.Data[0].Id != 2 {
+		t.Errorf("Expected only the token user's subscription, got %+v", page.Data)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, asUser(t, app, forgedRequest("POST", "/sum_subscriptions", `{"start_date":"2024-01-01","end_date":"2024-12-31"}`), aliceID))
+	var sum types.UserSubscriptionSumResponse
+	json.Unmarshal(w.Body.Bytes(), &sum)
+	if sum.UserId != aliceID {
+		t.Errorf("Expected the sum of the token user, got %s", sum.UserId)
+	}
+}
+
+func TestHandlersWithoutMiddleware_RejectForgedHeader(t *testing.T) {
+	app, repo := newRBACApp()
+	seedSubscription(repo, 1, adminID)
+	handlers := map[string]http.HandlerFunc{
+		"CreateSubscription":   app.CreateSubscription,
+		"ReadSubscription":     app.ReadSubscription,
+		"UpdateSubscription":   app.UpdateSubscription,
+		"DeleteSubscription":   app.DeleteSubscription,
+		"RestoreSubscription":  app.RestoreSubscription,
+		"ListSubscription":     app.ListSubscription,
+		"ListTrash":            app.ListTrash,
+		"ListAuditEvents":      app.ListAuditEvents,
+		"SumUserSubscriptions": app.SumUserSubscriptions,
+		"Logout":               app.Logout,
+		"LogoutAll":            app.LogoutAll,
+		"UpdateUserAccess":     app.UpdateUserAccess,
+	}
+
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			req := withIDParam(forgedRequest("POST", "/", `{}`), "1")
+			w := httptest.NewRecorder()
+			handler(w, req)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+			}
+		})
+	}
+	if sub, ok := repo.subscriptions[1]; !ok || sub.DeletedAt != nil {
+		t.Error("Expected the subscription to survive")
+	}
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+)
+
+// requestIDHeader carries the ID of a request in both directions.
+const requestIDHeader = "X-Request-ID"
+
+// requestIDPattern bounds the request IDs accepted from clients and proxies.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+type requestIDKey struct{}
+
+// RequestID tags every request with an ID, taken from the X-Request-ID header
+// when it holds a usable one and generated otherwise. The ID is echoed in the
+// response and recorded in audit events.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			// crypto/rand does not fail on supported platforms.
+			id, _ = newOpaqueToken(16)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// RequestIDFromContext returns the request ID stored in ctx by RequestID, or
+// an empty string.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -1,27 +1,79 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"crudl_service/src/config"
 	"crudl_service/src/db"
+	"crudl_service/src/notify"
 	"crudl_service/src/service"
 	"crudl_service/src/types"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
-const dateFormat = "01-2006"
+const (
+	// defaultListLimit is the page size used when the client sends no limit.
+	defaultListLimit = 20
+	// maxListLimit caps the page size a client may request.
+	maxListLimit = 100
+)
 
 // App holds all application dependencies.
 type App struct {
-	repo      db.Repository
-	jwtSecret string
+	repo       db.Repository
+	keys       *service.KeySet
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	// userThrottle and ipThrottle slow down failed logins per username and
+	// per client address.
+	userThrottle service.LoginThrottle
+	ipThrottle   service.LoginThrottle
+	passwords    *service.PasswordPolicy
+	// notifier delivers password reset tokens, which expire after resetTTL.
+	notifier notify.Notifier
+	resetTTL time.Duration
+	// requireIfMatch rejects subscription writes without an If-Match header.
+	requireIfMatch bool
 }
 
-func NewApp(repo db.Repository, jwtSecret string) *App {
-	return &App{repo: repo, jwtSecret: jwtSecret}
+// NewApp wires the handlers to repo, loading the JWT keys configured in cfg.
+func NewApp(repo db.Repository, cfg *config.Config) (*App, error) {
+	keys, err := service.LoadKeySet(cfg.JWT)
+	if err != nil {
+		return nil, err
+	}
+	passwords, err := service.LoadPasswordPolicy(cfg.Password)
+	if err != nil {
+		return nil, err
+	}
+	notifier, err := notify.New(cfg.Notify)
+	if err != nil {
+		return nil, err
+	}
+	return &App{
+		repo:         repo,
+		keys:         keys,
+		accessTTL:    cfg.JWT.AccessTokenTTL,
+		refreshTTL:   cfg.JWT.RefreshTokenTTL,
+		userThrottle: service.UserLoginThrottle,
+		ipThrottle:   service.IPLoginThrottle,
+		passwords:    passwords,
+		notifier:     notifier,
+		resetTTL:     cfg.Password.ResetTokenTTL,
+
+		requireIfMatch: cfg.Server.IfMatch == "required",
+	}, nil
 }
 
 // CreateSubscription creates a new subscription
@@ -33,143 +85,391 @@ func NewApp(repo db.Repository, jwtSecret string) *App {
 //	@Produce		json
 //	@Param			subscription	body		types.UserSubscription				true	"Subscription data"
 //	@Success		201				{object}	types.CreateSubscriptionResponse	"Subscription created"
-//	@Failure		400				{object}	string								"Bad request"
-//	@Failure		500				{object}	string								"Internal server error"
+//	@Failure		400				{object}	service.Problem						"Bad request"
+//	@Failure		500				{object}	service.Problem						"Internal server error"
 //	@Router			/subscription [post]
 func (a *App) CreateSubscription(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
 	var request types.UserSubscription
 	if !service.ReadUserData(w, r, &request) {
 		return
 	}
-	request.UserId = r.Header.Get("User-ID")
+	request.UserId = principal.UserID
 
-	if request.StartDate == nil {
-		http.Error(w, "Start date is required", http.StatusBadRequest)
+	if err := normalizeSubscription(&request); err != nil {
+		writeError(w, r, err, "Invalid subscription")
 		return
 	}
-	if _, err := time.Parse(dateFormat, *request.StartDate); err != nil {
-		http.Error(w, "Incorrect time format, expected MM-YYYY", http.StatusBadRequest)
-		return
-	}
-	if request.EndDate != nil {
-		if _, err := time.Parse(dateFormat, *request.EndDate); err != nil {
-			http.Error(w, "Incorrect time format, expected MM-YYYY", http.StatusBadRequest)
-			return
-		}
-	}
 
-	id, err := a.repo.Create(&request)
+	var id int64
+	var created *types.UserSubscription
+	err := a.repo.WithTx(r.Context(), func(tx db.Repository) error {
+		var err error
+		if id, err = tx.Create(r.Context(), &request); err != nil {
+			return err
+		}
+		if created, err = tx.Get(r.Context(), id); err != nil {
+			return err
+		}
+		return auditSubscription(tx, r, db.AuditSubscriptionCreated, nil, created)
+	})
 	if err != nil {
-		log.WithError(err).Error("Failed to create subscription")
-		http.Error(w, "Failed to create subscription", http.StatusInternalServerError)
+		writeError(w, r, err, "Failed to create subscription")
 		return
 	}
 
 	body, err := json.Marshal(types.CreateSubscriptionResponse{Result: "ok", SubscriptionId: id})
 	if err != nil {
-		log.WithError(err).Error("Failed to marshal response")
-		http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
+		writeError(w, r, err, "Failed to marshal response")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", service.ETag(created.Version))
 	w.WriteHeader(http.StatusCreated)
 	w.Write(body)
 }
 
+// normalizeSubscription rewrites the dates, currency and billing period of a
+// validated sub into their canonical form. A MM-YYYY end date is stored as the
+// last day of its month.
+func normalizeSubscription(sub *types.UserSubscription) error {
+	if err := service.NormalizeDate(sub.StartDate, service.ParseDate); err != nil {
+		return err
+	}
+	if err := service.NormalizeDate(sub.EndDate, service.ParsePeriodEnd); err != nil {
+		return err
+	}
+	if err := service.NormalizeDate(sub.BillingAnchor, service.ParseDate); err != nil {
+		return err
+	}
+	sub.Currency, _ = service.NormalizeCurrency(sub.Currency)
+	period, err := service.NormalizeBillingPeriod(sub.BillingPeriod, sub.BillingIntervalMonths)
+	if err != nil {
+		return err
+	}
+	sub.BillingPeriod = period
+	return nil
+}
+
 // ReadSubscription gets a subscription by ID
 //
 //	@Summary		Get subscription
 //	@Description	Get subscription by ID
 //	@Tags			subscriptions
 //	@Produce		json
-//	@Param			id	path		int						true	"Subscription ID"
-//	@Success		200	{object}	types.UserSubscription	"Subscription data"
-//	@Failure		400	{object}	string					"Bad request"
-//	@Failure		403	{object}	string					"Forbidden"
-//	@Failure		404	{object}	string					"Not found"
+//	@Param			id				path		int						true	"Subscription ID"
+//	@Param			If-None-Match	header		string					false	"ETag of a cached copy"
+//	@Success		200				{object}	types.UserSubscription	"Subscription data"
+//	@Header			200				{string}	ETag					"Current version of the subscription"
+//	@Success		304				"Cached copy is current"
+//	@Failure		400				{object}	service.Problem	"Bad request"
+//	@Failure		403				{object}	service.Problem	"Forbidden"
+//	@Failure		404				{object}	service.Problem	"Not found"
 //	@Router			/subscription/{id} [get]
 func (a *App) ReadSubscription(w http.ResponseWriter, r *http.Request) {
-	id, err := service.GetIDRequest(r)
-	if err != nil {
-		http.Error(w, "Failed to parse ID", http.StatusBadRequest)
+	principal, id, ok := subscriptionRequest(w, r)
+	if !ok {
 		return
 	}
-	sub, err := a.repo.Get(id)
+	// A single statement loads the subscription and the owner checked below.
+	sub, err := ownedSubscription(r.Context(), a.repo, principal, id)
 	if err != nil {
-		http.Error(w, "Subscription not found", http.StatusNotFound)
+		writeError(w, r, err, "Failed to get subscription")
 		return
 	}
-	if sub.UserId != r.Header.Get("User-ID") {
-		http.Error(w, "Forbidden", http.StatusForbidden)
+	etag := service.ETag(sub.Version)
+	if match := strings.Join(r.Header.Values("If-None-Match"), ","); match != "" && service.MatchesIfNoneMatch(match, etag) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
-	body, err := json.Marshal(sub)
-	if err != nil {
-		log.WithError(err).Error("Failed to marshal subscription")
-		http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(body)
+	writeSubscription(w, r, sub)
 }
 
-// UpdateSubscription updates an existing subscription
+// UpdateSubscription replaces an existing subscription
 //
 //	@Summary		Update subscription
-//	@Description	Update subscription details
+//	@Description	Update subscription by ID
 //	@Tags			subscriptions
 //	@Accept			json
 //	@Produce		json
-//	@Param			id				path	int						true	"Subscription ID"
-//	@Param			subscription	body	types.UserSubscription	true	"Updated subscription data"
-//	@Success		200				"Subscription updated"
-//	@Failure		400				{object}	string	"Bad request"
-//	@Failure		404				{object}	string	"Not found"
+//	@Param			id				path		int						true	"Subscription ID"
+//	@Param			If-Match		header		string					false	"ETag the update is based on"
+//	@Param			subscription	body		types.UserSubscription	true	"Updated subscription data"
+//	@Success		200				{object}	types.UserSubscription	"Updated subscription"
+//	@Header			200				{string}	ETag					"New version of the subscription"
+//	@Failure		400				{object}	service.Problem			"Bad request"
+//	@Failure		403				{object}	service.Problem			"Forbidden"
+//	@Failure		404				{object}	service.Problem			"Not found"
+//	@Failure		412				{object}	service.Problem			"Subscription changed since it was read"
+//	@Failure		428				{object}	service.Problem			"If-Match header missing"
 //	@Router			/subscription/{id} [put]
 func (a *App) UpdateSubscription(w http.ResponseWriter, r *http.Request) {
+	principal, id, ok := subscriptionRequest(w, r)
+	if !ok {
+		return
+	}
 	var request types.UserSubscription
 	if !service.ReadUserData(w, r, &request) {
 		return
 	}
-	request.UserId = r.Header.Get("User-ID")
 
-	if err := a.repo.Update(&request); err != nil {
-		log.WithError(err).Error("Failed to update subscription")
-		http.Error(w, "Subscription not found", http.StatusNotFound)
+	var stored *types.UserSubscription
+	err := a.repo.WithTx(r.Context(), func(tx db.Repository) error {
+		existing, err := ownedSubscription(r.Context(), tx, principal, id)
+		if err != nil {
+			return err
+		}
+		if err := a.checkIfMatch(r, existing); err != nil {
+			return err
+		}
+		replacement := request
+		replacement.Id = existing.Id
+		replacement.UserId = existing.UserId
+		replacement.Version = existing.Version
+		if stored, err = saveSubscription(r.Context(), tx, &replacement); err != nil {
+			return err
+		}
+		return auditSubscription(tx, r, db.AuditSubscriptionUpdated, existing, stored)
+	})
+	if err != nil {
+		writeError(w, r, err, "Failed to update subscription")
 		return
 	}
-	w.WriteHeader(http.StatusOK)
+	writeSubscription(w, r, stored)
 }
 
-// DeleteSubscription deletes a subscription
+// PatchSubscription partially updates an existing subscription
 //
-//	@Summary		Delete subscription
-//	@Description	Delete subscription by ID
+//	@Summary		Patch subscription
+//	@Description	Apply a JSON Merge Patch (RFC 7396) to the subscription with the given ID
 //	@Tags			subscriptions
-//	@Param			id	path	int	true	"Subscription ID"
-//	@Success		200	"Subscription deleted"
-//	@Failure		400	{object}	string	"Bad request"
-//	@Failure		403	{object}	string	"Forbidden"
-//	@Failure		404	{object}	string	"Not found"
-//	@Router			/subscription/{id} [delete]
-func (a *App) DeleteSubscription(w http.ResponseWriter, r *http.Request) {
-	id, err := service.GetIDRequest(r)
-	if err != nil {
-		http.Error(w, "Failed to parse ID", http.StatusBadRequest)
+//	@Accept			application/merge-patch+json
+//	@Produce		json
+//	@Param			id			path		int						true	"Subscription ID"
+//	@Param			If-Match	header		string					false	"ETag the patch is based on"
+//	@Param			patch		body		object					true	"Fields to change; null removes optional fields"
+//	@Success		200			{object}	types.UserSubscription	"Updated subscription"
+//	@Header			200			{string}	ETag					"New version of the subscription"
+//	@Failure		400			{object}	service.Problem			"Bad request"
+//	@Failure		403			{object}	service.Problem			"Forbidden"
+//	@Failure		404			{object}	service.Problem			"Not found"
+//	@Failure		412			{object}	service.Problem			"Subscription changed since it was read"
+//	@Failure		415			{object}	service.Problem			"Unsupported media type"
+//	@Failure		428			{object}	service.Problem			"If-Match header missing"
+//	@Router			/subscription/{id} [patch]
+func (a *App) PatchSubscription(w http.ResponseWriter, r *http.Request) {
+	if !requireMergePatch(w, r) {
 		return
 	}
-	existing, err := a.repo.Get(id)
+	principal, id, ok := subscriptionRequest(w, r)
+	if !ok {
+		return
+	}
+	patch, ok := readMergePatch(w, r)
+	if !ok {
+		return
+	}
+
+	var stored *types.UserSubscription
+	err := a.repo.WithTx(r.Context(), func(tx db.Repository) error {
+		existing, err := ownedSubscription(r.Context(), tx, principal, id)
+		if err != nil {
+			return err
+		}
+		if err := a.checkIfMatch(r, existing); err != nil {
+			return err
+		}
+		var updated types.UserSubscription
+		if err := applyMergePatch(existing, patch, &updated); err != nil {
+			return err
+		}
+		if err := service.Validate(&updated); err != nil {
+			return err
+		}
+		updated.Id = existing.Id
+		updated.UserId = existing.UserId
+		updated.Version = existing.Version
+		if stored, err = saveSubscription(r.Context(), tx, &updated); err != nil {
+			return err
+		}
+		return auditSubscription(tx, r, db.AuditSubscriptionUpdated, existing, stored)
+	})
 	if err != nil {
-		http.Error(w, "Subscription not found", http.StatusNotFound)
+		writeError(w, r, err, "Failed to update subscription")
 		return
 	}
-	if existing.UserId != r.Header.Get("User-ID") {
-		http.Error(w, "Forbidden", http.StatusForbidden)
+	writeSubscription(w, r, stored)
+}
+
+// requireMergePatch checks that r carries a JSON Merge Patch body, writing
+// a 415 response when it does not. Plain application/json is accepted too.
+func requireMergePatch(w http.ResponseWriter, r *http.Request) bool {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil ||
+		(mediaType != service.MergePatchContentType && mediaType != "application/json") {
+		writeProblem(w, r, http.StatusUnsupportedMediaType, service.CodeUnsupportedMediaType,
+			"Content-Type must be "+service.MergePatchContentType)
+		return false
+	}
+	return true
+}
+
+// readMergePatch reads the merge patch in the body of r, writing a 413
+// response when it is too large.
+func readMergePatch(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	patch, err := io.ReadAll(http.MaxBytesReader(w, r.Body, service.MaxBodyBytes))
+	if err != nil {
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, service.CodeBodyTooLarge, "Request body is too large")
+		return nil, false
+	}
+	return patch, true
+}
+
+// applyMergePatch applies patch to the JSON form of original and decodes the
+// result into target. Malformed patches and results yield a 400 problem.
+// target still needs validating.
+func applyMergePatch(original any, patch []byte, target any) error {
+	document, err := json.Marshal(original)
+	if err != nil {
+		return err
+	}
+	merged, err := service.MergePatch(document, patch)
+	if err != nil {
+		return service.NewProblem(http.StatusBadRequest, service.CodeInvalidBody, "Incorrect input data format")
+	}
+	return service.DecodeJSON(bytes.NewReader(merged), target)
+}
+
+// subscriptionRequest returns the authenticated user and the subscription ID
+// of the {id} path parameter, writing the error response itself when either
+// is missing.
+func subscriptionRequest(w http.ResponseWriter, r *http.Request) (*Principal, int64, bool) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return nil, 0, false
+	}
+	id, err := service.GetIDRequest(r)
+	if err != nil {
+		service.WriteProblem(w, r, service.InvalidParameter("id", "Subscription ID must be an integer"))
+		return nil, 0, false
+	}
+	return principal, id, true
+}
+
+// ownedSubscription loads subscription id through repo and checks that it
+// belongs to principal, or that principal is an admin. It fails with a 404 or
+// 403 problem otherwise.
+func ownedSubscription(ctx context.Context, repo db.Repository, principal *Principal, id int64) (*types.UserSubscription, error) {
+	sub, err := repo.Get(ctx, id)
+	return requireOwner(principal, sub, err)
+}
+
+// requireOwner applies the checks of ownedSubscription to the result of
+// loading a subscription.
+func requireOwner(principal *Principal, sub *types.UserSubscription, err error) (*types.UserSubscription, error) {
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, service.NewProblem(http.StatusNotFound, service.CodeNotFound, "Subscription not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sub.UserId != principal.UserID && !principal.HasRole(types.RoleAdmin) {
+		return nil, service.NewProblem(http.StatusForbidden, service.CodeForbidden, "Subscription belongs to another user")
+	}
+	return sub, nil
+}
+
+// saveSubscription normalizes and stores a full replacement of an existing
+// subscription through repo and returns its stored representation. The
+// update only applies while sub.UserId still owns the subscription and
+// sub.Version is still current.
+func saveSubscription(ctx context.Context, repo db.Repository, sub *types.UserSubscription) (*types.UserSubscription, error) {
+	if err := normalizeSubscription(sub); err != nil {
+		return nil, err
+	}
+	if err := repo.Update(ctx, sub); errors.Is(err, db.ErrNotFound) {
+		return nil, service.NewProblem(http.StatusNotFound, service.CodeNotFound, "Subscription not found")
+	} else if errors.Is(err, db.ErrStale) {
+		return nil, errSubscriptionChanged
+	} else if err != nil {
+		return nil, err
+	}
+	return repo.Get(ctx, sub.Id)
+}
+
+// errSubscriptionChanged answers writes based on an outdated version.
+var errSubscriptionChanged = service.NewProblem(http.StatusPreconditionFailed, service.CodePreconditionFailed,
+	"Subscription was changed since it was read")
+
+// checkIfMatch checks the If-Match header of r against the current version
+// of sub. A missing header fails with a 428 problem when a.requireIfMatch is
+// set, a header naming another version with a 412 problem.
+func (a *App) checkIfMatch(r *http.Request, sub *types.UserSubscription) error {
+	match := strings.Join(r.Header.Values("If-Match"), ",")
+	if match == "" {
+		if a.requireIfMatch {
+			return service.NewProblem(http.StatusPreconditionRequired, service.CodePreconditionRequired,
+				"If-Match header with the subscription ETag is required")
+		}
+		return nil
+	}
+	if !service.MatchesIfMatch(match, service.ETag(sub.Version)) {
+		return errSubscriptionChanged
+	}
+	return nil
+}
+
+// writeSubscription responds with sub and its ETag.
+func writeSubscription(w http.ResponseWriter, r *http.Request, sub *types.UserSubscription) {
+	w.Header().Set("ETag", service.ETag(sub.Version))
+	writeJSON(w, r, http.StatusOK, sub)
+}
+
+// DeleteSubscription moves a subscription to the trash
+//
+//	@Summary		Delete subscription
+//	@Description	Move subscription to the trash; it can be restored until the retention period ends
+//	@Tags			subscriptions
+//	@Param			id			path	int		true	"Subscription ID"
+//	@Param			If-Match	header	string	false	"ETag the deletion is based on"
+//	@Success		200			"Subscription moved to the trash"
+//	@Failure		400			{object}	service.Problem	"Bad request"
+//	@Failure		403			{object}	service.Problem	"Forbidden"
+//	@Failure		404			{object}	service.Problem	"Not found"
+//	@Failure		412			{object}	service.Problem	"Subscription changed since it was read"
+//	@Failure		428			{object}	service.Problem	"If-Match header missing"
+//	@Router			/subscription/{id} [delete]
+func (a *App) DeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	principal, id, ok := subscriptionRequest(w, r)
+	if !ok {
 		return
 	}
-	if err := a.repo.Delete(id); err != nil {
-		log.WithError(err).Error("Failed to delete subscription")
-		http.Error(w, "Subscription not found", http.StatusNotFound)
+	err := a.repo.WithTx(r.Context(), func(tx db.Repository) error {
+		existing, err := ownedSubscription(r.Context(), tx, principal, id)
+		if err != nil {
+			return err
+		}
+		if err := a.checkIfMatch(r, existing); err != nil {
+			return err
+		}
+		err = tx.Delete(r.Context(), existing.Id, existing.UserId)
+		if errors.Is(err, db.ErrNotFound) {
+			return service.NewProblem(http.StatusNotFound, service.CodeNotFound, "Subscription not found")
+		}
+		if err != nil {
+			return err
+		}
+		deleted, err := tx.GetDeleted(r.Context(), existing.Id)
+		if err != nil {
+			return err
+		}
+		return auditSubscription(tx, r, db.AuditSubscriptionDeleted, existing, deleted)
+	})
+	if err != nil {
+		writeError(w, r, err, "Failed to delete subscription")
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -178,82 +478,293 @@ func (a *App) DeleteSubscription(w http.ResponseWriter, r *http.Request) {
 // ListSubscription lists subscriptions for the authenticated user
 //
 //	@Summary		List subscriptions
-//	@Description	Paginated list of subscriptions for the current user
+//	@Description	Filtered, sorted and paginated list of subscriptions for the current user
 //	@Tags			subscriptions
 //	@Produce		json
-//	@Param			after_id	query		int		false	"Cursor: return items after this ID"
-//	@Param			limit		query		int		false	"Max items to return"
-//	@Success		200			{object}	map[string]interface{}	"{ data: [...], next_after_id: number|null }"
-//	@Failure		500			{object}	string	"Internal server error"
+//	@Param			service_name_prefix		query		string							false	"Case-insensitive service name prefix"
+//	@Param			service_name_contains	query		string							false	"Case-insensitive service name substring"
+//	@Param			min_price				query		int								false	"Minimum price"
+//	@Param			max_price				query		int								false	"Maximum price"
+//	@Param			active_on				query		string							false	"Only subscriptions running on this date (YYYY-MM-DD)"
+//	@Param			status					query		string							false	"active (running today) or ended"
+//	@Param			updated_since			query		string							false	"Only subscriptions updated at or after this RFC 3339 time"
+//	@Param			include_deleted			query		bool							false	"Also return subscriptions in the trash"
+//	@Param			sort_by					query		string							false	"id, price, start_date, end_date, service_name or updated_at"
+//	@Param			order					query		string							false	"asc or desc"
+//	@Param			cursor					query		string							false	"Opaque cursor from next_cursor of the previous page"
+//	@Param			after_id				query		int								false	"Legacy cursor: return items after this ID (id order only)"
+//	@Param			limit					query		int								false	"Page size, 20 by default and at most 100"
+//	@Param			include_total			query		bool							false	"Also return the total number of matching subscriptions"
+//	@Success		200						{object}	types.SubscriptionListResponse	"Page of subscriptions; a Link header points at the next page"
+//	@Failure		400						{object}	service.Problem					"Bad request"
+//	@Failure		500						{object}	service.Problem					"Internal server error"
 //	@Router			/subscriptionList [get]
 func (a *App) ListSubscription(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("User-ID")
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	a.listSubscriptions(w, r, principal.UserID, false)
+}
 
-	var limit int
-	if v := r.URL.Query().Get("limit"); v != "" {
-		if n, err := strconv.Atoi(v); err == nil && n > 0 {
-			limit = n
-		}
+// listSubscriptions responds with the page of userID's subscriptions
+// described by the query parameters of r, or of the subscriptions in their
+// trash.
+func (a *App) listSubscriptions(w http.ResponseWriter, r *http.Request, userID string, trash bool) {
+	query, err := parseListQuery(r)
+	if err != nil {
+		writeError(w, r, err, "Invalid list parameters")
+		return
 	}
-	var afterID *int64
-	if v := r.URL.Query().Get("after_id"); v != "" {
-		if id, err := strconv.ParseInt(v, 10, 64); err == nil {
-			afterID = &id
-		}
+	if trash {
+		query.Deleted = types.DeletedOnly
 	}
 
-	items, err := a.repo.List(userID, afterID, limit)
+	pageSize := query.Limit
+	query.Limit = pageSize + 1
+	items, err := a.repo.List(r.Context(), userID, query)
 	if err != nil {
-		log.WithError(err).Error("Failed to list subscriptions")
-		http.Error(w, "Failed to retrieve subscriptions", http.StatusInternalServerError)
+		writeError(w, r, err, "Failed to retrieve subscriptions")
 		return
 	}
 
-	var nextAfterID *int64
-	if len(items) > 0 {
-		id := items[len(items)-1].Id
-		nextAfterID = &id
+	response := types.SubscriptionListResponse{Data: make([]types.SubscriptionListItem, 0, len(items))}
+	if len(items) > pageSize {
+		items = items[:pageSize]
+		response.HasMore = true
+		last := items[pageSize-1]
+		cursor := service.EncodeCursor(listCursor(&last, query))
+		response.NextCursor = &cursor
+		if query.SortBy == types.SortByID && !query.Descending {
+			response.NextAfterID = &last.Id
+		}
+		w.Header().Set("Link", nextPageLink(r, cursor, pageSize))
+	}
+	for _, item := range items {
+		response.Data = append(response.Data, types.SubscriptionListItem{UserSubscription: item, ETag: service.ETag(item.Version)})
+	}
+
+	if includeTotal, _ := strconv.ParseBool(r.URL.Query().Get("include_total")); includeTotal {
+		total, err := a.repo.Count(r.Context(), userID, query)
+		if err != nil {
+			writeError(w, r, err, "Failed to count subscriptions")
+			return
+		}
+		response.Total = &total
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(struct {
-		Data        []types.UserSubscription `json:"data"`
-		NextAfterID *int64                   `json:"next_after_id"`
-	}{Data: items, NextAfterID: nextAfterID}); err != nil {
+	if err := json.NewEncoder(w).Encode(response); err != nil {
 		log.WithError(err).Error("Failed to encode list response")
 	}
 }
 
+// nextPageLink builds an RFC 8288 Link header value pointing at the page
+// after cursor, keeping the request's filters and sort order.
+func nextPageLink(r *http.Request, cursor string, limit int) string {
+	params := r.URL.Query()
+	params.Del("after_id")
+	params.Set("cursor", cursor)
+	params.Set("limit", strconv.Itoa(limit))
+	next := url.URL{Path: r.URL.Path, RawQuery: params.Encode()}
+	return fmt.Sprintf(`<%s>; rel="next"`, next.String())
+}
+
+// parseListQuery reads the ListSubscription query parameters.
+func parseListQuery(r *http.Request) (*types.SubscriptionListQuery, error) {
+	params := r.URL.Query()
+	query := &types.SubscriptionListQuery{
+		ServiceNamePrefix:   params.Get("service_name_prefix"),
+		ServiceNameContains: params.Get("service_name_contains"),
+		SortBy:              types.SortByID,
+		Limit:               defaultListLimit,
+	}
+
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, service.InvalidParameter("limit", "Must be a positive integer")
+		}
+		query.Limit = min(n, maxListLimit)
+	}
+	if v := params.Get("include_total"); v != "" {
+		if _, err := strconv.ParseBool(v); err != nil {
+			return nil, service.InvalidParameter("include_total", "Must be a boolean")
+		}
+	}
+	for name, dst := range map[string]**int64{"min_price": &query.MinPrice, "max_price": &query.MaxPrice} {
+		if v := params.Get(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, service.InvalidParameter(name, "Must be an integer")
+			}
+			*dst = &n
+		}
+	}
+	if v := params.Get("active_on"); v != "" {
+		day, err := service.ParseDate(v)
+		if err != nil {
+			return nil, service.InvalidParameter("active_on", err.Error())
+		}
+		formatted := day.Format(service.DateFormat)
+		query.ActiveOn = &formatted
+	}
+	if v := params.Get("updated_since"); v != "" {
+		since, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, service.InvalidParameter("updated_since", "Must be an RFC 3339 timestamp")
+		}
+		query.UpdatedSince = &since
+	}
+	if v := params.Get("include_deleted"); v != "" {
+		include, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, service.InvalidParameter("include_deleted", "Must be a boolean")
+		}
+		if include {
+			query.Deleted = types.DeletedInclude
+		}
+	}
+	switch status := params.Get("status"); status {
+	case "", types.StatusActive, types.StatusEnded:
+		query.Status = status
+	default:
+		return nil, service.InvalidParameter("status", "Must be active or ended")
+	}
+	switch sortBy := params.Get("sort_by"); sortBy {
+	case "":
+	case types.SortByID, types.SortByPrice, types.SortByStartDate, types.SortByEndDate, types.SortByServiceName,
+		types.SortByUpdatedAt:
+		query.SortBy = sortBy
+	default:
+		return nil, service.InvalidParameter("sort_by", "Must be one of id, price, start_date, end_date, service_name, updated_at")
+	}
+	switch order := params.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return nil, service.InvalidParameter("order", "Must be asc or desc")
+	}
+
+	if v := params.Get("cursor"); v != "" {
+		cursor, err := service.DecodeCursor(v)
+		if err != nil {
+			return nil, service.InvalidParameter("cursor", err.Error())
+		}
+		if cursor.SortBy != query.SortBy || cursor.Descending != query.Descending {
+			return nil, service.InvalidParameter("cursor", "Cursor does not match the requested sort order")
+		}
+		if !validCursorValue(cursor) {
+			return nil, service.InvalidParameter("cursor", "Cursor sort key does not match the sort column")
+		}
+		query.After = cursor
+	} else if v := params.Get("after_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, service.InvalidParameter("after_id", "Must be an integer")
+		}
+		if query.SortBy != types.SortByID || query.Descending {
+			return nil, service.InvalidParameter("after_id", "Only supported with ascending id order, use cursor instead")
+		}
+		query.After = &types.ListCursor{SortBy: types.SortByID, ID: id}
+	}
+	return query, nil
+}
+
+// validCursorValue reports whether the sort key of a decoded cursor has the
+// type of its sort column, as listCursor writes it. Only id cursors carry no
+// value, and only end_date cursors may hold NULL.
+func validCursorValue(c *types.ListCursor) bool {
+	if c.Value == nil {
+		return c.SortBy == types.SortByID || c.SortBy == types.SortByEndDate
+	}
+	var err error
+	switch c.SortBy {
+	case types.SortByPrice:
+		_, err = strconv.ParseInt(*c.Value, 10, 64)
+	case types.SortByStartDate, types.SortByEndDate:
+		_, err = service.ParseDate(*c.Value)
+	case types.SortByUpdatedAt:
+		_, err = time.Parse(time.RFC3339Nano, *c.Value)
+	}
+	return err == nil
+}
+
+// listCursor builds the cursor pointing right after sub in query's order.
+func listCursor(sub *types.UserSubscription, query *types.SubscriptionListQuery) types.ListCursor {
+	cursor := types.ListCursor{SortBy: query.SortBy, Descending: query.Descending, ID: sub.Id}
+	var value string
+	switch query.SortBy {
+	case types.SortByPrice:
+		value = strconv.FormatInt(sub.Price, 10)
+	case types.SortByStartDate:
+		cursor.Value = sub.StartDate
+		return cursor
+	case types.SortByEndDate:
+		cursor.Value = sub.EndDate
+		return cursor
+	case types.SortByServiceName:
+		value = sub.ServiceName
+	case types.SortByUpdatedAt:
+		if sub.UpdatedAt == nil {
+			return cursor
+		}
+		value = sub.UpdatedAt.UTC().Format(time.RFC3339Nano)
+	default:
+		return cursor
+	}
+	cursor.Value = &value
+	return cursor
+}
+
 // SumUserSubscriptions calculates total subscription cost
 //
 //	@Summary		Calculate subscription sum
-//	@Description	Total cost of subscriptions in a date range
+//	@Description	Total cost of subscriptions in a date range, converted into target_currency
 //	@Tags			subscriptions
 //	@Accept			json
 //	@Produce		json
-//	@Param			request	body		types.UserSumSubscriptionRequest	true	"Date range"
+//	@Param			request	body		types.UserSumSubscriptionRequest	true	"Date range and target currency"
 //	@Success		200		{object}	types.UserSubscriptionSumResponse	"Total sum"
-//	@Failure		400		{object}	string								"Bad request"
-//	@Failure		500		{object}	string								"Internal server error"
+//	@Failure		400		{object}	service.Problem						"Bad request"
+//	@Failure		422		{object}	service.Problem						"Missing exchange rate"
+//	@Failure		500		{object}	service.Problem						"Internal server error"
 //	@Router			/sum_subscriptions [post]
 func (a *App) SumUserSubscriptions(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
 	var request types.UserSumSubscriptionRequest
 	if !service.ReadUserData(w, r, &request) {
 		return
 	}
-	request.UserId = r.Header.Get("User-ID")
+	request.UserId = principal.UserID
+
+	request.TargetCurrency, _ = service.NormalizeCurrency(request.TargetCurrency)
+	if err := service.NormalizeDate(&request.StartDate, service.ParseDate); err != nil {
+		writeError(w, r, err, "Invalid date range")
+		return
+	}
+	if err := service.NormalizeDate(&request.EndDate, service.ParsePeriodEnd); err != nil {
+		writeError(w, r, err, "Invalid date range")
+		return
+	}
 
-	total, err := a.repo.Sum(&request)
+	total, err := a.repo.Sum(r.Context(), &request)
 	if err != nil {
-		log.WithError(err).Error("Failed to calculate subscription sum")
-		http.Error(w, "Failed to calculate subscription sum", http.StatusInternalServerError)
+		writeError(w, r, err, "Failed to calculate subscription sum")
 		return
 	}
 
-	body, err := json.Marshal(types.UserSubscriptionSumResponse{UserId: request.UserId, CurrentSum: total})
+	body, err := json.Marshal(types.UserSubscriptionSumResponse{
+		UserId:     request.UserId,
+		CurrentSum: total,
+		Currency:   request.TargetCurrency,
+	})
 	if err != nil {
-		log.WithError(err).Error("Failed to marshal sum response")
-		http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
+		writeError(w, r, err, "Failed to marshal response")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"crudl_service/src/service"
 	"crudl_service/src/types"
 	"encoding/json"
 	"net/http"
@@ -22,7 +23,7 @@ func TestCreateSubscription_ValidRequest(t *testing.T) {
 
 	jsonData, _ := json.Marshal(subscription)
 	req := httptest.NewRequest("POST", "/subscription", bytes.NewBuffer(jsonData))
-	req.Header.Set("User-ID", "user123")
+	req = withPrincipal(req, "user123")
 	w := httptest.NewRecorder()
 
 	app.CreateSubscription(w, req)
@@ -44,6 +45,7 @@ func TestCreateSubscription_InvalidTimeFormat(t *testing.T) {
 
 	jsonData, _ := json.Marshal(subscription)
 	req := httptest.NewRequest("POST", "/subscription", bytes.NewBuffer(jsonData))
+	req = withPrincipal(req, "user123")
 	w := httptest.NewRecorder()
 
 	app.CreateSubscription(w, req)
@@ -114,3 +116,212 @@ func TestListSubscription_Structure(t *testing.T) {
 		t.Error("Handler did not set any response code")
 	}
 }
+
+func TestCreateSubscription_DefaultCurrency(t *testing.T) {
+	repo := newMockRepository()
+	app := newTestApp(repo)
+
+	startDate := "01-2023"
+	jsonData, _ := json.Marshal(types.UserSubscription{ServiceName: "Netflix", Price: 999, StartDate: &startDate})
+	req := httptest.NewRequest("POST", "/subscription", bytes.NewBuffer(jsonData))
+	req = withPrincipal(req, "user123")
+	w := httptest.NewRecorder()
+
+	app.CreateSubscription(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, w.Code)
+	}
+	if got := repo.subscriptions[1].Currency; got != "RUB" {
+		t.Errorf("Expected default currency 'RUB', got '%s'", got)
+	}
+}
+
+func TestCreateSubscription_InvalidCurrency(t *testing.T) {
+	app := newTestApp(newMockRepository())
+
+	startDate := "01-2023"
+	jsonData, _ := json.Marshal(types.UserSubscription{ServiceName: "Netflix", Price: 999, Currency: "XYZ", StartDate: &startDate})
+	req := httptest.NewRequest("POST", "/subscription", bytes.NewBuffer(jsonData))
+	req = withPrincipal(req, "user123")
+	w := httptest.NewRecorder()
+
+	app.CreateSubscription(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestSumUserSubscriptions_TargetCurrency(t *testing.T) {
+	repo := newMockRepository()
+	app := newTestApp(repo)
+
+	startDate := "01-2023"
+	repo.subscriptions[1] = &types.UserSubscription{Id: 1, ServiceName: "Netflix", Price: 10, Currency: "USD", UserId: "user123", StartDate: &startDate}
+
+	jsonData, _ := json.Marshal(types.UserSumSubscriptionRequest{StartDate: "01-2023", EndDate: "12-2023", TargetCurrency: "usd"})
+	req := httptest.NewRequest("POST", "/sum_subscriptions", bytes.NewBuffer(jsonData))
+	req = withPrincipal(req, "user123")
+	w := httptest.NewRecorder()
+
+	app.SumUserSubscriptions(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var response types.UserSubscriptionSumResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal("Failed to unmarshal response")
+	}
+	if response.Currency != "USD" || response.CurrentSum != 10 {
+		t.Errorf("Expected 10 USD, got %d %s", response.CurrentSum, response.Currency)
+	}
+}
+
+func TestSumUserSubscriptions_MissingRate(t *testing.T) {
+	repo := newMockRepository()
+	app := newTestApp(repo)
+
+	startDate := "01-2023"
+	repo.subscriptions[1] = &types.UserSubscription{Id: 1, ServiceName: "Netflix", Price: 10, Currency: "USD", UserId: "user123", StartDate: &startDate}
+
+	jsonData, _ := json.Marshal(types.UserSumSubscriptionRequest{StartDate: "01-2023", EndDate: "12-2023", TargetCurrency: "EUR"})
+	req := httptest.NewRequest("POST", "/sum_subscriptions", bytes.NewBuffer(jsonData))
+	req = withPrincipal(req, "user123")
+	w := httptest.NewRecorder()
+
+	app.SumUserSubscriptions(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+}
+
+func TestCreateSubscription_BillingPeriod(t *testing.T) {
+	startDate := "01-2023"
+	badAnchor := "15.01.2023"
+	interval := 6
+	tests := []struct {
+		name     string
+		sub      types.UserSubscription
+		expected int
+		period   string
+	}{
+		{"Default monthly", types.UserSubscription{}, http.StatusCreated, "monthly"},
+		{"Yearly", types.UserSubscription{BillingPeriod: "YEARLY"}, http.StatusCreated, "yearly"},
+		{"Custom", types.UserSubscription{BillingPeriod: "custom", BillingIntervalMonths: &interval}, http.StatusCreated, "custom"},
+		{"Custom without interval", types.UserSubscription{BillingPeriod: "custom"}, http.StatusBadRequest, ""},
+		{"Unknown period", types.UserSubscription{BillingPeriod: "daily"}, http.StatusBadRequest, ""},
+		{"Bad anchor", types.UserSubscription{BillingAnchor: &badAnchor}, http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newMockRepository()
+			app := newTestApp(repo)
+
+			sub := tt.sub
+			sub.ServiceName, sub.Price, sub.StartDate = "Netflix", 999, &startDate
+			jsonData, _ := json.Marshal(sub)
+			req := httptest.NewRequest("POST", "/subscription", bytes.NewBuffer(jsonData))
+			req = withPrincipal(req, "user123")
+			w := httptest.NewRecorder()
+
+			app.CreateSubscription(w, req)
+
+			if w.Code != tt.expected {
+				t.Fatalf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+			if tt.period != "" && repo.subscriptions[1].BillingPeriod != tt.period {
+				t.Errorf("Expected billing period '%s', got '%s'", tt.period, repo.subscriptions[1].BillingPeriod)
+			}
+		})
+	}
+}
+
+func TestCreateSubscription_DayPrecisionDates(t *testing.T) {
+	repo := newMockRepository()
+	app := newTestApp(repo)
+
+	startDate := "2024-01-17"
+	endDate := "06-2024"
+	jsonData, _ := json.Marshal(types.UserSubscription{ServiceName: "Netflix", Price: 999, StartDate: &startDate, EndDate: &endDate})
+	req := httptest.NewRequest("POST", "/subscription", bytes.NewBuffer(jsonData))
+	req = withPrincipal(req, "user123")
+	w := httptest.NewRecorder()
+
+	app.CreateSubscription(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, w.Code)
+	}
+	stored := repo.subscriptions[1]
+	if *stored.StartDate != "2024-01-17" {
+		t.Errorf("Expected start date '2024-01-17', got '%s'", *stored.StartDate)
+	}
+	if *stored.EndDate != "2024-06-30" {
+		t.Errorf("Expected legacy end month to cover the whole month, got '%s'", *stored.EndDate)
+	}
+}
+
+func TestSumUserSubscriptions_InvalidDate(t *testing.T) {
+	app := newTestApp(newMockRepository())
+
+	jsonData, _ := json.Marshal(types.UserSumSubscriptionRequest{StartDate: "2024/01/01", EndDate: "12-2024"})
+	req := httptest.NewRequest("POST", "/sum_subscriptions", bytes.NewBuffer(jsonData))
+	req = withPrincipal(req, "user123")
+	w := httptest.NewRecorder()
+
+	app.SumUserSubscriptions(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestCreateSubscription_ReportsAllFieldErrors(t *testing.T) {
+	app := newTestApp(newMockRepository())
+
+	req := httptest.NewRequest("POST", "/subscription", bytes.NewBufferString(
+		`{"service_name":"Netflix","price":999,"currency":"XYZ","end_date":"soon","billing_period":"daily"}`))
+	req = withPrincipal(req, "user123")
+	w := httptest.NewRecorder()
+
+	app.CreateSubscription(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != service.ProblemContentType {
+		t.Errorf("Expected content type %s, got %s", service.ProblemContentType, ct)
+	}
+	var problem service.Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatal("Failed to unmarshal problem")
+	}
+	fields := map[string]bool{}
+	for _, e := range problem.Errors {
+		fields[e.Field] = true
+	}
+	for _, field := range []string{"start_date", "end_date", "currency", "billing_period"} {
+		if !fields[field] {
+			t.Errorf("Expected a violation for %s, got %+v", field, problem.Errors)
+		}
+	}
+}
+
+func TestSumUserSubscriptions_EndBeforeStart(t *testing.T) {
+	app := newTestApp(newMockRepository())
+
+	jsonData, _ := json.Marshal(types.UserSumSubscriptionRequest{StartDate: "2024-06-01", EndDate: "2024-05-31"})
+	req := httptest.NewRequest("POST", "/sum_subscriptions", bytes.NewBuffer(jsonData))
+	req = withPrincipal(req, "user123")
+	w := httptest.NewRecorder()
+
+	app.SumUserSubscriptions(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
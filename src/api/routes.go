@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Router mounts every API endpoint with its authentication middleware.
+func (a *App) Router() chi.Router {
+	r := chi.NewRouter()
+	r.Use(RequestID)
+
+	r.Get("/.well-known/jwks.json", a.JWKS)
+
+	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}`))
+	})
+
+	r.Post("/register", a.RegisterUser)
+	r.Post("/login", a.LoginUser)
+	r.Post("/login/mfa", a.LoginMFA)
+	r.Post("/token/refresh", a.RefreshToken)
+	r.Post("/logout", a.ValidateJWT(a.Logout))
+	r.Post("/logout-all", a.RequireWriteScope(a.LogoutAll))
+	r.Get("/me", a.ValidateJWT(a.GetProfile))
+	r.Patch("/me", a.RequireWriteScope(a.PatchProfile))
+	r.Delete("/me", a.ValidateJWT(a.DeleteAccount))
+	r.Get("/me/export", a.ValidateJWT(a.ExportAccount))
+	r.Post("/me/password", a.ValidateJWT(a.ChangePassword))
+	r.Post("/me/mfa/totp", a.ValidateJWT(a.EnrollTOTP))
+	r.Post("/me/mfa/totp/verify", a.ValidateJWT(a.VerifyTOTP))
+	r.Delete("/me/mfa/totp", a.ValidateJWT(a.DisableTOTP))
+	r.Post("/password/reset/request", a.RequestPasswordReset)
+	r.Post("/password/reset", a.ResetPassword)
+
+	r.Get("/api_keys", a.ValidateJWT(a.ListAPIKeys))
+	r.Post("/api_keys", a.ValidateJWT(a.CreateAPIKey))
+	r.Patch("/api_keys/{id}", a.ValidateJWT(a.RenameAPIKey))
+	r.Delete("/api_keys/{id}", a.ValidateJWT(a.RevokeAPIKey))
+
+	r.Post("/subscription", a.RequireWriteScope(a.CreateSubscription))
+	r.Get("/subscription/{id}", a.ValidateJWT(a.ReadSubscription))
+	r.Put("/subscription/{id}", a.RequireWriteScope(a.UpdateSubscription))
+	r.Patch("/subscription/{id}", a.RequireWriteScope(a.PatchSubscription))
+	r.Delete("/subscription/{id}", a.RequireWriteScope(a.DeleteSubscription))
+	r.Post("/subscription/{id}/restore", a.RequireWriteScope(a.RestoreSubscription))
+	r.Get("/subscriptionList", a.ValidateJWT(a.ListSubscription))
+	r.Get("/trash", a.ValidateJWT(a.ListTrash))
+	r.Get("/audit", a.ValidateJWT(a.ListAuditEvents))
+	r.Post("/sum_subscriptions", a.ValidateJWT(a.SumUserSubscriptions))
+
+	r.Put("/admin/exchange_rates/{month}", a.RequireAdminWrite(a.SetExchangeRates))
+	r.Get("/admin/exchange_rates/{month}", a.RequireAdmin(a.ListExchangeRates))
+	r.Get("/admin/users", a.RequireAdmin(a.ListUsers))
+	r.Patch("/admin/users/{user_id}", a.RequireAdminWrite(a.UpdateUserAccess))
+	r.Get("/admin/users/{user_id}/subscriptions", a.RequireAdmin(a.ListUserSubscriptions))
+	r.Get("/admin/audit", a.RequireAdmin(a.ListAllAuditEvents))
+
+	return r
+}
@@ -1,13 +1,20 @@
 package api
 
 import (
+	"bytes"
 	"context"
 	"crudl_service/src/db"
+	"crudl_service/src/service"
 	"crudl_service/src/types"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -15,81 +22,670 @@ import (
 // mockRepository implements db.Repository for tests.
 type mockRepository struct {
 	subscriptions map[int64]*types.UserSubscription
+	rates         map[string][]types.ExchangeRate
 	nextID        int64
+	lastListQuery *types.SubscriptionListQuery
+	refreshTokens map[string]*mockRefreshToken
+	revokedJTIs   map[string]bool
+	validAfter    map[string]time.Time
+	users         map[string]*db.User
+	usersErr      error
+	apiKeys       map[string]*mockAPIKey
+	loginFailures map[string]*mockLoginFailure
+	auditEvents   []db.AuditEvent
+	auditErr      error
+	resetTokens   map[string]*mockResetToken
+	totpSteps     map[string]int64
+	recoveryCodes map[string]map[string]bool
+	challenges    map[string]*mockChallenge
+	profiles      map[string]*types.Profile
+	transactions  int
+}
+
+type mockChallenge struct {
+	userID   string
+	failures int
+	used     bool
+}
+
+type mockResetToken struct {
+	userID    string
+	expiresAt time.Time
+	used      bool
+}
+
+type mockLoginFailure struct {
+	failures    int
+	lockedUntil time.Time
+}
+
+type mockAPIKey struct {
+	types.APIKey
+	userID  string
+	revoked bool
+}
+
+type mockRefreshToken struct {
+	userID, family string
+	used, revoked  bool
 }
 
 func newMockRepository() *mockRepository {
 	return &mockRepository{
 		subscriptions: make(map[int64]*types.UserSubscription),
+		rates:         make(map[string][]types.ExchangeRate),
 		nextID:        1,
+		refreshTokens: make(map[string]*mockRefreshToken),
+		revokedJTIs:   make(map[string]bool),
+		validAfter:    make(map[string]time.Time),
+		users:         make(map[string]*db.User),
+		apiKeys:       make(map[string]*mockAPIKey),
+		loginFailures: make(map[string]*mockLoginFailure),
+		resetTokens:   make(map[string]*mockResetToken),
+		totpSteps:     make(map[string]int64),
+		recoveryCodes: make(map[string]map[string]bool),
+		challenges:    make(map[string]*mockChallenge),
+		profiles:      make(map[string]*types.Profile),
 	}
 }
 
 func newTestApp(repo db.Repository) *App {
-	return &App{repo: repo, jwtSecret: "test-secret"}
+	return &App{
+		repo: repo, keys: service.NewHMACKeySet("test-secret"), accessTTL: 15 * time.Minute, refreshTTL: time.Hour,
+		userThrottle: service.UserLoginThrottle, ipThrottle: service.IPLoginThrottle,
+		passwords: service.NewPasswordPolicy(8, "password123"), notifier: &recordingNotifier{}, resetTTL: time.Hour,
+	}
 }
 
-func (m *mockRepository) Create(data *types.UserSubscription) (int64, error) {
+// withPrincipal authenticates req as the regular user userID, as
+// ValidateJWT would.
+func withPrincipal(req *http.Request, userID string) *http.Request {
+	return req.WithContext(ContextWithPrincipal(req.Context(), &Principal{
+		UserID: userID, Roles: []string{types.RoleUser}, AuthMethod: AuthMethodJWT,
+	}))
+}
+
+func (m *mockRepository) Create(ctx context.Context, data *types.UserSubscription) (int64, error) {
+	now := time.Now()
 	data.Id = m.nextID
+	data.Version, data.CreatedAt, data.UpdatedAt = 1, &now, &now
 	m.subscriptions[m.nextID] = data
 	m.nextID++
 	return data.Id, nil
 }
 
-func (m *mockRepository) Get(id int64) (*types.UserSubscription, error) {
-	if sub, ok := m.subscriptions[id]; ok {
-		return sub, nil
+func (m *mockRepository) Get(ctx context.Context, id int64) (*types.UserSubscription, error) {
+	if sub, ok := m.subscriptions[id]; ok && sub.DeletedAt == nil {
+		stored := *sub
+		return &stored, nil
 	}
 	return nil, &db.NotFoundError{}
 }
 
-func (m *mockRepository) Update(data *types.UserSubscription) error {
-	if _, ok := m.subscriptions[data.Id]; !ok {
+func (m *mockRepository) Update(ctx context.Context, data *types.UserSubscription) error {
+	sub, ok := m.subscriptions[data.Id]
+	if !ok || sub.UserId != data.UserId || sub.DeletedAt != nil {
 		return &db.NotFoundError{}
 	}
+	if data.Version != 0 && data.Version != sub.Version {
+		return db.ErrStale
+	}
+	now := time.Now()
+	data.Version = sub.Version + 1
+	data.CreatedAt, data.UpdatedAt = sub.CreatedAt, &now
 	m.subscriptions[data.Id] = data
 	return nil
 }
 
-func (m *mockRepository) Delete(id int64) error {
-	if _, ok := m.subscriptions[id]; !ok {
+func (m *mockRepository) Delete(ctx context.Context, id int64, ownerID string) error {
+	sub, ok := m.subscriptions[id]
+	if !ok || sub.UserId != ownerID || sub.DeletedAt != nil {
 		return &db.NotFoundError{}
 	}
-	delete(m.subscriptions, id)
+	now := time.Now()
+	sub.DeletedAt = &now
+	sub.Version++
+	return nil
+}
+
+func (m *mockRepository) GetDeleted(ctx context.Context, id int64) (*types.UserSubscription, error) {
+	if sub, ok := m.subscriptions[id]; ok && sub.DeletedAt != nil {
+		stored := *sub
+		return &stored, nil
+	}
+	return nil, db.ErrNotFound
+}
+
+func (m *mockRepository) Restore(ctx context.Context, id int64, ownerID string) error {
+	sub, ok := m.subscriptions[id]
+	if !ok || sub.UserId != ownerID || sub.DeletedAt == nil {
+		return db.ErrNotFound
+	}
+	sub.DeletedAt = nil
+	sub.Version++
 	return nil
 }
 
-func (m *mockRepository) List(userID string, afterID *int64, limit int) ([]types.UserSubscription, error) {
+func (m *mockRepository) PurgeDeleted(ctx context.Context, before time.Time, limit int) (int64, error) {
+	var purged int64
+	for id, sub := range m.subscriptions {
+		if sub.DeletedAt != nil && sub.DeletedAt.Before(before) && purged < int64(limit) {
+			delete(m.subscriptions, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// WithTx counts the transaction and runs fn on the mock itself, which has no
+// concurrent writers to isolate fn from.
+func (m *mockRepository) WithTx(ctx context.Context, fn func(db.Repository) error, opts ...db.TxOption) error {
+	m.transactions++
+	return fn(m)
+}
+
+func (m *mockRepository) List(ctx context.Context, userID string, query *types.SubscriptionListQuery) ([]types.UserSubscription, error) {
+	m.lastListQuery = query
+	ids := make([]int64, 0, len(m.subscriptions))
+	for id := range m.subscriptions {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
 	var result []types.UserSubscription
-	for _, sub := range m.subscriptions {
-		if sub.UserId == userID {
-			if afterID == nil || sub.Id > *afterID {
-				result = append(result, *sub)
-				if limit > 0 && len(result) >= limit {
-					break
-				}
-			}
+	for _, id := range ids {
+		sub := m.subscriptions[id]
+		if sub.UserId != userID || (query.After != nil && sub.Id <= query.After.ID) {
+			continue
+		}
+		if query.ServiceNamePrefix != "" && !strings.HasPrefix(strings.ToLower(sub.ServiceName), strings.ToLower(query.ServiceNamePrefix)) {
+			continue
+		}
+		if (query.MinPrice != nil && sub.Price < *query.MinPrice) || (query.MaxPrice != nil && sub.Price > *query.MaxPrice) {
+			continue
+		}
+		if query.UpdatedSince != nil && (sub.UpdatedAt == nil || sub.UpdatedAt.Before(*query.UpdatedSince)) {
+			continue
+		}
+		if (sub.DeletedAt != nil && query.Deleted == types.DeletedExclude) || (sub.DeletedAt == nil && query.Deleted == types.DeletedOnly) {
+			continue
+		}
+		result = append(result, *sub)
+		if query.Limit > 0 && len(result) >= query.Limit {
+			break
 		}
 	}
 	return result, nil
 }
 
-func (m *mockRepository) Sum(data *types.UserSumSubscriptionRequest) (int64, error) {
+func (m *mockRepository) Count(ctx context.Context, userID string, query *types.SubscriptionListQuery) (int64, error) {
+	unpaged := *query
+	unpaged.After, unpaged.Limit = nil, 0
+	items, err := m.List(ctx, userID, &unpaged)
+	return int64(len(items)), err
+}
+
+func (m *mockRepository) Sum(ctx context.Context, data *types.UserSumSubscriptionRequest) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("%w: %w", db.ErrCanceled, context.Cause(ctx))
+	}
 	var sum int64
 	for _, sub := range m.subscriptions {
-		if sub.UserId == data.UserId {
+		if sub.UserId == data.UserId && sub.DeletedAt == nil {
+			if sub.Currency != data.TargetCurrency {
+				return 0, db.ErrExchangeRateNotFound
+			}
 			sum += sub.Price
 		}
 	}
 	return sum, nil
 }
 
-func (m *mockRepository) GetUserByUsername(username string) (*db.User, error) {
+func (m *mockRepository) SetExchangeRates(ctx context.Context, month string, rates []types.ExchangeRate) error {
+	m.rates[month] = rates
+	return nil
+}
+
+func (m *mockRepository) ListExchangeRates(ctx context.Context, month string) ([]types.ExchangeRate, error) {
+	return m.rates[month], nil
+}
+
+func (m *mockRepository) GetUserByUsername(ctx context.Context, username string) (*db.User, error) {
+	if m.usersErr != nil {
+		return nil, m.usersErr
+	}
+	for _, user := range m.users {
+		if strings.EqualFold(user.Username, username) {
+			copied := *user
+			return &copied, nil
+		}
+	}
 	return nil, &db.NotFoundError{}
 }
 
-func (m *mockRepository) CreateUser(username, hashedPassword string) (string, error) {
-	return "", &db.NotFoundError{}
+func (m *mockRepository) GetUser(ctx context.Context, id string) (*db.User, error) {
+	user, ok := m.users[id]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (m *mockRepository) ListUsers(ctx context.Context, after string, limit int) ([]db.User, error) {
+	var users []db.User
+	for _, user := range m.users {
+		if user.Username > after {
+			users = append(users, *user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (m *mockRepository) UpdateUserAccess(ctx context.Context, user *db.User) error {
+	if _, ok := m.users[user.ID]; !ok {
+		return db.ErrNotFound
+	}
+	copied := *user
+	m.users[user.ID] = &copied
+	return nil
+}
+
+func (m *mockRepository) CreateUser(ctx context.Context, username, hashedPassword string) (string, error) {
+	if _, err := m.GetUserByUsername(ctx, username); err == nil {
+		return "", &db.ConflictError{Constraint: "users_username_lower"}
+	}
+	id := fmt.Sprintf("00000000-0000-4000-8000-%012d", len(m.users)+1)
+	m.users[id] = &db.User{ID: id, Username: username, Password: hashedPassword, Role: types.RoleUser}
+	return id, nil
+}
+
+func (m *mockRepository) GetProfile(ctx context.Context, userID string) (*types.UserProfile, error) {
+	user, ok := m.users[userID]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	profile := &types.UserProfile{ID: user.ID, Username: user.Username, Role: user.Role, MFAEnabled: user.TOTPEnabledAt != nil}
+	if stored := m.profiles[userID]; stored != nil {
+		profile.Profile = *stored
+	}
+	return profile, nil
+}
+
+func (m *mockRepository) UpdateProfile(ctx context.Context, userID string, profile *types.Profile) (*types.UserProfile, error) {
+	if _, ok := m.users[userID]; !ok {
+		return nil, db.ErrNotFound
+	}
+	if profile.Email != nil {
+		for id, other := range m.profiles {
+			if id != userID && other.Email != nil && strings.EqualFold(*other.Email, *profile.Email) {
+				return nil, &db.ConflictError{Constraint: "users_email_lower"}
+			}
+		}
+	}
+	copied := *profile
+	m.profiles[userID] = &copied
+	return m.GetProfile(ctx, userID)
+}
+
+func (m *mockRepository) DeleteUser(ctx context.Context, userID string, anonymizeSubscriptions bool) error {
+	user, ok := m.users[userID]
+	if !ok {
+		return db.ErrNotFound
+	}
+	for id, sub := range m.subscriptions {
+		if sub.UserId != userID {
+			continue
+		}
+		if anonymizeSubscriptions {
+			sub.UserId = ""
+		} else {
+			delete(m.subscriptions, id)
+		}
+	}
+	for hash, key := range m.apiKeys {
+		if key.userID == userID {
+			delete(m.apiKeys, hash)
+		}
+	}
+	for _, token := range m.refreshTokens {
+		if token.userID == userID {
+			token.revoked = true
+		}
+	}
+	delete(m.loginFailures, "user:"+strings.ToLower(user.Username))
+	// Tokens of deleted users are rejected like those of a logout everywhere.
+	m.validAfter[userID] = time.Now().Add(time.Hour)
+	delete(m.profiles, userID)
+	delete(m.users, userID)
+	return nil
+}
+
+func (m *mockRepository) ExportUser(ctx context.Context, userID string) (*types.UserExport, error) {
+	profile, err := m.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	export := &types.UserExport{ExportedAt: time.Now(), User: *profile, Subscriptions: []types.UserSubscription{},
+		APIKeys: []types.APIKey{}, Sessions: []types.SessionInfo{}, AuditEvents: []types.AuditEventInfo{}}
+	for _, sub := range m.subscriptions {
+		if sub.UserId == userID {
+			export.Subscriptions = append(export.Subscriptions, *sub)
+		}
+	}
+	sort.Slice(export.Subscriptions, func(i, j int) bool { return export.Subscriptions[i].Id < export.Subscriptions[j].Id })
+	keys, _ := m.ListAPIKeys(ctx, userID)
+	export.APIKeys = append(export.APIKeys, keys...)
+	for _, token := range m.refreshTokens {
+		if token.userID == userID {
+			export.Sessions = append(export.Sessions, types.SessionInfo{})
+		}
+	}
+	for i, event := range m.auditEvents {
+		if event.UserID == userID {
+			export.AuditEvents = append(export.AuditEvents, auditEventInfo(i, &event))
+		}
+	}
+	return export, nil
+}
+
+func (m *mockRepository) UpdatePassword(ctx context.Context, userID, hashedPassword string) error {
+	user, ok := m.users[userID]
+	if !ok {
+		return db.ErrNotFound
+	}
+	user.Password = hashedPassword
+	return nil
+}
+
+func (m *mockRepository) CreatePasswordResetToken(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error {
+	m.resetTokens[tokenHash] = &mockResetToken{userID: userID, expiresAt: expiresAt}
+	return nil
+}
+
+func (m *mockRepository) ConsumePasswordResetToken(ctx context.Context, tokenHash, hashedPassword string) (string, error) {
+	token, ok := m.resetTokens[tokenHash]
+	if !ok || token.used || token.expiresAt.Before(time.Now()) {
+		return "", db.ErrNotFound
+	}
+	for _, other := range m.resetTokens {
+		if other.userID == token.userID {
+			other.used = true
+		}
+	}
+	return token.userID, m.UpdatePassword(ctx, token.userID, hashedPassword)
+}
+
+func (m *mockRepository) SetTOTPSecret(ctx context.Context, userID, secret string) error {
+	user, ok := m.users[userID]
+	if !ok || user.TOTPEnabledAt != nil {
+		return db.ErrNotFound
+	}
+	user.TOTPSecret = secret
+	return nil
+}
+
+func (m *mockRepository) EnableTOTP(ctx context.Context, userID string, step int64, recoveryHashes []string) error {
+	user, ok := m.users[userID]
+	if !ok || user.TOTPSecret == "" || user.TOTPEnabledAt != nil {
+		return db.ErrNotFound
+	}
+	now := time.Now()
+	user.TOTPEnabledAt = &now
+	m.totpSteps[userID] = step
+	m.recoveryCodes[userID] = map[string]bool{}
+	for _, hash := range recoveryHashes {
+		m.recoveryCodes[userID][hash] = false
+	}
+	return nil
+}
+
+func (m *mockRepository) DisableTOTP(ctx context.Context, userID string) error {
+	user := m.users[userID]
+	user.TOTPSecret, user.TOTPEnabledAt = "", nil
+	delete(m.totpSteps, userID)
+	delete(m.recoveryCodes, userID)
+	return nil
+}
+
+func (m *mockRepository) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	if last, ok := m.totpSteps[userID]; ok && last >= step {
+		return false, nil
+	}
+	m.totpSteps[userID] = step
+	return true, nil
+}
+
+func (m *mockRepository) UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error) {
+	used, ok := m.recoveryCodes[userID][codeHash]
+	if !ok || used {
+		return false, nil
+	}
+	m.recoveryCodes[userID][codeHash] = true
+	return true, nil
+}
+
+func (m *mockRepository) CreateMFAChallenge(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error {
+	m.challenges[tokenHash] = &mockChallenge{userID: userID}
+	return nil
+}
+
+func (m *mockRepository) GetMFAChallenge(ctx context.Context, tokenHash string) (string, error) {
+	challenge, ok := m.challenges[tokenHash]
+	if !ok || challenge.used || challenge.failures >= db.MaxMFAAttempts {
+		return "", db.ErrNotFound
+	}
+	return challenge.userID, nil
+}
+
+func (m *mockRepository) FailMFAChallenge(ctx context.Context, tokenHash string) error {
+	m.challenges[tokenHash].failures++
+	return nil
+}
+
+func (m *mockRepository) CompleteMFAChallenge(ctx context.Context, tokenHash string) error {
+	if _, err := m.GetMFAChallenge(ctx, tokenHash); err != nil {
+		return err
+	}
+	m.challenges[tokenHash].used = true
+	return nil
+}
+
+func (m *mockRepository) CreateRefreshToken(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error {
+	m.refreshTokens[tokenHash] = &mockRefreshToken{userID: userID, family: tokenHash}
+	return nil
+}
+
+func (m *mockRepository) RotateRefreshToken(ctx context.Context, oldHash, newHash string, expiresAt time.Time) (string, error) {
+	old, ok := m.refreshTokens[oldHash]
+	if !ok {
+		return "", db.ErrNotFound
+	}
+	if old.used || old.revoked {
+		m.revokeFamily(old.family)
+		return "", db.ErrRefreshTokenReused
+	}
+	old.used = true
+	m.refreshTokens[newHash] = &mockRefreshToken{userID: old.userID, family: old.family}
+	return old.userID, nil
+}
+
+func (m *mockRepository) revokeFamily(family string) {
+	for _, token := range m.refreshTokens {
+		if token.family == family {
+			token.revoked = true
+		}
+	}
+}
+
+func (m *mockRepository) RevokeRefreshToken(ctx context.Context, userID, tokenHash string) error {
+	if token, ok := m.refreshTokens[tokenHash]; ok && token.userID == userID {
+		m.revokeFamily(token.family)
+	}
+	return nil
+}
+
+func (m *mockRepository) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	m.revokedJTIs[jti] = true
+	return nil
+}
+
+func (m *mockRepository) RevokeUserTokens(ctx context.Context, userID string) error {
+	for _, token := range m.refreshTokens {
+		if token.userID == userID {
+			token.revoked = true
+		}
+	}
+	m.validAfter[userID] = time.Now()
+	return nil
+}
+
+func (m *mockRepository) AuthenticateAccessToken(ctx context.Context, jti, userID string, issuedAt time.Time) (string, error) {
+	user := m.users[userID]
+	if user == nil || user.DisabledAt != nil || m.revokedJTIs[jti] || !m.validAfter[userID].Before(issuedAt) {
+		return "", db.ErrNotFound
+	}
+	return user.Role, nil
+}
+
+func (m *mockRepository) CreateAPIKey(ctx context.Context, userID, keyHash string, key *types.APIKey) error {
+	key.ID = int64(len(m.apiKeys) + 1)
+	key.CreatedAt = time.Now()
+	m.apiKeys[keyHash] = &mockAPIKey{APIKey: *key, userID: userID}
+	return nil
+}
+
+func (m *mockRepository) ListAPIKeys(ctx context.Context, userID string) ([]types.APIKey, error) {
+	var keys []types.APIKey
+	for _, key := range m.apiKeys {
+		if key.userID == userID && !key.revoked {
+			keys = append(keys, key.APIKey)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID > keys[j].ID })
+	return keys, nil
+}
+
+func (m *mockRepository) apiKey(userID string, id int64) *mockAPIKey {
+	for _, key := range m.apiKeys {
+		if key.ID == id && key.userID == userID && !key.revoked {
+			return key
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) RenameAPIKey(ctx context.Context, userID string, id int64, name string) (*types.APIKey, error) {
+	key := m.apiKey(userID, id)
+	if key == nil {
+		return nil, db.ErrNotFound
+	}
+	key.Name = name
+	copied := key.APIKey
+	return &copied, nil
+}
+
+func (m *mockRepository) RevokeAPIKey(ctx context.Context, userID string, id int64) error {
+	key := m.apiKey(userID, id)
+	if key == nil {
+		return db.ErrNotFound
+	}
+	key.revoked = true
+	return nil
+}
+
+func (m *mockRepository) AuthenticateAPIKey(ctx context.Context, keyHash string) (*db.APIKeyOwner, error) {
+	key, ok := m.apiKeys[keyHash]
+	if !ok || key.revoked {
+		return nil, db.ErrNotFound
+	}
+	user := m.users[key.userID]
+	if user == nil || user.DisabledAt != nil {
+		return nil, db.ErrNotFound
+	}
+	now := time.Now()
+	key.LastUsedAt = &now
+	return &db.APIKeyOwner{KeyID: key.ID, UserID: key.userID, Role: user.Role, Scope: key.Scope}, nil
+}
+
+func (m *mockRepository) LoginLockedUntil(ctx context.Context, keys ...string) (time.Time, error) {
+	var until time.Time
+	for _, key := range keys {
+		if failure, ok := m.loginFailures[key]; ok && failure.lockedUntil.After(until) && failure.lockedUntil.After(time.Now()) {
+			until = failure.lockedUntil
+		}
+	}
+	return until, nil
+}
+
+func (m *mockRepository) RecordLoginFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	if m.loginFailures[key] == nil {
+		m.loginFailures[key] = &mockLoginFailure{}
+	}
+	m.loginFailures[key].failures++
+	return m.loginFailures[key].failures, nil
+}
+
+func (m *mockRepository) LockLogin(ctx context.Context, key string, until time.Time) error {
+	m.loginFailures[key].lockedUntil = until
+	return nil
+}
+
+func (m *mockRepository) ClearLoginFailures(ctx context.Context, key string) error {
+	delete(m.loginFailures, key)
+	return nil
+}
+
+func (m *mockRepository) RecordAuditEvent(ctx context.Context, event *db.AuditEvent) error {
+	if m.auditErr != nil {
+		return m.auditErr
+	}
+	m.auditEvents = append(m.auditEvents, *event)
+	return nil
+}
+
+// auditEventsOf returns the recorded audit events of kind event.
+func (m *mockRepository) auditEventsOf(event string) []db.AuditEvent {
+	var events []db.AuditEvent
+	for _, e := range m.auditEvents {
+		if e.Event == event {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// auditEventInfo is the listed form of the i-th recorded audit event, whose
+// ID is i+1.
+func auditEventInfo(i int, event *db.AuditEvent) types.AuditEventInfo {
+	info := types.AuditEventInfo{ID: int64(i + 1), Event: event.Event, Changes: event.Changes}
+	for dst, v := range map[**string]string{&info.UserID: event.UserID, &info.ActorID: event.ActorID,
+		&info.RequestID: event.RequestID, &info.IP: event.IP} {
+		if v != "" {
+			*dst = &v
+		}
+	}
+	if event.SubscriptionID != 0 {
+		info.SubscriptionID = &event.SubscriptionID
+	}
+	return info
+}
+
+func (m *mockRepository) ListAuditEvents(ctx context.Context, q *types.AuditEventQuery) ([]types.AuditEventInfo, error) {
+	var events []types.AuditEventInfo
+	for i := len(m.auditEvents) - 1; i >= 0 && len(events) < q.Limit; i-- {
+		event := &m.auditEvents[i]
+		if (q.UserID != "" && event.UserID != q.UserID) || (q.ActorID != "" && event.ActorID != q.ActorID) ||
+			(q.Event != "" && event.Event != q.Event) || (q.Before > 0 && int64(i+1) >= q.Before) {
+			continue
+		}
+		events = append(events, auditEventInfo(i, event))
+	}
+	return events, nil
 }
 
 func TestReadSubscription_ValidID(t *testing.T) {
@@ -102,7 +698,7 @@ func TestReadSubscription_ValidID(t *testing.T) {
 	}
 
 	req := httptest.NewRequest("GET", "/subscription/1", nil)
-	req.Header.Set("User-ID", "user123")
+	req = withPrincipal(req, "user123")
 	rctx := chi.NewRouteContext()
 	rctx.URLParams.Add("id", "1")
 	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
@@ -129,6 +725,7 @@ func TestReadSubscription_InvalidID(t *testing.T) {
 	rctx := chi.NewRouteContext()
 	rctx.URLParams.Add("id", "invalid")
 	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	req = withPrincipal(req, "user123")
 	w := httptest.NewRecorder()
 
 	app.ReadSubscription(w, req)
@@ -145,6 +742,7 @@ func TestReadSubscription_NotFound(t *testing.T) {
 	rctx := chi.NewRouteContext()
 	rctx.URLParams.Add("id", "999")
 	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	req = withPrincipal(req, "user123")
 	w := httptest.NewRecorder()
 
 	app.ReadSubscription(w, req)
@@ -163,7 +761,7 @@ func TestListSubscription_ValidUser(t *testing.T) {
 	repo.subscriptions[2] = &types.UserSubscription{Id: 2, ServiceName: "Spotify", Price: 499, UserId: "user123", StartDate: &startDate}
 
 	req := httptest.NewRequest("GET", "/subscriptionList", nil)
-	req.Header.Set("User-ID", "user123")
+	req = withPrincipal(req, "user123")
 	w := httptest.NewRecorder()
 
 	app.ListSubscription(w, req)
@@ -195,7 +793,7 @@ func TestListSubscription_WithLimit(t *testing.T) {
 	}
 
 	req := httptest.NewRequest("GET", "/subscriptionList?limit=3", nil)
-	req.Header.Set("User-ID", "user123")
+	req = withPrincipal(req, "user123")
 	w := httptest.NewRecorder()
 
 	app.ListSubscription(w, req)
@@ -221,7 +819,7 @@ func TestDeleteSubscription_ValidID(t *testing.T) {
 	}
 
 	req := httptest.NewRequest("DELETE", "/subscription/1", nil)
-	req.Header.Set("User-ID", "user123")
+	req = withPrincipal(req, "user123")
 	rctx := chi.NewRouteContext()
 	rctx.URLParams.Add("id", "1")
 	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
@@ -232,8 +830,8 @@ func TestDeleteSubscription_ValidID(t *testing.T) {
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
 	}
-	if _, exists := repo.subscriptions[1]; exists {
-		t.Error("Subscription should be deleted")
+	if sub := repo.subscriptions[1]; sub == nil || sub.DeletedAt == nil {
+		t.Error("Subscription should be moved to the trash")
 	}
 }
 
@@ -244,6 +842,7 @@ func TestDeleteSubscription_NotFound(t *testing.T) {
 	rctx := chi.NewRouteContext()
 	rctx.URLParams.Add("id", "999")
 	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	req = withPrincipal(req, "user123")
 	w := httptest.NewRecorder()
 
 	app.DeleteSubscription(w, req)
@@ -252,3 +851,607 @@ func TestDeleteSubscription_NotFound(t *testing.T) {
 		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
 	}
 }
+
+func TestDeleteSubscription_AdminDeletesForOwner(t *testing.T) {
+	repo := newMockRepository()
+	app := newTestApp(repo)
+	seedSubscription(repo, 1, "owner")
+
+	req := withIDParam(httptest.NewRequest("DELETE", "/subscription/1", nil), "1")
+	req = req.WithContext(ContextWithPrincipal(req.Context(), &Principal{
+		UserID: "admin", Roles: []string{types.RoleAdmin}, AuthMethod: AuthMethodJWT,
+	}))
+	w := httptest.NewRecorder()
+
+	app.DeleteSubscription(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if sub := repo.subscriptions[1]; sub == nil || sub.DeletedAt == nil {
+		t.Error("Subscription should be moved to the trash")
+	}
+	if repo.transactions != 1 {
+		t.Errorf("Expected the check and delete in one transaction, got %d", repo.transactions)
+	}
+}
+
+func TestDeleteSubscription_Forbidden(t *testing.T) {
+	repo := newMockRepository()
+	app := newTestApp(repo)
+	seedSubscription(repo, 1, "owner")
+
+	req := withPrincipal(withIDParam(httptest.NewRequest("DELETE", "/subscription/1", nil), "1"), "intruder")
+	w := httptest.NewRecorder()
+
+	app.DeleteSubscription(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+	if sub := repo.subscriptions[1]; sub == nil || sub.DeletedAt != nil {
+		t.Error("Subscription should not be deleted by another user")
+	}
+}
+
+func withIDParam(req *http.Request, id string) *http.Request {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", id)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
+func seedSubscription(repo *mockRepository, id int64, userID string) *types.UserSubscription {
+	startDate, anchor := "2023-01-01", "2023-01-01"
+	sub := &types.UserSubscription{
+		Id: id, ServiceName: "Netflix", Price: 999, Currency: "RUB", UserId: userID,
+		StartDate: &startDate, BillingPeriod: "monthly", BillingAnchor: &anchor, Version: 1,
+	}
+	repo.subscriptions[id] = sub
+	return sub
+}
+
+func TestUpdateSubscription_ByID(t *testing.T) {
+	repo := newMockRepository()
+	app := newTestApp(repo)
+	seedSubscription(repo, 1, "user123")
+	seedSubscription(repo, 2, "user123")
+
+	startDate := "2023-02-01"
+	jsonData, _ := json.Marshal(types.UserSubscription{ServiceName: "Netflix Premium", Price: 1299, StartDate: &startDate})
+	req := withIDParam(httptest.NewRequest("PUT", "/subscription/1", bytes.NewBuffer(jsonData)), "1")
+	req = withPrincipal(req, "user123")
+	w := httptest.NewRecorder()
+
+	app.UpdateSubscription(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if got := repo.subscriptions[1]; got.ServiceName != "Netflix Premium" || got.Price != 1299 {
+		t.Errorf("Subscription 1 was not updated: %+v", got)
+	}
+	if got := repo.subscriptions[2]; got.ServiceName != "Netflix" || got.Price != 999 {
+		t.Errorf("Subscription 2 should be untouched: %+v", got)
+	}
+}
+
+func TestUpdateSubscription_Forbidden(t *testing.T) {
+	repo := newMockRepository()
+	app := newTestApp(repo)
+	seedSubscription(repo, 1, "owner")
+
+	startDate := "2023-02-01"
+	jsonData, _ := json.Marshal(types.UserSubscription{ServiceName: "Hijacked", Price: 1, StartDate: &startDate})
+	req := withIDParam(httptest.NewRequest("PUT", "/subscription/1", bytes.NewBuffer(jsonData)), "1")
+	req = withPrincipal(req, "intruder")
+	w := httptest.NewRecorder()
+
+	app.UpdateSubscription(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+	if repo.subscriptions[1].ServiceName != "Netflix" {
+		t.Error("Subscription should not be modified by another user")
+	}
+}
+
+func TestUpdateSubscription_MissingStartDate(t *testing.T) {
+	repo := newMockRepository()
+	app := newTestApp(repo)
+	seedSubscription(repo, 1, "user123")
+
+	jsonData, _ := json.Marshal(types.UserSubscription{ServiceName: "Netflix", Price: 1299})
+	req := withIDParam(httptest.NewRequest("PUT", "/subscription/1", bytes.NewBuffer(jsonData)), "1")
+	req = withPrincipal(req, "user123")
+	w := httptest.NewRecorder()
+
+	app.UpdateSubscription(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestPatchSubscription_PriceOnly(t *testing.T) {
+	repo := newMockRepository()
+	app := newTestApp(repo)
+	seedSubscription(repo, 1, "user123")
+
+	req := withIDParam(httptest.NewRequest("PATCH", "/subscription/1", bytes.NewBufferString(`{"price":1499}`)), "1")
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req = withPrincipal(req, "user123")
+	w := httptest.NewRecorder()
+
+	app.PatchSubscription(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var response types.UserSubscription
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal("Failed to unmarshal response")
+	}
+	if response.Price != 1499 || response.ServiceName != "Netflix" || *response.StartDate != "2023-01-01" {
+		t.Errorf("Unexpected patched subscription: %+v", response)
+	}
+}
+
+func TestPatchSubscription_SetAndClearEndDate(t *testing.T) {
+	repo := newMockRepository()
+	app := newTestApp(repo)
+	seedSubscription(repo, 1, "user123")
+
+	for _, tt := range []struct {
+		patch    string
+		expected *string
+	}{
+		{`{"end_date":"2023-06-30"}`, func() *string { s := "2023-06-30"; return &s }()},
+		{`{"end_date":null}`, nil},
+	} {
+		req := withIDParam(httptest.NewRequest("PATCH", "/subscription/1", bytes.NewBufferString(tt.patch)), "1")
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		req = withPrincipal(req, "user123")
+		w := httptest.NewRecorder()
+
+		app.PatchSubscription(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		got := repo.subscriptions[1].EndDate
+		if (got == nil) != (tt.expected == nil) || (got != nil && *got != *tt.expected) {
+			t.Errorf("Patch %s: unexpected end date %v", tt.patch, got)
+		}
+	}
+}
+
+func TestPatchSubscription_InvalidRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		expected    int
+	}{
+		{"Wrong content type", "text/plain", `{"price":1}`, http.StatusUnsupportedMediaType},
+		{"Malformed patch", "application/merge-patch+json", `{"price":`, http.StatusBadRequest},
+		{"Wrong field type", "application/merge-patch+json", `{"price":"free"}`, http.StatusBadRequest},
+		{"Removes start date", "application/merge-patch+json", `{"start_date":null}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newMockRepository()
+			app := newTestApp(repo)
+			seedSubscription(repo, 1, "user123")
+
+			req := withIDParam(httptest.NewRequest("PATCH", "/subscription/1", bytes.NewBufferString(tt.body)), "1")
+			req.Header.Set("Content-Type", tt.contentType)
+			req = withPrincipal(req, "user123")
+			w := httptest.NewRecorder()
+
+			app.PatchSubscription(w, req)
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
+
+func TestListSubscription_Filters(t *testing.T) {
+	repo := newMockRepository()
+	app := newTestApp(repo)
+
+	req := httptest.NewRequest("GET", "/subscriptionList?service_name_prefix=net&service_name_contains=FLIX"+
+		"&min_price=100&max_price=2000&active_on=2024-03-01&status=active&updated_since=2024-03-01T10:00:00Z"+
+		"&sort_by=price&order=desc&limit=5", nil)
+	req = withPrincipal(req, "user123")
+	w := httptest.NewRecorder()
+
+	app.ListSubscription(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	q := repo.lastListQuery
+	if q.ServiceNamePrefix != "net" || q.ServiceNameContains != "FLIX" {
+		t.Errorf("Unexpected service name filters: %+v", q)
+	}
+	if q.MinPrice == nil || *q.MinPrice != 100 || q.MaxPrice == nil || *q.MaxPrice != 2000 {
+		t.Errorf("Unexpected price filters: %+v", q)
+	}
+	if q.ActiveOn == nil || *q.ActiveOn != "2024-03-01" || q.Status != types.StatusActive {
+		t.Errorf("Unexpected date filters: %+v", q)
+	}
+	if q.UpdatedSince == nil || !q.UpdatedSince.Equal(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected updated_since filter: %v", q.UpdatedSince)
+	}
+	// One extra row is requested to find out whether another page exists.
+	if q.SortBy != types.SortByPrice || !q.Descending || q.Limit != 6 {
+		t.Errorf("Unexpected ordering: %+v", q)
+	}
+}
+
+func TestListSubscription_UpdatedSince(t *testing.T) {
+	repo := newMockRepository()
+	app := newTestApp(repo)
+	old, recent := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	seedSubscription(repo, 1, "user123").UpdatedAt = &old
+	seedSubscription(repo, 2, "user123").UpdatedAt = &recent
+
+	req := withPrincipal(httptest.NewRequest("GET", "/subscriptionList?updated_since=2024-03-01T00:00:00%2B03:00", nil), "user123")
+	w := httptest.NewRecorder()
+	app.ListSubscription(w, req)
+
+	var response types.SubscriptionListResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %s", w.Body.String())
+	}
+	if len(response.Data) != 1 || response.Data[0].Id != 2 {
+		t.Fatalf("Expected only the recently updated subscription, got %+v", response.Data)
+	}
+	if got := response.Data[0].UpdatedAt; got == nil || !got.Equal(recent) {
+		t.Errorf("Expected updated_at %v, got %v", recent, got)
+	}
+}
+
+func TestUpdateSubscription_Timestamps(t *testing.T) {
+	repo := newMockRepository()
+	app := newTestApp(repo)
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sub := seedSubscription(repo, 1, "user123")
+	sub.CreatedAt, sub.UpdatedAt = &created, &created
+
+	req := withIDParam(httptest.NewRequest("PATCH", "/subscription/1",
+		bytes.NewBufferString(`{"price":1499,"created_at":"2000-01-01T00:00:00Z"}`)), "1")
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	w := httptest.NewRecorder()
+	app.PatchSubscription(w, withPrincipal(req, "user123"))
+
+	var response types.UserSubscription
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %s", w.Body.String())
+	}
+	if response.CreatedAt == nil || !response.CreatedAt.Equal(created) {
+		t.Errorf("Expected created_at to stay %v, got %v", created, response.CreatedAt)
+	}
+	if response.UpdatedAt == nil || !response.UpdatedAt.After(created) {
+		t.Errorf("Expected updated_at to move past %v, got %v", created, response.UpdatedAt)
+	}
+}
+
+// tampered encodes a cursor for sortBy whose sort key is value, or missing
+// when value is empty.
+func tampered(sortBy, value string) string {
+	cursor := types.ListCursor{SortBy: sortBy, ID: 1}
+	if value != "" {
+		cursor.Value = &value
+	}
+	return service.EncodeCursor(cursor)
+}
+
+func TestListSubscription_InvalidParams(t *testing.T) {
+	otherSort := service.EncodeCursor(types.ListCursor{SortBy: types.SortByPrice, ID: 1})
+	tests := []string{
+		"limit=0",
+		"limit=abc",
+		"min_price=cheap",
+		"active_on=yesterday",
+		"status=paused",
+		"updated_since=2024-03-01",
+		"sort_by=user_id",
+		"order=sideways",
+		"cursor=not.a.cursor",
+		"cursor=" + otherSort,
+		"sort_by=price&cursor=" + tampered(types.SortByPrice, "abc"),
+		"sort_by=price&cursor=" + tampered(types.SortByPrice, ""),
+		"sort_by=start_date&cursor=" + tampered(types.SortByStartDate, "soon"),
+		"sort_by=start_date&cursor=" + tampered(types.SortByStartDate, ""),
+		"sort_by=end_date&cursor=" + tampered(types.SortByEndDate, "2024-13-01"),
+		"sort_by=service_name&cursor=" + tampered(types.SortByServiceName, ""),
+		"sort_by=updated_at&cursor=" + tampered(types.SortByUpdatedAt, "2024-03-01"),
+		"sort_by=price&after_id=3",
+	}
+
+	for _, params := range tests {
+		t.Run(params, func(t *testing.T) {
+			app := newTestApp(newMockRepository())
+			req := httptest.NewRequest("GET", "/subscriptionList?"+params, nil)
+			req = withPrincipal(req, "user123")
+			w := httptest.NewRecorder()
+
+			app.ListSubscription(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+			}
+		})
+	}
+}
+
+func TestListSubscription_CursorFollowsSortKey(t *testing.T) {
+	repo := newMockRepository()
+	app := newTestApp(repo)
+	seedSubscription(repo, 1, "user123")
+	seedSubscription(repo, 2, "user123").Price = 1500
+
+	req := httptest.NewRequest("GET", "/subscriptionList?sort_by=price&limit=1", nil)
+	req = withPrincipal(req, "user123")
+	w := httptest.NewRecorder()
+
+	app.ListSubscription(w, req)
+
+	var response struct {
+		NextCursor  *string `json:"next_cursor"`
+		NextAfterID *int64  `json:"next_after_id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal("Failed to unmarshal response")
+	}
+	if response.NextAfterID != nil {
+		t.Error("next_after_id should only be set for id order")
+	}
+	if response.NextCursor == nil {
+		t.Fatal("Expected next_cursor")
+	}
+	cursor, err := service.DecodeCursor(*response.NextCursor)
+	if err != nil {
+		t.Fatalf("Invalid cursor: %v", err)
+	}
+	if cursor.SortBy != types.SortByPrice || cursor.ID != 1 || cursor.Value == nil || *cursor.Value != "999" {
+		t.Errorf("Unexpected cursor: %+v", cursor)
+	}
+
+	req = httptest.NewRequest("GET", "/subscriptionList?sort_by=price&limit=1&cursor="+*response.NextCursor, nil)
+	req = withPrincipal(req, "user123")
+	w = httptest.NewRecorder()
+
+	app.ListSubscription(w, req)
+
+	if w.Code != http.StatusOK || repo.lastListQuery.After == nil || repo.lastListQuery.After.ID != 1 {
+		t.Errorf("Cursor was not passed to the repository: %d %+v", w.Code, repo.lastListQuery.After)
+	}
+}
+
+func TestListSubscription_PageMetadata(t *testing.T) {
+	repo := newMockRepository()
+	app := newTestApp(repo)
+	for i := int64(1); i <= 5; i++ {
+		seedSubscription(repo, i, "user123")
+	}
+
+	get := func(target string) (*httptest.ResponseRecorder, types.SubscriptionListResponse) {
+		req := httptest.NewRequest("GET", target, nil)
+		req = withPrincipal(req, "user123")
+		w := httptest.NewRecorder()
+		app.ListSubscription(w, req)
+		var response types.SubscriptionListResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal("Failed to unmarshal response")
+		}
+		return w, response
+	}
+
+	w, first := get("/subscriptionList?limit=3&service_name_prefix=net&include_total=true")
+	if len(first.Data) != 3 || !first.HasMore || first.NextCursor == nil {
+		t.Fatalf("Unexpected first page: %+v", first)
+	}
+	if first.Total == nil || *first.Total != 5 {
+		t.Errorf("Expected total 5, got %v", first.Total)
+	}
+	if first.Data[0].ETag != `"1"` {
+		t.Errorf(`Expected list items to carry ETag "1", got %q`, first.Data[0].ETag)
+	}
+	if first.NextAfterID == nil || *first.NextAfterID != 3 {
+		t.Errorf("Expected next_after_id 3, got %v", first.NextAfterID)
+	}
+	link := w.Header().Get("Link")
+	if !strings.HasPrefix(link, "</subscriptionList?") || !strings.HasSuffix(link, `>; rel="next"`) ||
+		!strings.Contains(link, "cursor="+*first.NextCursor) || !strings.Contains(link, "service_name_prefix=net") {
+		t.Errorf("Unexpected Link header: %s", link)
+	}
+
+	w, last := get("/subscriptionList?limit=3&cursor=" + *first.NextCursor)
+	if len(last.Data) != 2 || last.HasMore || last.NextCursor != nil || last.NextAfterID != nil {
+		t.Errorf("Unexpected last page: %+v", last)
+	}
+	if last.Total != nil {
+		t.Error("Total should only be returned when requested")
+	}
+	if w.Header().Get("Link") != "" {
+		t.Error("Last page should not have a next link")
+	}
+}
+
+func TestReadSubscription_ETag(t *testing.T) {
+	repo := newMockRepository()
+	app := newTestApp(repo)
+	seedSubscription(repo, 1, "user123")
+
+	read := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := withPrincipal(withIDParam(httptest.NewRequest("GET", "/subscription/1", nil), "1"), "user123")
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		app.ReadSubscription(w, req)
+		return w
+	}
+
+	w := read("")
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"1"` {
+		t.Fatalf(`Expected 200 with ETag "1", got %d with %q`, w.Code, w.Header().Get("ETag"))
+	}
+	if w := read(`W/"1"`); w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != `"1"` {
+		t.Errorf("Expected an empty 304 for a matching If-None-Match, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := read(`"0"`); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 for an outdated If-None-Match, got %d", w.Code)
+	}
+}
+
+func TestWriteSubscription_IfMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		ifMatch  string
+		required bool
+		status   int
+		code     string
+	}{
+		{"PUT current version", "PUT", `"1"`, true, http.StatusOK, ""},
+		{"PUT any version", "PUT", "*", true, http.StatusOK, ""},
+		{"PUT without header when optional", "PUT", "", false, http.StatusOK, ""},
+		{"PUT without header when required", "PUT", "", true, http.StatusPreconditionRequired, service.CodePreconditionRequired},
+		{"PUT outdated version", "PUT", `"0"`, false, http.StatusPreconditionFailed, service.CodePreconditionFailed},
+		{"PUT weak tag", "PUT", `W/"1"`, false, http.StatusPreconditionFailed, service.CodePreconditionFailed},
+		{"PATCH current version", "PATCH", `"1"`, true, http.StatusOK, ""},
+		{"PATCH outdated version", "PATCH", `"2", "3"`, true, http.StatusPreconditionFailed, service.CodePreconditionFailed},
+		{"DELETE current version", "DELETE", `"1"`, true, http.StatusOK, ""},
+		{"DELETE without header when required", "DELETE", "", true, http.StatusPreconditionRequired, service.CodePreconditionRequired},
+		{"DELETE outdated version", "DELETE", `"0"`, true, http.StatusPreconditionFailed, service.CodePreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newMockRepository()
+			app := newTestApp(repo)
+			app.requireIfMatch = tt.required
+			seedSubscription(repo, 1, "user123")
+
+			body := `{"service_name":"Netflix Premium","price":1299,"start_date":"2023-02-01"}`
+			if tt.method == "PATCH" {
+				body = `{"price":1299}`
+			}
+			req := withIDParam(httptest.NewRequest(tt.method, "/subscription/1", bytes.NewBufferString(body)), "1")
+			req.Header.Set("Content-Type", "application/merge-patch+json")
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			req = withPrincipal(req, "user123")
+			w := httptest.NewRecorder()
+
+			map[string]http.HandlerFunc{
+				"PUT": app.UpdateSubscription, "PATCH": app.PatchSubscription, "DELETE": app.DeleteSubscription,
+			}[tt.method](w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			if tt.code != "" {
+				var problem service.Problem
+				json.Unmarshal(w.Body.Bytes(), &problem)
+				if problem.Code != tt.code {
+					t.Errorf("Expected code %s, got %s", tt.code, problem.Code)
+				}
+				if repo.subscriptions[1] == nil || repo.subscriptions[1].Price != 999 {
+					t.Error("Subscription should be unchanged")
+				}
+			}
+			if tt.status == http.StatusOK && tt.method != "DELETE" && w.Header().Get("ETag") != `"2"` {
+				t.Errorf(`Expected the new ETag "2", got %q`, w.Header().Get("ETag"))
+			}
+		})
+	}
+}
+
+func TestSaveSubscription_StaleVersion(t *testing.T) {
+	repo := newMockRepository()
+	sub := *seedSubscription(repo, 1, "user123")
+	repo.subscriptions[1].Version = 2
+
+	_, err := saveSubscription(context.Background(), repo, &sub)
+	var problem *service.Problem
+	if !errors.As(err, &problem) || problem.Status != http.StatusPreconditionFailed {
+		t.Errorf("Expected a 412 problem for a stale version, got %v", err)
+	}
+}
+
+func TestListSubscription_LimitBounds(t *testing.T) {
+	repo := newMockRepository()
+	app := newTestApp(repo)
+
+	for target, expected := range map[string]int{
+		"/subscriptionList":           defaultListLimit + 1,
+		"/subscriptionList?limit=500": maxListLimit + 1,
+	} {
+		req := httptest.NewRequest("GET", target, nil)
+		req = withPrincipal(req, "user123")
+		app.ListSubscription(httptest.NewRecorder(), req)
+
+		if repo.lastListQuery.Limit != expected {
+			t.Errorf("%s: expected repository limit %d, got %d", target, expected, repo.lastListQuery.Limit)
+		}
+	}
+}
+
+func TestListSubscription_EmptyData(t *testing.T) {
+	app := newTestApp(newMockRepository())
+
+	req := httptest.NewRequest("GET", "/subscriptionList", nil)
+	req = withPrincipal(req, "user123")
+	w := httptest.NewRecorder()
+
+	app.ListSubscription(w, req)
+
+	if !strings.Contains(w.Body.String(), `"data":[]`) {
+		t.Errorf("Expected empty data array, got %s", w.Body.String())
+	}
+}
+
+func TestPatchSubscription_Validation(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		field string
+	}{
+		{"Unknown field", `{"colour":"red"}`, "colour"},
+		{"End before start", `{"end_date":"2000-01-01"}`, "end_date"},
+		{"Removing start date", `{"start_date":null}`, "start_date"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newMockRepository()
+			app := newTestApp(repo)
+			seedSubscription(repo, 1, "user123")
+
+			req := withIDParam(httptest.NewRequest("PATCH", "/subscription/1", bytes.NewBufferString(tt.patch)), "1")
+			req.Header.Set("Content-Type", service.MergePatchContentType)
+			req = withPrincipal(req, "user123")
+			w := httptest.NewRecorder()
+
+			app.PatchSubscription(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+			}
+			var problem service.Problem
+			json.Unmarshal(w.Body.Bytes(), &problem)
+			if len(problem.Errors) != 1 || problem.Errors[0].Field != tt.field {
+				t.Errorf("Expected a violation for %s, got %+v", tt.field, problem.Errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"crudl_service/src/db"
+	"crudl_service/src/service"
+	"crudl_service/src/types"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// issueTokens starts a new session for userID: it stores a fresh refresh
+// token family and responds with an access and refresh token pair.
+func (a *App) issueTokens(w http.ResponseWriter, r *http.Request, userID, role string, status int) {
+	refreshToken, err := newOpaqueToken(32)
+	if err != nil {
+		writeError(w, r, err, "Token generation failed")
+		return
+	}
+	if err := a.repo.CreateRefreshToken(r.Context(), userID, hashToken(refreshToken), time.Now().Add(a.refreshTTL)); err != nil {
+		writeError(w, r, err, "Token generation failed")
+		return
+	}
+	a.sendAuthResponse(w, r, userID, role, refreshToken, status)
+}
+
+func (a *App) sendAuthResponse(w http.ResponseWriter, r *http.Request, userID, role, refreshToken string, status int) {
+	token, err := a.generateJWT(userID, role)
+	if err != nil {
+		writeError(w, r, err, "Token generation failed")
+		return
+	}
+	body, err := json.Marshal(types.AuthResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(a.accessTTL / time.Second),
+		UserID:       userID,
+	})
+	if err != nil {
+		writeError(w, r, err, "Failed to marshal auth response")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// RefreshToken exchanges a refresh token for a new token pair. The presented
+// refresh token is used up; presenting it again revokes the whole session.
+func (a *App) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var request types.RefreshTokenRequest
+	if !service.ReadUserData(w, r, &request) {
+		return
+	}
+	refreshToken, err := newOpaqueToken(32)
+	if err != nil {
+		writeError(w, r, err, "Token generation failed")
+		return
+	}
+	userID, err := a.repo.RotateRefreshToken(r.Context(), hashToken(request.RefreshToken), hashToken(refreshToken), time.Now().Add(a.refreshTTL))
+	switch {
+	case errors.Is(err, db.ErrRefreshTokenReused):
+		writeProblem(w, r, http.StatusUnauthorized, service.CodeRefreshTokenReused, "Refresh token was already used; the session has been revoked")
+		return
+	case errors.Is(err, db.ErrNotFound):
+		writeProblem(w, r, http.StatusUnauthorized, service.CodeUnauthorized, "Invalid or expired refresh token")
+		return
+	case err != nil:
+		writeError(w, r, err, "Failed to refresh token")
+		return
+	}
+	user, err := a.repo.GetUser(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err, "Failed to refresh token")
+		return
+	}
+	if user.DisabledAt != nil {
+		writeProblem(w, r, http.StatusForbidden, service.CodeAccountDisabled, "Account is disabled")
+		return
+	}
+	a.sendAuthResponse(w, r, user.ID, user.Role, refreshToken, http.StatusOK)
+}
+
+// Logout revokes the access token of the request and the session of the
+// given refresh token.
+func (a *App) Logout(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+	var request types.RefreshTokenRequest
+	if !service.ReadUserData(w, r, &request) {
+		return
+	}
+	if err := a.repo.RevokeRefreshToken(r.Context(), principal.UserID, hashToken(request.RefreshToken)); err != nil {
+		writeError(w, r, err, "Failed to log out")
+		return
+	}
+	if err := a.repo.RevokeAccessToken(r.Context(), principal.TokenID, principal.TokenExpiresAt); err != nil {
+		writeError(w, r, err, "Failed to log out")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutAll revokes every session of the authenticated user, including all
+// access tokens issued so far.
+func (a *App) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	if err := a.repo.RevokeUserTokens(r.Context(), principal.UserID); err != nil {
+		writeError(w, r, err, "Failed to log out")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newOpaqueToken returns n random bytes encoded as URL-safe base64.
+func newOpaqueToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is the form in which opaque tokens are stored.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// JWKS publishes the public keys that verify access tokens, so that other
+// services can check tokens without sharing a secret.
+func (a *App) JWKS(w http.ResponseWriter, r *http.Request) {
+	body, err := json.Marshal(a.keys.JWKS())
+	if err != nil {
+		writeError(w, r, err, "Failed to marshal key set")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(body)
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"bytes"
+	"crudl_service/src/db"
+	"crudl_service/src/service"
+	"crudl_service/src/types"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// login starts a session for userID, registering the user with the mock
+// repository when needed, and returns its token pair.
+func login(t *testing.T, app *App, userID string) types.AuthResponse {
+	t.Helper()
+	users := app.repo.(*mockRepository).users
+	if users[userID] == nil {
+		users[userID] = &db.User{ID: userID, Username: userID, Role: types.RoleUser}
+	}
+	w := httptest.NewRecorder()
+	app.issueTokens(w, httptest.NewRequest("POST", "/login", nil), userID, users[userID].Role, http.StatusOK)
+	var response types.AuthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.RefreshToken == "" {
+		t.Fatalf("Failed to issue tokens: %s", w.Body.String())
+	}
+	return response
+}
+
+func refresh(app *App, refreshToken string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(types.RefreshTokenRequest{RefreshToken: refreshToken})
+	w := httptest.NewRecorder()
+	app.RefreshToken(w, httptest.NewRequest("POST", "/token/refresh", bytes.NewBuffer(body)))
+	return w
+}
+
+// authorized reports whether ValidateJWT accepts the access token.
+func authorized(app *App, token string) bool {
+	req := httptest.NewRequest("GET", "/protected", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	app.ValidateJWT(func(w http.ResponseWriter, r *http.Request) {})(w, req)
+	return w.Code == http.StatusOK
+}
+
+func TestRefreshToken_Rotates(t *testing.T) {
+	app := newTestApp(newMockRepository())
+	first := login(t, app, "user123")
+
+	w := refresh(app, first.RefreshToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var second types.AuthResponse
+	json.Unmarshal(w.Body.Bytes(), &second)
+	if second.RefreshToken == first.RefreshToken || second.UserID != "user123" {
+		t.Errorf("Expected a new refresh token for user123, got %+v", second)
+	}
+	if second.ExpiresIn != int64(app.accessTTL.Seconds()) {
+		t.Errorf("Expected expires_in %v, got %d", app.accessTTL.Seconds(), second.ExpiresIn)
+	}
+	if !authorized(app, second.Token) {
+		t.Error("Expected the new access token to be accepted")
+	}
+}
+
+func TestRefreshToken_ReuseRevokesFamily(t *testing.T) {
+	app := newTestApp(newMockRepository())
+	first := login(t, app, "user123")
+
+	w := refresh(app, first.RefreshToken)
+	var second types.AuthResponse
+	json.Unmarshal(w.Body.Bytes(), &second)
+
+	w = refresh(app, first.RefreshToken)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status %d on reuse, got %d", http.StatusUnauthorized, w.Code)
+	}
+	var problem service.Problem
+	json.Unmarshal(w.Body.Bytes(), &problem)
+	if problem.Code != service.CodeRefreshTokenReused {
+		t.Errorf("Expected code %s, got %s", service.CodeRefreshTokenReused, problem.Code)
+	}
+	if w := refresh(app, second.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the rest of the family to be revoked, got %d", w.Code)
+	}
+}
+
+func TestRefreshToken_Unknown(t *testing.T) {
+	app := newTestApp(newMockRepository())
+	if w := refresh(app, "unknown"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	app := newTestApp(newMockRepository())
+	session := login(t, app, "user123")
+	other := login(t, app, "user123")
+
+	body, _ := json.Marshal(types.RefreshTokenRequest{RefreshToken: session.RefreshToken})
+	req := httptest.NewRequest("POST", "/logout", bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "Bearer "+session.Token)
+	w := httptest.NewRecorder()
+	app.ValidateJWT(app.Logout)(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if authorized(app, session.Token) {
+		t.Error("Expected the access token to be revoked")
+	}
+	if w := refresh(app, session.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the refresh token to be revoked, got %d", w.Code)
+	}
+	if !authorized(app, other.Token) {
+		t.Error("Expected other sessions to stay valid")
+	}
+}
+
+func TestLogoutAll(t *testing.T) {
+	app := newTestApp(newMockRepository())
+	first := login(t, app, "user123")
+	second := login(t, app, "user123")
+	stranger := login(t, app, "user456")
+
+	req := httptest.NewRequest("POST", "/logout-all", nil)
+	req.Header.Set("Authorization", "Bearer "+first.Token)
+	w := httptest.NewRecorder()
+	app.ValidateJWT(app.LogoutAll)(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	for _, session := range []types.AuthResponse{first, second} {
+		if authorized(app, session.Token) {
+			t.Error("Expected every access token of the user to be revoked")
+		}
+		if w := refresh(app, session.RefreshToken); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected every refresh token of the user to be revoked, got %d", w.Code)
+		}
+	}
+	if !authorized(app, stranger.Token) {
+		t.Error("Expected other users to stay logged in")
+	}
+	if !authorized(app, login(t, app, "user123").Token) {
+		t.Error("Expected a session started after the logout to be accepted")
+	}
+}
+
+func TestJWKS_DoesNotPublishSecrets(t *testing.T) {
+	app := newTestApp(newMockRepository())
+
+	w := httptest.NewRecorder()
+	app.JWKS(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if body := strings.TrimSpace(w.Body.String()); body != `{"keys":[]}` {
+		t.Errorf("Expected an empty key set for an HS256-only app, got %s", body)
+	}
+}
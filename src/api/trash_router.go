@@ -0,0 +1,74 @@
+package api
+
+import (
+	"crudl_service/src/db"
+	"crudl_service/src/service"
+	"crudl_service/src/types"
+	"errors"
+	"net/http"
+)
+
+// ListTrash lists the deleted subscriptions of the authenticated user
+//
+//	@Summary		List deleted subscriptions
+//	@Description	Subscriptions in the trash, with the filters, sorting and paging of /subscriptionList. They are purged for good once the retention period ends.
+//	@Tags			subscriptions
+//	@Produce		json
+//	@Param			sort_by	query		string	false	"id, price, start_date, end_date, service_name or updated_at"
+//	@Param			order	query		string	false	"asc or desc"
+//	@Param			cursor	query		string	false	"Opaque cursor from next_cursor of the previous page"
+//	@Param			limit	query		int		false	"Page size, 20 by default and at most 100"
+//	@Success		200		{object}	types.SubscriptionListResponse	"Page of deleted subscriptions"
+//	@Failure		400		{object}	service.Problem					"Bad request"
+//	@Failure		401		{object}	service.Problem					"Unauthorized"
+//	@Router			/trash [get]
+func (a *App) ListTrash(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	a.listSubscriptions(w, r, principal.UserID, true)
+}
+
+// RestoreSubscription takes a subscription out of the trash
+//
+//	@Summary		Restore subscription
+//	@Description	Restore a deleted subscription that has not been purged yet
+//	@Tags			subscriptions
+//	@Produce		json
+//	@Param			id	path		int						true	"Subscription ID"
+//	@Success		200	{object}	types.UserSubscription	"Restored subscription"
+//	@Header			200	{string}	ETag					"New version of the subscription"
+//	@Failure		400	{object}	service.Problem			"Bad request"
+//	@Failure		403	{object}	service.Problem			"Forbidden"
+//	@Failure		404	{object}	service.Problem			"Not in the trash"
+//	@Router			/subscription/{id}/restore [post]
+func (a *App) RestoreSubscription(w http.ResponseWriter, r *http.Request) {
+	principal, id, ok := subscriptionRequest(w, r)
+	if !ok {
+		return
+	}
+	var restored *types.UserSubscription
+	err := a.repo.WithTx(r.Context(), func(tx db.Repository) error {
+		deleted, err := tx.GetDeleted(r.Context(), id)
+		if deleted, err = requireOwner(principal, deleted, err); err != nil {
+			return err
+		}
+		err = tx.Restore(r.Context(), deleted.Id, deleted.UserId)
+		if errors.Is(err, db.ErrNotFound) {
+			return service.NewProblem(http.StatusNotFound, service.CodeNotFound, "Subscription not found")
+		}
+		if err != nil {
+			return err
+		}
+		if restored, err = tx.Get(r.Context(), deleted.Id); err != nil {
+			return err
+		}
+		return auditSubscription(tx, r, db.AuditSubscriptionRestored, deleted, restored)
+	})
+	if err != nil {
+		writeError(w, r, err, "Failed to restore subscription")
+		return
+	}
+	writeSubscription(w, r, restored)
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"crudl_service/src/types"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func listPage(t *testing.T, handler http.HandlerFunc, target, userID string) types.SubscriptionListResponse {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler(w, withPrincipal(httptest.NewRequest("GET", target, nil), userID))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var page types.SubscriptionListResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	return page
+}
+
+func TestTrash_DeleteAndRestore(t *testing.T) {
+	repo := newMockRepository()
+	app := newTestApp(repo)
+	seedSubscription(repo, 1, "user123")
+	seedSubscription(repo, 2, "user123")
+
+	w := httptest.NewRecorder()
+	app.DeleteSubscription(w, withPrincipal(withIDParam(httptest.NewRequest("DELETE", "/subscription/1", nil), "1"), "user123"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	app.ReadSubscription(w, withPrincipal(withIDParam(httptest.NewRequest("GET", "/subscription/1", nil), "1"), "user123"))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected deleted subscriptions to read as %d, got %d", http.StatusNotFound, w.Code)
+	}
+	if page := listPage(t, app.ListSubscription, "/subscriptionList", "user123"); len(page.Data) != 1 || page.Data[0].Id != 2 {
+		t.Errorf("Expected only the live subscription in the list, got %+v", page.Data)
+	}
+	if page := listPage(t, app.ListSubscription, "/subscriptionList?include_deleted=true", "user123"); len(page.Data) != 2 {
+		t.Errorf("Expected include_deleted to list both subscriptions, got %+v", page.Data)
+	}
+	trash := listPage(t, app.ListTrash, "/trash?include_deleted=false", "user123")
+	if len(trash.Data) != 1 || trash.Data[0].Id != 1 || trash.Data[0].DeletedAt == nil {
+		t.Fatalf("Expected the deleted subscription in the trash, got %+v", trash.Data)
+	}
+
+	restore := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		app.RestoreSubscription(w, withPrincipal(withIDParam(httptest.NewRequest("POST", "/subscription/1/restore", nil), "1"), "user123"))
+		return w
+	}
+	w = restore()
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var restored types.UserSubscription
+	json.Unmarshal(w.Body.Bytes(), &restored)
+	if restored.DeletedAt != nil || restored.Version != 3 || w.Header().Get("ETag") != `"3"` {
+		t.Errorf("Expected a live subscription at version 3, got %+v with ETag %s", restored, w.Header().Get("ETag"))
+	}
+	if page := listPage(t, app.ListTrash, "/trash", "user123"); len(page.Data) != 0 {
+		t.Errorf("Expected an empty trash, got %+v", page.Data)
+	}
+	if w := restore(); w.Code != http.StatusNotFound {
+		t.Errorf("Expected restoring a live subscription to give %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestRestoreSubscription_Forbidden(t *testing.T) {
+	repo := newMockRepository()
+	app := newTestApp(repo)
+	seedSubscription(repo, 1, "owner")
+	if err := repo.Delete(t.Context(), 1, "owner"); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	app.RestoreSubscription(w, withPrincipal(withIDParam(httptest.NewRequest("POST", "/subscription/1/restore", nil), "1"), "intruder"))
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+	if repo.subscriptions[1].DeletedAt == nil {
+		t.Error("Subscription should stay in the trash")
+	}
+	if page := listPage(t, app.ListTrash, "/trash", "intruder"); len(page.Data) != 0 {
+		t.Errorf("Expected other users' trash to stay hidden, got %+v", page.Data)
+	}
+}
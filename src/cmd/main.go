@@ -25,6 +25,8 @@ import (
 	"crudl_service/src/closer"
 	"crudl_service/src/config"
 	"crudl_service/src/db"
+	"crudl_service/src/purge"
+	"net"
 	"net/http"
 	"os/signal"
 	"sync"
@@ -33,7 +35,6 @@ import (
 
 	_ "crudl_service/docs"
 
-	"github.com/go-chi/chi/v5"
 	log "github.com/sirupsen/logrus"
 	httpSwagger "github.com/swaggo/http-swagger"
 )
@@ -66,40 +67,54 @@ func main() {
 		return sqlDB.Close()
 	})
 
-	repo := db.NewPostgresRepository(sqlDB)
-	app := api.NewApp(repo, cfg.JWT.SecretKey)
-
-	r := chi.NewRouter()
+	isolation, err := db.ParseIsolation(cfg.Database.TxIsolation)
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	repo := db.NewPostgresRepository(sqlDB, db.Timeouts{
+		Default:    cfg.Database.QueryTimeout,
+		Operations: cfg.Database.OperationTimeouts,
+	}, isolation)
+	app, err := api.NewApp(repo, cfg)
+	if err != nil {
+		log.Fatalf("Application initialization failed: %v", err)
+	}
 
-	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(`{"status":"ok"}`))
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	purgeDone := make(chan struct{})
+	go func() {
+		defer close(purgeDone)
+		purge.Run(purgeCtx, repo, cfg.Trash.Retention, cfg.Trash.PurgeInterval)
+	}()
+	cl.Add(func() error {
+		log.Info("Stopping trash purge")
+		stopPurge()
+		<-purgeDone
+		return nil
 	})
 
-	r.Post("/register", app.RegisterUser)
-	r.Post("/login", app.LoginUser)
-
-	r.Post("/subscription", app.ValidateJWT(app.CreateSubscription))
-	r.Get("/subscription/{id}", app.ValidateJWT(app.ReadSubscription))
-	r.Put("/subscription/{id}", app.ValidateJWT(app.UpdateSubscription))
-	r.Delete("/subscription/{id}", app.ValidateJWT(app.DeleteSubscription))
-	r.Get("/subscriptionList", app.ValidateJWT(app.ListSubscription))
-	r.Post("/sum_subscriptions", app.ValidateJWT(app.SumUserSubscriptions))
+	r := app.Router()
 
 	r.Get("/swagger/*", httpSwagger.Handler(
 		httpSwagger.URL("http://localhost:8080/swagger/doc.json"),
 	))
 
+	// Requests still running when shutdown stops waiting are canceled, which
+	// aborts their database queries.
+	requestCtx, cancelRequests := context.WithCancelCause(context.Background())
 	server := &http.Server{
-		Addr:    ":" + cfg.Server.Port,
-		Handler: r,
+		Addr:        ":" + cfg.Server.Port,
+		Handler:     r,
+		BaseContext: func(net.Listener) context.Context { return requestCtx },
 	}
 
 	cl.Add(func() error {
 		log.Info("Shutting down HTTP server")
 		ctx, cancel := context.WithTimeout(context.Background(), 25*time.Second)
 		defer cancel()
-		return server.Shutdown(ctx)
+		err := server.Shutdown(ctx)
+		cancelRequests(api.ErrShutdown)
+		return err
 	})
 
 	var wg sync.WaitGroup
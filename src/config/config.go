@@ -3,17 +3,26 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
 	Server   *ServerConfig
 	Database *DatabaseConfig
 	JWT      *JWTConfig
+	Password *PasswordConfig
+	Notify   *NotifyConfig
+	Trash    *TrashConfig
 }
 
 type ServerConfig struct {
 	Port     string
 	LogLevel string
+	// IfMatch is "required" when writes to a subscription must carry an
+	// If-Match header, or "optional" when the header is only checked if sent.
+	IfMatch string
 }
 
 type DatabaseConfig struct {
@@ -24,10 +33,79 @@ type DatabaseConfig struct {
 	Port          string
 	SSLMode       string
 	PathMigration string
+	// SubscriptionOnUserDelete is the ON DELETE action of the foreign key from
+	// subscriptions to users: "cascade", "set_null" or "restrict". It applies
+	// to users deleted directly in the database and is only applied when
+	// migration 22 runs.
+	SubscriptionOnUserDelete string
+	// QueryTimeout bounds every repository operation; OperationTimeouts
+	// overrides it per repository method, read from DB_OPERATION_TIMEOUTS as
+	// "Method=duration,Method=duration".
+	QueryTimeout      time.Duration
+	OperationTimeouts map[string]time.Duration
+	// TxIsolation is the default isolation level of repository transactions:
+	// "read_committed", "repeatable_read" or "serializable".
+	TxIsolation string
 }
 
 type JWTConfig struct {
+	// SecretKey is a legacy HS256 secret. It signs tokens only when no
+	// ActiveKeyID is configured, and otherwise keeps verifying tokens issued
+	// before the switch to asymmetric keys.
 	SecretKey string
+	// KeyFiles maps key IDs to PEM files with RSA or Ed25519 keys, read from
+	// JWT_KEYS as "kid=path,kid=path". ActiveKeyID names the key that signs
+	// new tokens; the others only verify tokens signed before a rotation.
+	KeyFiles    map[string]string
+	ActiveKeyID string
+	// AccessTokenTTL is the lifetime of access tokens, RefreshTokenTTL that of
+	// refresh tokens. Each rotation restarts the refresh token lifetime.
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+}
+
+type PasswordConfig struct {
+	// MinLength is the minimum password length in characters.
+	MinLength int
+	// BreachedListFile names a file with one known breached password per
+	// line. Passwords on the list are rejected; no file disables the check.
+	BreachedListFile string
+	// ResetTokenTTL is how long a password reset token stays usable.
+	ResetTokenTTL time.Duration
+}
+
+// NotifyConfig selects how messages such as password reset links reach
+// users.
+type NotifyConfig struct {
+	// Sink is "log", which writes messages to the application log, or
+	// "file", which appends them as JSON lines to File.
+	Sink string
+	File string
+}
+
+// TrashConfig controls how long deleted subscriptions can be restored.
+type TrashConfig struct {
+	// Retention is how long a subscription stays in the trash before the
+	// purge job, which runs every PurgeInterval, removes it for good.
+	Retention     time.Duration
+	PurgeInterval time.Duration
+}
+
+const (
+	DefaultAccessTokenTTL  = 15 * time.Minute
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+	DefaultPasswordLength  = 8
+	DefaultResetTokenTTL   = time.Hour
+	DefaultQueryTimeout    = 5 * time.Second
+	DefaultTrashRetention  = 30 * 24 * time.Hour
+	DefaultPurgeInterval   = time.Hour
+)
+
+// DefaultOperationTimeouts gives the reporting operations, which scan all
+// rows of a user, more time than DefaultQueryTimeout.
+var DefaultOperationTimeouts = map[string]time.Duration{
+	"Sum":        30 * time.Second,
+	"ExportUser": 30 * time.Second,
 }
 
 func InitConfig() (*Config, error) {
@@ -48,6 +126,7 @@ func newConfig() *Config {
 		Server: &ServerConfig{
 			Port:     port,
 			LogLevel: os.Getenv("LOG_LEVEL"),
+			IfMatch:  stringEnv("IF_MATCH", "required"),
 		},
 		Database: &DatabaseConfig{
 			Username:      os.Getenv("DB_USER"),
@@ -57,27 +136,182 @@ func newConfig() *Config {
 			Name:          os.Getenv("DB_NAME"),
 			SSLMode:       sslMode,
 			PathMigration: os.Getenv("DB_PATH_MIGRATION"),
+
+			SubscriptionOnUserDelete: stringEnv("DB_SUBSCRIPTION_ON_USER_DELETE", "cascade"),
+			QueryTimeout:             durationEnv("DB_QUERY_TIMEOUT", DefaultQueryTimeout),
+			OperationTimeouts:        durationsEnv("DB_OPERATION_TIMEOUTS", DefaultOperationTimeouts),
+			TxIsolation:              stringEnv("DB_TX_ISOLATION", "read_committed"),
 		},
 		JWT: &JWTConfig{
-			SecretKey: os.Getenv("JWT_SECRET_KEY"),
+			SecretKey:       os.Getenv("JWT_SECRET_KEY"),
+			KeyFiles:        keyFilesEnv("JWT_KEYS"),
+			ActiveKeyID:     os.Getenv("JWT_ACTIVE_KID"),
+			AccessTokenTTL:  durationEnv("JWT_ACCESS_TTL", DefaultAccessTokenTTL),
+			RefreshTokenTTL: durationEnv("JWT_REFRESH_TTL", DefaultRefreshTokenTTL),
+		},
+		Password: &PasswordConfig{
+			MinLength:        intEnv("PASSWORD_MIN_LENGTH", DefaultPasswordLength),
+			BreachedListFile: os.Getenv("PASSWORD_BREACHED_LIST"),
+			ResetTokenTTL:    durationEnv("PASSWORD_RESET_TTL", DefaultResetTokenTTL),
+		},
+		Notify: &NotifyConfig{
+			Sink: stringEnv("NOTIFY_SINK", "log"),
+			File: os.Getenv("NOTIFY_FILE"),
 		},
+		Trash: &TrashConfig{
+			Retention:     durationEnv("TRASH_RETENTION", DefaultTrashRetention),
+			PurgeInterval: durationEnv("TRASH_PURGE_INTERVAL", DefaultPurgeInterval),
+		},
+	}
+}
+
+func stringEnv(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// intEnv parses the environment variable key as an integer, using def when
+// it is unset. Unparsable values yield -1, which Validate rejects.
+func intEnv(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// durationEnv parses the environment variable key as a time.Duration, using
+// def when it is unset. Unparsable values yield 0, which Validate rejects.
+func durationEnv(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// durationsEnv parses a "name=duration,name=duration" list, using def when
+// it is unset. Malformed entries are kept under an empty name, which
+// Validate rejects.
+func durationsEnv(key string, def map[string]time.Duration) map[string]time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
 	}
+	durations := map[string]time.Duration{}
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, raw, _ := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		d, err := time.ParseDuration(strings.TrimSpace(raw))
+		if name == "" || err != nil || d <= 0 {
+			name = ""
+		}
+		durations[name] = d
+	}
+	return durations
+}
+
+// keyFilesEnv parses a "kid=path,kid=path" list. Entries without a key ID or
+// path are kept under an empty key ID, which Validate rejects.
+func keyFilesEnv(key string) map[string]string {
+	files := map[string]string{}
+	for _, entry := range strings.Split(os.Getenv(key), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		kid, path, _ := strings.Cut(entry, "=")
+		kid, path = strings.TrimSpace(kid), strings.TrimSpace(path)
+		if kid == "" || path == "" {
+			kid = ""
+		}
+		files[kid] = path
+	}
+	return files
 }
 
 func (c *Config) Validate() error {
 	required := map[string]string{
-		"DB_USER":             c.Database.Username,
-		"DB_PASSWORD":         c.Database.Password,
-		"DB_HOST":             c.Database.Host,
-		"DB_PORT":             c.Database.Port,
-		"DB_NAME":             c.Database.Name,
-		"JWT_SECRET_KEY":      c.JWT.SecretKey,
-		"DB_PATH_MIGRATION":   c.Database.PathMigration,
+		"DB_USER":           c.Database.Username,
+		"DB_PASSWORD":       c.Database.Password,
+		"DB_HOST":           c.Database.Host,
+		"DB_PORT":           c.Database.Port,
+		"DB_NAME":           c.Database.Name,
+		"DB_PATH_MIGRATION": c.Database.PathMigration,
 	}
 	for key, val := range required {
 		if val == "" {
 			return fmt.Errorf("required environment variable %s is not set", key)
 		}
 	}
+	switch c.Server.IfMatch {
+	case "required", "optional":
+	default:
+		return fmt.Errorf("IF_MATCH must be required or optional")
+	}
+	switch c.Database.SubscriptionOnUserDelete {
+	case "cascade", "set_null", "restrict":
+	default:
+		return fmt.Errorf("DB_SUBSCRIPTION_ON_USER_DELETE must be cascade, set_null or restrict")
+	}
+	if c.Database.QueryTimeout <= 0 {
+		return fmt.Errorf("DB_QUERY_TIMEOUT must be a positive duration")
+	}
+	if _, ok := c.Database.OperationTimeouts[""]; ok {
+		return fmt.Errorf("DB_OPERATION_TIMEOUTS entries must look like Method=duration")
+	}
+	switch c.Database.TxIsolation {
+	case "read_committed", "repeatable_read", "serializable":
+	default:
+		return fmt.Errorf("DB_TX_ISOLATION must be read_committed, repeatable_read or serializable")
+	}
+	if _, ok := c.JWT.KeyFiles[""]; ok {
+		return fmt.Errorf("JWT_KEYS entries must look like kid=path")
+	}
+	if c.JWT.ActiveKeyID == "" && c.JWT.SecretKey == "" {
+		return fmt.Errorf("either JWT_ACTIVE_KID or JWT_SECRET_KEY must be set")
+	}
+	if _, ok := c.JWT.KeyFiles[c.JWT.ActiveKeyID]; c.JWT.ActiveKeyID != "" && !ok {
+		return fmt.Errorf("JWT_ACTIVE_KID %s is not listed in JWT_KEYS", c.JWT.ActiveKeyID)
+	}
+	if c.JWT.AccessTokenTTL <= 0 {
+		return fmt.Errorf("JWT_ACCESS_TTL must be a positive duration")
+	}
+	if c.JWT.RefreshTokenTTL <= c.JWT.AccessTokenTTL {
+		return fmt.Errorf("JWT_REFRESH_TTL must be longer than JWT_ACCESS_TTL")
+	}
+	if c.Password.MinLength < 1 || c.Password.MinLength > 72 {
+		return fmt.Errorf("PASSWORD_MIN_LENGTH must be between 1 and 72")
+	}
+	if c.Password.ResetTokenTTL <= 0 {
+		return fmt.Errorf("PASSWORD_RESET_TTL must be a positive duration")
+	}
+	if c.Trash.Retention <= 0 {
+		return fmt.Errorf("TRASH_RETENTION must be a positive duration")
+	}
+	if c.Trash.PurgeInterval <= 0 {
+		return fmt.Errorf("TRASH_PURGE_INTERVAL must be a positive duration")
+	}
+	switch c.Notify.Sink {
+	case "log":
+	case "file":
+		if c.Notify.File == "" {
+			return fmt.Errorf("NOTIFY_FILE must be set when NOTIFY_SINK is file")
+		}
+	default:
+		return fmt.Errorf("NOTIFY_SINK must be log or file")
+	}
 	return nil
 }
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+// setRequiredEnv sets the variables InitConfig cannot do without.
+func setRequiredEnv(t *testing.T) {
+	t.Helper()
+	for key, value := range map[string]string{
+		"DB_USER":           "postgres",
+		"DB_PASSWORD":       "postgres",
+		"DB_HOST":           "localhost",
+		"DB_PORT":           "5432",
+		"DB_NAME":           "database",
+		"DB_PATH_MIGRATION": "file://migration",
+		"JWT_SECRET_KEY":    "secret",
+	} {
+		t.Setenv(key, value)
+	}
+}
+
+func TestInitConfig_Defaults(t *testing.T) {
+	setRequiredEnv(t)
+	for _, key := range []string{"DB_TX_ISOLATION", "DB_SUBSCRIPTION_ON_USER_DELETE", "IF_MATCH", "NOTIFY_SINK", "JWT_KEYS", "JWT_ACTIVE_KID"} {
+		t.Setenv(key, "")
+	}
+
+	cfg, err := InitConfig()
+	if err != nil {
+		t.Fatalf("Expected the defaults to be valid, got %v", err)
+	}
+	if cfg.Database.TxIsolation != "read_committed" || cfg.Database.SubscriptionOnUserDelete != "cascade" ||
+		cfg.Server.IfMatch != "required" || cfg.Notify.Sink != "log" {
+		t.Errorf("Unexpected defaults: %+v %+v %+v", cfg.Database, cfg.Server, cfg.Notify)
+	}
+}
+
+func TestInitConfig_ReadsEnvironment(t *testing.T) {
+	setRequiredEnv(t)
+	for key, value := range map[string]string{
+		"DB_TX_ISOLATION":                "serializable",
+		"DB_SUBSCRIPTION_ON_USER_DELETE": "restrict",
+		"DB_QUERY_TIMEOUT":               "2s",
+		"IF_MATCH":                       "optional",
+		"JWT_ACCESS_TTL":                 "5m",
+		"PASSWORD_MIN_LENGTH":            "12",
+		"TRASH_RETENTION":                "48h",
+	} {
+		t.Setenv(key, value)
+	}
+
+	cfg, err := InitConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Database.TxIsolation != "serializable" {
+		t.Errorf("Expected DB_TX_ISOLATION to be read, got %q", cfg.Database.TxIsolation)
+	}
+	if cfg.Database.SubscriptionOnUserDelete != "restrict" || cfg.Database.QueryTimeout != 2*time.Second {
+		t.Errorf("Expected the database settings to be read, got %+v", cfg.Database)
+	}
+	if cfg.Server.IfMatch != "optional" || cfg.JWT.AccessTokenTTL != 5*time.Minute ||
+		cfg.Password.MinLength != 12 || cfg.Trash.Retention != 48*time.Hour {
+		t.Errorf("Expected the remaining settings to be read, got %+v %+v %+v %+v", cfg.Server, cfg.JWT, cfg.Password, cfg.Trash)
+	}
+}
+
+func TestInitConfig_RejectsInvalidValues(t *testing.T) {
+	for key, value := range map[string]string{
+		"DB_TX_ISOLATION":  "snapshot",
+		"DB_QUERY_TIMEOUT": "soon",
+		"IF_MATCH":         "sometimes",
+		"NOTIFY_SINK":      "smtp",
+	} {
+		t.Run(key, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv(key, value)
+			if _, err := InitConfig(); err == nil {
+				t.Errorf("Expected %s=%s to be rejected", key, value)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package db
+
+import (
+	"context"
+	"crudl_service/src/types"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// profileColumns is the select list understood by scanProfile.
+const profileColumns = `id, username, role, display_name, email, default_currency, timezone, totp_enabled_at IS NOT NULL`
+
+func scanProfile(row rowScanner, profile *types.UserProfile) error {
+	return row.Scan(&profile.ID, &profile.Username, &profile.Role, &profile.DisplayName, &profile.Email,
+		&profile.DefaultCurrency, &profile.Timezone, &profile.MFAEnabled)
+}
+
+// GetProfile returns the account of userID as shown to the user.
+func (r *postgresRepository) GetProfile(ctx context.Context, userID string) (_ *types.UserProfile, err error) {
+	if err := r.checkDB(); err != nil {
+		return nil, err
+	}
+	ctx, done := r.operation(ctx, "GetProfile", &err)
+	defer done()
+	profile := &types.UserProfile{}
+	err = scanProfile(r.conn().QueryRowContext(ctx, `SELECT `+profileColumns+` FROM users WHERE id = $1`, userID), profile)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to get profile")
+		return nil, err
+	}
+	return profile, nil
+}
+
+// UpdateProfile replaces the profile fields of userID with profile; nil
+// fields are cleared. It fails with ErrConflict when the email address is
+// used by another account, ignoring case.
+func (r *postgresRepository) UpdateProfile(ctx context.Context, userID string, profile *types.Profile) (_ *types.UserProfile, err error) {
+	if err := r.checkDB(); err != nil {
+		return nil, err
+	}
+	ctx, done := r.operation(ctx, "UpdateProfile", &err)
+	defer done()
+	updated := &types.UserProfile{}
+	err = scanProfile(r.conn().QueryRowContext(ctx,
+		`UPDATE users SET display_name = $2, email = $3, default_currency = $4, timezone = $5
+		 WHERE id = $1 RETURNING `+profileColumns,
+		userID, profile.DisplayName, profile.Email, profile.DefaultCurrency, profile.Timezone,
+	), updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		if err = translateError(err); !errors.Is(err, ErrConflict) {
+			log.WithError(err).Error("Failed to update profile")
+		}
+		return nil, err
+	}
+	return updated, nil
+}
+
+// DeleteUser deletes the account of userID with its tokens, keys and MFA
+// data. Its subscriptions are deleted too, or kept without a user when
+// anonymizeSubscriptions is set. Audit events of the user are kept with the
+// username, address and profile values stripped.
+func (r *postgresRepository) DeleteUser(ctx context.Context, userID string, anonymizeSubscriptions bool) (err error) {
+	if err := r.checkDB(); err != nil {
+		return err
+	}
+	ctx, done := r.operation(ctx, "DeleteUser", &err)
+	defer done()
+	tx, err := r.begin(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var username string
+	err = tx.QueryRowContext(ctx, `SELECT username FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to lock user for deletion")
+		return err
+	}
+	// Subscriptions go first so that the foreign key action configured for
+	// manual deletes does not apply. Only live subscriptions are kept when
+	// anonymizing; the trash is emptied either way.
+	subscriptions := []string{`DELETE FROM subscriptions WHERE user_id = $1`}
+	if anonymizeSubscriptions {
+		subscriptions = []string{
+			`DELETE FROM subscriptions WHERE user_id = $1 AND deleted_at IS NOT NULL`,
+			`UPDATE subscriptions SET user_id = NULL WHERE user_id = $1`,
+		}
+	}
+	for _, statement := range subscriptions {
+		if _, err := tx.ExecContext(ctx, statement, userID); err != nil {
+			log.WithError(err).Error("Failed to remove subscriptions of deleted user")
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, userID); err != nil {
+		log.WithError(err).Error("Failed to delete user")
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM login_failures WHERE key = $1`, "user:"+username); err != nil {
+		log.WithError(err).Error("Failed to remove login failures of deleted user")
+		return err
+	}
+	// The only update audit_events allows. Profile changes record personal
+	// details such as the email address, so their values go too.
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE audit_events SET username = NULL, ip = NULL,
+		     changes = CASE WHEN event = $3 THEN NULL ELSE changes END
+		 WHERE user_id = $1 OR lower(username) = lower($2)`,
+		userID, username, AuditProfileUpdated,
+	); err != nil {
+		log.WithError(err).Error("Failed to anonymize audit events of deleted user")
+		return err
+	}
+	return tx.Commit()
+}
+
+// ExportUser collects everything stored about userID from one consistent
+// snapshot. Secrets such as the password hash are left out.
+func (r *postgresRepository) ExportUser(ctx context.Context, userID string) (_ *types.UserExport, err error) {
+	if err := r.checkDB(); err != nil {
+		return nil, err
+	}
+	ctx, done := r.operation(ctx, "ExportUser", &err)
+	defer done()
+	tx, err := r.begin(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	export := &types.UserExport{
+		ExportedAt:    time.Now().UTC(),
+		Subscriptions: []types.UserSubscription{},
+		APIKeys:       []types.APIKey{},
+		Sessions:      []types.SessionInfo{},
+		AuditEvents:   []types.AuditEventInfo{},
+	}
+	err = scanProfile(tx.QueryRowContext(ctx, `SELECT `+profileColumns+` FROM users WHERE id = $1`, userID), &export.User)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to export profile")
+		return nil, err
+	}
+
+	queries := []struct {
+		query string
+		scan  func(rowScanner) error
+	}{
+		{`SELECT ` + subscriptionColumns + ` FROM subscriptions WHERE user_id = $1 ORDER BY id`, func(row rowScanner) error {
+			var sub types.UserSubscription
+			if err := scanSubscription(row, &sub); err != nil {
+				return err
+			}
+			export.Subscriptions = append(export.Subscriptions, sub)
+			return nil
+		}},
+		{`SELECT ` + apiKeyColumns + ` FROM api_keys WHERE user_id = $1 ORDER BY id`, func(row rowScanner) error {
+			var key types.APIKey
+			if err := scanAPIKey(row, &key); err != nil {
+				return err
+			}
+			export.APIKeys = append(export.APIKeys, key)
+			return nil
+		}},
+		{`SELECT created_at, expires_at, used_at, revoked_at FROM refresh_tokens WHERE user_id = $1 ORDER BY id`, func(row rowScanner) error {
+			var session types.SessionInfo
+			if err := row.Scan(&session.CreatedAt, &session.ExpiresAt, &session.UsedAt, &session.RevokedAt); err != nil {
+				return err
+			}
+			export.Sessions = append(export.Sessions, session)
+			return nil
+		}},
+		{`SELECT ` + auditEventColumns + ` FROM audit_events WHERE user_id = $1 ORDER BY id`, func(row rowScanner) error {
+			var event types.AuditEventInfo
+			if err := scanAuditEvent(row, &event); err != nil {
+				return err
+			}
+			export.AuditEvents = append(export.AuditEvents, event)
+			return nil
+		}},
+	}
+	for _, q := range queries {
+		if err := queryEach(ctx, tx, q.query, userID, q.scan); err != nil {
+			log.WithError(err).Error("Failed to export user data")
+			return nil, err
+		}
+	}
+	return export, tx.Commit()
+}
+
+// queryEach runs query with arg and calls scan for every row.
+func queryEach(ctx context.Context, tx querier, query string, arg any, scan func(rowScanner) error) error {
+	rows, err := tx.QueryContext(ctx, query, arg)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"context"
+	"crudl_service/src/types"
+	"testing"
+)
+
+func TestGetProfile_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if _, err := r.GetProfile(context.Background(), "user"); err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
+
+func TestUpdateProfile_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if _, err := r.UpdateProfile(context.Background(), "user", &types.Profile{}); err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
+
+func TestDeleteUser_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if err := r.DeleteUser(context.Background(), "user", false); err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
+
+func TestExportUser_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if _, err := r.ExportUser(context.Background(), "user"); err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
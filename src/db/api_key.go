@@ -0,0 +1,148 @@
+package db
+
+import (
+	"context"
+	"crudl_service/src/types"
+	"database/sql"
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// APIKeyOwner is what an API key authenticates as.
+type APIKeyOwner struct {
+	KeyID  int64
+	UserID string
+	Role   string
+	Scope  string
+}
+
+// apiKeyColumns is the select list understood by scanAPIKey.
+const apiKeyColumns = `id, name, prefix, scope, created_at, last_used_at`
+
+func scanAPIKey(row rowScanner, key *types.APIKey) error {
+	return row.Scan(&key.ID, &key.Name, &key.Prefix, &key.Scope, &key.CreatedAt, &key.LastUsedAt)
+}
+
+// CreateAPIKey stores a new key of userID, filling in its ID and creation
+// time.
+func (r *postgresRepository) CreateAPIKey(ctx context.Context, userID, keyHash string, key *types.APIKey) (err error) {
+	if err := r.checkDB(); err != nil {
+		return err
+	}
+	ctx, done := r.operation(ctx, "CreateAPIKey", &err)
+	defer done()
+	err = r.conn().QueryRowContext(ctx,
+		`INSERT INTO api_keys (user_id, name, prefix, key_hash, scope) VALUES ($1, $2, $3, $4, $5)
+		 RETURNING id, created_at`,
+		userID, key.Name, key.Prefix, keyHash, key.Scope,
+	).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		log.WithError(err).Error("Failed to create API key")
+	}
+	return err
+}
+
+// ListAPIKeys returns the keys of userID that have not been revoked, newest
+// first.
+func (r *postgresRepository) ListAPIKeys(ctx context.Context, userID string) (_ []types.APIKey, err error) {
+	if err := r.checkDB(); err != nil {
+		return nil, err
+	}
+	ctx, done := r.operation(ctx, "ListAPIKeys", &err)
+	defer done()
+	rows, err := r.conn().QueryContext(ctx,
+		`SELECT `+apiKeyColumns+` FROM api_keys
+		 WHERE user_id = $1 AND revoked_at IS NULL ORDER BY id DESC`, userID,
+	)
+	if err != nil {
+		log.WithError(err).Error("Failed to list API keys")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []types.APIKey
+	for rows.Next() {
+		var key types.APIKey
+		if err := scanAPIKey(rows, &key); err != nil {
+			log.WithError(err).Error("Failed to scan API key row")
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RenameAPIKey renames an active key of userID and returns it.
+func (r *postgresRepository) RenameAPIKey(ctx context.Context, userID string, id int64, name string) (_ *types.APIKey, err error) {
+	if err := r.checkDB(); err != nil {
+		return nil, err
+	}
+	ctx, done := r.operation(ctx, "RenameAPIKey", &err)
+	defer done()
+	key := &types.APIKey{}
+	err = scanAPIKey(r.conn().QueryRowContext(ctx,
+		`UPDATE api_keys SET name = $3 WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+		 RETURNING `+apiKeyColumns, id, userID, name,
+	), key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to rename API key")
+		return nil, err
+	}
+	return key, nil
+}
+
+// RevokeAPIKey revokes an active key of userID.
+func (r *postgresRepository) RevokeAPIKey(ctx context.Context, userID string, id int64) (err error) {
+	if err := r.checkDB(); err != nil {
+		return err
+	}
+	ctx, done := r.operation(ctx, "RevokeAPIKey", &err)
+	defer done()
+	result, err := r.conn().ExecContext(ctx,
+		`UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, id, userID,
+	)
+	if err != nil {
+		log.WithError(err).Error("Failed to revoke API key")
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// AuthenticateAPIKey resolves an active key of an enabled user by its hash
+// and records its use. last_used_at is only written when it is more than a
+// minute old, to keep busy keys from turning every request into a write.
+func (r *postgresRepository) AuthenticateAPIKey(ctx context.Context, keyHash string) (_ *APIKeyOwner, err error) {
+	if err := r.checkDB(); err != nil {
+		return nil, err
+	}
+	ctx, done := r.operation(ctx, "AuthenticateAPIKey", &err)
+	defer done()
+	owner := &APIKeyOwner{}
+	err = r.conn().QueryRowContext(ctx,
+		`WITH key AS (
+		     SELECT k.id, k.user_id, u.role, k.scope, k.last_used_at
+		     FROM api_keys k JOIN users u ON u.id = k.user_id
+		     WHERE k.key_hash = $1 AND k.revoked_at IS NULL AND u.disabled_at IS NULL
+		 ), touched AS (
+		     UPDATE api_keys SET last_used_at = NOW()
+		     WHERE id IN (SELECT id FROM key
+		                  WHERE last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+		 )
+		 SELECT id, user_id, role, scope FROM key`, keyHash,
+	).Scan(&owner.KeyID, &owner.UserID, &owner.Role, &owner.Scope)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to authenticate API key")
+		return nil, err
+	}
+	return owner, nil
+}
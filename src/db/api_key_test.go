@@ -0,0 +1,50 @@
+package db
+
+import (
+	"context"
+	"crudl_service/src/types"
+	"testing"
+)
+
+func TestCreateAPIKey_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if err := r.CreateAPIKey(context.Background(), "user", "hash", &types.APIKey{Name: "ci", Scope: types.APIKeyScopeRead}); err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
+
+func TestListAPIKeys_NilDB(t *testing.T) {
+	r := newNilRepo()
+	keys, err := r.ListAPIKeys(context.Background(), "user")
+	if err == nil {
+		t.Error("Expected error with nil db")
+	}
+	if keys != nil {
+		t.Error("Expected nil result")
+	}
+}
+
+func TestRenameAPIKey_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if _, err := r.RenameAPIKey(context.Background(), "user", 1, "ci"); err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
+
+func TestRevokeAPIKey_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if err := r.RevokeAPIKey(context.Background(), "user", 1); err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
+
+func TestAuthenticateAPIKey_NilDB(t *testing.T) {
+	r := newNilRepo()
+	owner, err := r.AuthenticateAPIKey(context.Background(), "hash")
+	if err == nil {
+		t.Error("Expected error with nil db")
+	}
+	if owner != nil {
+		t.Error("Expected nil owner")
+	}
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"crudl_service/src/types"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Audit events.
+const (
+	AuditLoginLockout   = "login_lockout"
+	AuditAccountDeleted = "account_deleted"
+
+	AuditRegistered      = "registered"
+	AuditLogin           = "login"
+	AuditLoginFailed     = "login_failed"
+	AuditProfileUpdated  = "profile_updated"
+	AuditPasswordChanged = "password_changed"
+	AuditPasswordReset   = "password_reset"
+	AuditAccessChanged   = "access_changed"
+	AuditAPIKeyCreated   = "api_key_created"
+	AuditAPIKeyRenamed   = "api_key_renamed"
+	AuditAPIKeyRevoked   = "api_key_revoked"
+	AuditMFAEnabled      = "mfa_enabled"
+	AuditMFADisabled     = "mfa_disabled"
+
+	AuditSubscriptionCreated  = "subscription_created"
+	AuditSubscriptionUpdated  = "subscription_updated"
+	AuditSubscriptionDeleted  = "subscription_deleted"
+	AuditSubscriptionRestored = "subscription_restored"
+)
+
+// AuditEvent is an entry of the audit trail. UserID is the user the event is
+// about and ActorID the user who caused it. Changes is a JSON document of
+// the changed values, see service.Diff. Empty fields are stored as NULL.
+type AuditEvent struct {
+	Event          string
+	UserID         string
+	ActorID        string
+	Username       string
+	SubscriptionID int64
+	Changes        []byte
+	RequestID      string
+	IP             string
+	Detail         string
+}
+
+// auditEventColumns is the select list understood by scanAuditEvent.
+const auditEventColumns = `id, event, user_id, actor_id, username, subscription_id, changes, request_id, ip, detail, created_at`
+
+func scanAuditEvent(row rowScanner, event *types.AuditEventInfo) error {
+	var changes []byte
+	if err := row.Scan(&event.ID, &event.Event, &event.UserID, &event.ActorID, &event.Username, &event.SubscriptionID,
+		&changes, &event.RequestID, &event.IP, &event.Detail, &event.CreatedAt); err != nil {
+		return err
+	}
+	event.Changes = changes
+	return nil
+}
+
+// RecordAuditEvent appends event to the audit trail. Inside WithTx the event
+// is only kept if the transaction commits.
+func (r *postgresRepository) RecordAuditEvent(ctx context.Context, event *AuditEvent) (err error) {
+	if err := r.checkDB(); err != nil {
+		return err
+	}
+	ctx, done := r.operation(ctx, "RecordAuditEvent", &err)
+	defer done()
+	_, err = r.conn().ExecContext(ctx,
+		`INSERT INTO audit_events (event, user_id, actor_id, username, subscription_id, changes, request_id, ip, detail)
+		 VALUES ($1, NULLIF($2, '')::uuid, NULLIF($3, '')::uuid, NULLIF($4, ''), NULLIF($5, 0),
+		         NULLIF($6, '')::jsonb, NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''))`,
+		event.Event, event.UserID, event.ActorID, event.Username, event.SubscriptionID,
+		string(event.Changes), event.RequestID, event.IP, event.Detail,
+	)
+	if err != nil {
+		log.WithError(err).Error("Failed to record audit event")
+	}
+	return err
+}
+
+// ListAuditEvents returns a page of the audit events selected by q, newest
+// first.
+func (r *postgresRepository) ListAuditEvents(ctx context.Context, q *types.AuditEventQuery) (_ []types.AuditEventInfo, err error) {
+	if err := r.checkDB(); err != nil {
+		return nil, err
+	}
+	ctx, done := r.operation(ctx, "ListAuditEvents", &err)
+	defer done()
+
+	var args queryArgs
+	var conds []string
+	if q.UserID != "" {
+		conds = append(conds, "user_id = "+args.add(q.UserID)+"::uuid")
+	}
+	if q.ActorID != "" {
+		conds = append(conds, "actor_id = "+args.add(q.ActorID)+"::uuid")
+	}
+	if q.Event != "" {
+		conds = append(conds, "event = "+args.add(q.Event))
+	}
+	if q.Before > 0 {
+		conds = append(conds, "id < "+args.add(q.Before))
+	}
+	query := `SELECT ` + auditEventColumns + ` FROM audit_events`
+	if len(conds) > 0 {
+		query += ` WHERE ` + strings.Join(conds, " AND ")
+	}
+	query += ` ORDER BY id DESC LIMIT ` + args.add(q.Limit)
+
+	rows, err := r.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		log.WithError(err).Error("Failed to list audit events")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []types.AuditEventInfo
+	for rows.Next() {
+		var event types.AuditEventInfo
+		if err := scanAuditEvent(rows, &event); err != nil {
+			log.WithError(err).Error("Failed to scan audit event row")
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
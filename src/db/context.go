@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrTimeout is matched by errors of operations that ran out of time,
+	// either their own timeout or the caller's deadline.
+	ErrTimeout = errors.New("database operation timed out")
+	// ErrCanceled is matched by errors of operations whose caller gave up,
+	// such as a client that disconnected. The error also wraps the cause of
+	// the cancellation.
+	ErrCanceled = errors.New("database operation canceled")
+)
+
+// Timeouts bounds how long repository operations may run. Operations maps
+// repository method names, such as "Sum", to their own limit; the others get
+// Default. A zero duration means no limit.
+type Timeouts struct {
+	Default    time.Duration
+	Operations map[string]time.Duration
+}
+
+// For returns the timeout of the repository method op.
+func (t Timeouts) For(op string) time.Duration {
+	if d, ok := t.Operations[op]; ok {
+		return d
+	}
+	return t.Default
+}
+
+// operation bounds ctx by the timeout of the repository method op. The
+// returned function must be deferred: it releases the context and turns
+// *err into ErrTimeout or ErrCanceled when the context ended.
+func (r *postgresRepository) operation(ctx context.Context, op string, err *error) (context.Context, func()) {
+	var cancel context.CancelFunc
+	if timeout := r.timeouts.For(op); timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	return ctx, func() {
+		*err = contextError(ctx, *err)
+		cancel()
+	}
+}
+
+// contextError classifies err by the state of ctx, the context the failed
+// operation ran under.
+func contextError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return fmt.Errorf("%w: %w", ErrCanceled, context.Cause(ctx))
+}
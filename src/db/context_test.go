@@ -0,0 +1,54 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTimeouts_For(t *testing.T) {
+	timeouts := Timeouts{Default: time.Second, Operations: map[string]time.Duration{"Sum": time.Minute}}
+	if got := timeouts.For("Sum"); got != time.Minute {
+		t.Errorf("Expected the Sum timeout, got %v", got)
+	}
+	if got := timeouts.For("Get"); got != time.Second {
+		t.Errorf("Expected the default timeout, got %v", got)
+	}
+}
+
+func TestContextError(t *testing.T) {
+	queryErr := errors.New("pq: canceling statement due to user request")
+	shutdown := errors.New("shutting down")
+
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	if err := contextError(expired, queryErr); !errors.Is(err, ErrTimeout) || !errors.Is(err, queryErr) {
+		t.Errorf("Expected a timeout wrapping the query error, got %v", err)
+	}
+
+	canceled, cancelCause := context.WithCancelCause(context.Background())
+	cancelCause(shutdown)
+	if err := contextError(canceled, queryErr); !errors.Is(err, ErrCanceled) || !errors.Is(err, shutdown) {
+		t.Errorf("Expected a cancellation wrapping its cause, got %v", err)
+	}
+
+	if err := contextError(context.Background(), queryErr); err != queryErr {
+		t.Errorf("Expected other errors unchanged, got %v", err)
+	}
+	if err := contextError(canceled, nil); err != nil {
+		t.Errorf("Expected success to stay nil, got %v", err)
+	}
+}
+
+func TestOperation_AppliesTimeout(t *testing.T) {
+	r := &postgresRepository{timeouts: Timeouts{Default: time.Hour, Operations: map[string]time.Duration{"Sum": time.Millisecond}}}
+	var err error
+	ctx, done := r.operation(context.Background(), "Sum", &err)
+	<-ctx.Done()
+	err = ctx.Err()
+	done()
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected ErrTimeout, got %v", err)
+	}
+}
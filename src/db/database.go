@@ -3,6 +3,7 @@ package db
 import (
 	"crudl_service/src/config"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
@@ -11,7 +12,7 @@ import (
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type NotFoundError struct{}
@@ -20,6 +21,45 @@ func (e *NotFoundError) Error() string {
 	return "not found"
 }
 
+// Is makes errors.Is(err, ErrNotFound) hold for *NotFoundError values.
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// ConflictError reports a write rejected by the unique constraint
+// Constraint.
+type ConflictError struct {
+	Constraint string
+}
+
+func (e *ConflictError) Error() string {
+	return "conflict with existing row on " + e.Constraint
+}
+
+// Is makes errors.Is(err, ErrConflict) hold for *ConflictError values.
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// PostgreSQL error codes of unique and foreign key constraint violations,
+// and of the transaction failures WithTx retries.
+const (
+	pqUniqueViolation      = "23505"
+	pqForeignKeyViolation  = "23503"
+	pqSerializationFailure = "40001"
+	pqDeadlockDetected     = "40P01"
+)
+
+// translateError turns unique constraint violations into *ConflictError and
+// returns other errors unchanged.
+func translateError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == pqUniqueViolation {
+		return &ConflictError{Constraint: pqErr.Constraint}
+	}
+	return err
+}
+
 func buildConnURL(cfg *config.DatabaseConfig) string {
 	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s",
 		cfg.Username, cfg.Password, cfg.Host, cfg.Port, cfg.Name, cfg.SSLMode,
@@ -51,7 +91,20 @@ func InitDB(cfg *config.DatabaseConfig) (*sql.DB, error) {
 	}
 	log.Info("Database connection established successfully")
 
-	m, err := migrate.New(cfg.PathMigration, urlConnection)
+	report, err := ReportOrphanedSubscriptions(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if report != nil && report.Total() > 0 {
+		log.WithFields(log.Fields{
+			"invalid_user_ids": report.InvalidUserIDs,
+			"unknown_users":    report.UnknownUsers,
+			"user_ids":         report.UserIDs,
+		}).Warn("Subscriptions without an existing user will be moved to orphaned_subscriptions")
+	}
+
+	m, err := migrate.New(cfg.PathMigration, migrationURL(urlConnection, cfg.SubscriptionOnUserDelete))
 	if err != nil {
 		_ = conn.Close()
 		return nil, fmt.Errorf("failed to create migration instance: %w", err)
@@ -68,6 +121,11 @@ func InitDB(cfg *config.DatabaseConfig) (*sql.DB, error) {
 
 	log.Info("Database migrations completed successfully")
 
+	if err := CheckSubscriptionOnUserDelete(conn, cfg.SubscriptionOnUserDelete); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
 	return conn, nil
 }
 
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"context"
+	"crudl_service/src/types"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SetExchangeRates upserts the given rates for month (MM-YYYY) in a single
+// transaction, so a partially loaded month is never visible to Sum.
+func (r *postgresRepository) SetExchangeRates(ctx context.Context, month string, rates []types.ExchangeRate) (err error) {
+	if err := r.checkDB(); err != nil {
+		return err
+	}
+	ctx, done := r.operation(ctx, "SetExchangeRates", &err)
+	defer done()
+	tx, err := r.begin(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO exchange_rates (base_currency, quote_currency, month, rate)
+			  VALUES ($1, $2, to_date($3, 'MM-YYYY'), $4)
+			  ON CONFLICT (base_currency, quote_currency, month)
+			  DO UPDATE SET rate = EXCLUDED.rate, updated_at = NOW()`
+	for _, rate := range rates {
+		if _, err := tx.ExecContext(ctx, query, rate.BaseCurrency, rate.QuoteCurrency, month, rate.Rate); err != nil {
+			log.WithError(err).Error("Failed to store exchange rate")
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *postgresRepository) ListExchangeRates(ctx context.Context, month string) (_ []types.ExchangeRate, err error) {
+	if err := r.checkDB(); err != nil {
+		return nil, err
+	}
+	ctx, done := r.operation(ctx, "ListExchangeRates", &err)
+	defer done()
+	rows, err := r.conn().QueryContext(ctx,
+		`SELECT base_currency, quote_currency, to_char(month, 'MM-YYYY'), rate
+		 FROM exchange_rates WHERE month = to_date($1, 'MM-YYYY')
+		 ORDER BY base_currency, quote_currency`, month,
+	)
+	if err != nil {
+		log.WithError(err).Error("Failed to list exchange rates")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rates []types.ExchangeRate
+	for rows.Next() {
+		var rate types.ExchangeRate
+		if err := rows.Scan(&rate.BaseCurrency, &rate.QuoteCurrency, &rate.Month, &rate.Rate); err != nil {
+			log.WithError(err).Error("Failed to scan exchange rate row")
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+	return rates, rows.Err()
+}
@@ -0,0 +1,26 @@
+package db
+
+import (
+	"context"
+	"crudl_service/src/types"
+	"testing"
+)
+
+func TestSetExchangeRates_NilDB(t *testing.T) {
+	r := newNilRepo()
+	err := r.SetExchangeRates(context.Background(), "01-2024", []types.ExchangeRate{{BaseCurrency: "USD", QuoteCurrency: "RUB", Rate: 90}})
+	if err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
+
+func TestListExchangeRates_NilDB(t *testing.T) {
+	r := newNilRepo()
+	result, err := r.ListExchangeRates(context.Background(), "01-2024")
+	if err == nil {
+		t.Error("Expected error with nil db")
+	}
+	if result != nil {
+		t.Error("Expected nil result")
+	}
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+// LoginLockedUntil returns the latest time until which any of keys is locked,
+// or the zero time when none of them is locked now.
+func (r *postgresRepository) LoginLockedUntil(ctx context.Context, keys ...string) (_ time.Time, err error) {
+	if err := r.checkDB(); err != nil {
+		return time.Time{}, err
+	}
+	ctx, done := r.operation(ctx, "LoginLockedUntil", &err)
+	defer done()
+	var until sql.NullTime
+	err = r.conn().QueryRowContext(ctx,
+		`SELECT MAX(locked_until) FROM login_failures WHERE key = ANY($1) AND locked_until > NOW()`,
+		pq.Array(keys),
+	).Scan(&until)
+	if err != nil {
+		log.WithError(err).Error("Failed to check login lock")
+		return time.Time{}, err
+	}
+	return until.Time, nil
+}
+
+// RecordLoginFailure counts a failed login for key and returns the number of
+// failures in a row. Failures older than window are forgotten.
+func (r *postgresRepository) RecordLoginFailure(ctx context.Context, key string, window time.Duration) (_ int, err error) {
+	if err := r.checkDB(); err != nil {
+		return 0, err
+	}
+	ctx, done := r.operation(ctx, "RecordLoginFailure", &err)
+	defer done()
+	var failures int
+	err = r.conn().QueryRowContext(ctx,
+		`INSERT INTO login_failures (key, failures, last_failure_at) VALUES ($1, 1, NOW())
+		 ON CONFLICT (key) DO UPDATE SET
+		     failures = CASE WHEN login_failures.last_failure_at < NOW() - make_interval(secs => $2)
+		                     THEN 1 ELSE login_failures.failures + 1 END,
+		     last_failure_at = NOW()
+		 RETURNING failures`,
+		key, window.Seconds(),
+	).Scan(&failures)
+	if err != nil {
+		log.WithError(err).Error("Failed to record login failure")
+	}
+	return failures, err
+}
+
+// LockLogin refuses logins for key until the given time.
+func (r *postgresRepository) LockLogin(ctx context.Context, key string, until time.Time) (err error) {
+	if err := r.checkDB(); err != nil {
+		return err
+	}
+	ctx, done := r.operation(ctx, "LockLogin", &err)
+	defer done()
+	if _, err := r.conn().ExecContext(ctx, `UPDATE login_failures SET locked_until = $2 WHERE key = $1`, key, until); err != nil {
+		log.WithError(err).Error("Failed to lock login")
+		return err
+	}
+	return nil
+}
+
+// ClearLoginFailures forgets the failed logins of key.
+func (r *postgresRepository) ClearLoginFailures(ctx context.Context, key string) (err error) {
+	if err := r.checkDB(); err != nil {
+		return err
+	}
+	ctx, done := r.operation(ctx, "ClearLoginFailures", &err)
+	defer done()
+	if _, err := r.conn().ExecContext(ctx, `DELETE FROM login_failures WHERE key = $1`, key); err != nil {
+		log.WithError(err).Error("Failed to clear login failures")
+		return err
+	}
+	return nil
+}
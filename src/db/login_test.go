@@ -0,0 +1,42 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLoginLockedUntil_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if _, err := r.LoginLockedUntil(context.Background(), "user:alice", "ip:127.0.0.1"); err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
+
+func TestRecordLoginFailure_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if _, err := r.RecordLoginFailure(context.Background(), "user:alice", time.Hour); err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
+
+func TestLockLogin_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if err := r.LockLogin(context.Background(), "user:alice", time.Now()); err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
+
+func TestClearLoginFailures_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if err := r.ClearLoginFailures(context.Background(), "user:alice"); err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
+
+func TestRecordAuditEvent_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if err := r.RecordAuditEvent(context.Background(), &AuditEvent{Event: AuditLoginLockout}); err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
@@ -0,0 +1,237 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MaxMFAAttempts is how many wrong codes a login challenge accepts before it
+// stops working.
+const MaxMFAAttempts = 5
+
+// SetTOTPSecret stores a new, not yet enabled TOTP secret for userID. It
+// fails with ErrNotFound when the user has TOTP enabled already.
+func (r *postgresRepository) SetTOTPSecret(ctx context.Context, userID, secret string) (err error) {
+	if err := r.checkDB(); err != nil {
+		return err
+	}
+	ctx, done := r.operation(ctx, "SetTOTPSecret", &err)
+	defer done()
+	result, err := r.conn().ExecContext(ctx,
+		`UPDATE users SET totp_secret = $2, totp_last_step = NULL WHERE id = $1 AND totp_enabled_at IS NULL`,
+		userID, secret,
+	)
+	if err != nil {
+		log.WithError(err).Error("Failed to store TOTP secret")
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// EnableTOTP turns on the pending TOTP secret of userID, recording step as
+// used, and replaces the user's recovery codes with recoveryHashes.
+func (r *postgresRepository) EnableTOTP(ctx context.Context, userID string, step int64, recoveryHashes []string) (err error) {
+	if err := r.checkDB(); err != nil {
+		return err
+	}
+	ctx, done := r.operation(ctx, "EnableTOTP", &err)
+	defer done()
+	tx, err := r.begin(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE users SET totp_enabled_at = NOW(), totp_last_step = $2
+		 WHERE id = $1 AND totp_secret IS NOT NULL AND totp_enabled_at IS NULL`, userID, step,
+	)
+	if err != nil {
+		log.WithError(err).Error("Failed to enable TOTP")
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrNotFound
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx querier, userID string, hashes []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		log.WithError(err).Error("Failed to drop recovery codes")
+		return err
+	}
+	for _, hash := range hashes {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hash,
+		); err != nil {
+			log.WithError(err).Error("Failed to store recovery code")
+			return err
+		}
+	}
+	return nil
+}
+
+// DisableTOTP removes the TOTP secret and recovery codes of userID.
+func (r *postgresRepository) DisableTOTP(ctx context.Context, userID string) (err error) {
+	if err := r.checkDB(); err != nil {
+		return err
+	}
+	ctx, done := r.operation(ctx, "DisableTOTP", &err)
+	defer done()
+	tx, err := r.begin(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL WHERE id = $1`, userID,
+	); err != nil {
+		log.WithError(err).Error("Failed to disable TOTP")
+		return err
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records step as the last accepted TOTP step of userID. It
+// returns false when that step or a later one was used already, i.e. when
+// the code is being replayed.
+func (r *postgresRepository) UseTOTPStep(ctx context.Context, userID string, step int64) (_ bool, err error) {
+	if err := r.checkDB(); err != nil {
+		return false, err
+	}
+	ctx, done := r.operation(ctx, "UseTOTPStep", &err)
+	defer done()
+	result, err := r.conn().ExecContext(ctx,
+		`UPDATE users SET totp_last_step = $2
+		 WHERE id = $1 AND (totp_last_step IS NULL OR totp_last_step < $2)`, userID, step,
+	)
+	if err != nil {
+		log.WithError(err).Error("Failed to record TOTP step")
+		return false, err
+	}
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+// UseRecoveryCode uses up the unused recovery code of userID with codeHash,
+// returning false when there is none.
+func (r *postgresRepository) UseRecoveryCode(ctx context.Context, userID, codeHash string) (_ bool, err error) {
+	if err := r.checkDB(); err != nil {
+		return false, err
+	}
+	ctx, done := r.operation(ctx, "UseRecoveryCode", &err)
+	defer done()
+	result, err := r.conn().ExecContext(ctx,
+		`UPDATE recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userID, codeHash,
+	)
+	if err != nil {
+		log.WithError(err).Error("Failed to use recovery code")
+		return false, err
+	}
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+// CreateMFAChallenge stores the hash of a login challenge for userID.
+// Finished and expired challenges of the user are dropped on the way.
+func (r *postgresRepository) CreateMFAChallenge(ctx context.Context, userID, tokenHash string, expiresAt time.Time) (err error) {
+	if err := r.checkDB(); err != nil {
+		return err
+	}
+	ctx, done := r.operation(ctx, "CreateMFAChallenge", &err)
+	defer done()
+	if _, err := r.conn().ExecContext(ctx,
+		`DELETE FROM mfa_challenges WHERE user_id = $1 AND (expires_at < NOW() OR used_at IS NOT NULL)`, userID,
+	); err != nil {
+		log.WithError(err).Warn("Failed to drop stale MFA challenges")
+	}
+	_, err = r.conn().ExecContext(ctx,
+		`INSERT INTO mfa_challenges (token_hash, user_id, expires_at) VALUES ($1, $2, $3)`,
+		tokenHash, userID, expiresAt,
+	)
+	if err != nil {
+		log.WithError(err).Error("Failed to store MFA challenge")
+	}
+	return err
+}
+
+// GetMFAChallenge returns the user of a pending challenge. Unknown, expired,
+// finished and exhausted challenges yield ErrNotFound.
+func (r *postgresRepository) GetMFAChallenge(ctx context.Context, tokenHash string) (_ string, err error) {
+	if err := r.checkDB(); err != nil {
+		return "", err
+	}
+	ctx, done := r.operation(ctx, "GetMFAChallenge", &err)
+	defer done()
+	var userID string
+	err = r.conn().QueryRowContext(ctx,
+		`SELECT user_id FROM mfa_challenges
+		 WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW() AND failed_attempts < $2`,
+		tokenHash, MaxMFAAttempts,
+	).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to get MFA challenge")
+		return "", err
+	}
+	return userID, nil
+}
+
+// FailMFAChallenge counts a wrong code against a challenge.
+func (r *postgresRepository) FailMFAChallenge(ctx context.Context, tokenHash string) (err error) {
+	if err := r.checkDB(); err != nil {
+		return err
+	}
+	ctx, done := r.operation(ctx, "FailMFAChallenge", &err)
+	defer done()
+	if _, err := r.conn().ExecContext(ctx,
+		`UPDATE mfa_challenges SET failed_attempts = failed_attempts + 1 WHERE token_hash = $1`, tokenHash,
+	); err != nil {
+		log.WithError(err).Error("Failed to record MFA failure")
+		return err
+	}
+	return nil
+}
+
+// CompleteMFAChallenge finishes a pending challenge. It yields ErrNotFound
+// when the challenge is no longer pending, e.g. because a concurrent request
+// completed it first.
+func (r *postgresRepository) CompleteMFAChallenge(ctx context.Context, tokenHash string) (err error) {
+	if err := r.checkDB(); err != nil {
+		return err
+	}
+	ctx, done := r.operation(ctx, "CompleteMFAChallenge", &err)
+	defer done()
+	result, err := r.conn().ExecContext(ctx,
+		`UPDATE mfa_challenges SET used_at = NOW()
+		 WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW() AND failed_attempts < $2`,
+		tokenHash, MaxMFAAttempts,
+	)
+	if err != nil {
+		log.WithError(err).Error("Failed to complete MFA challenge")
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSetTOTPSecret_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if err := r.SetTOTPSecret(context.Background(), "user", "secret"); err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
+
+func TestEnableTOTP_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if err := r.EnableTOTP(context.Background(), "user", 1, []string{"hash"}); err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
+
+func TestDisableTOTP_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if err := r.DisableTOTP(context.Background(), "user"); err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
+
+func TestUseTOTPStep_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if ok, err := r.UseTOTPStep(context.Background(), "user", 1); err == nil || ok {
+		t.Error("Expected error with nil db")
+	}
+}
+
+func TestUseRecoveryCode_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if ok, err := r.UseRecoveryCode(context.Background(), "user", "hash"); err == nil || ok {
+		t.Error("Expected error with nil db")
+	}
+}
+
+func TestMFAChallenge_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if err := r.CreateMFAChallenge(context.Background(), "user", "hash", time.Now()); err == nil {
+		t.Error("Expected error with nil db on create")
+	}
+	if _, err := r.GetMFAChallenge(context.Background(), "hash"); err == nil {
+		t.Error("Expected error with nil db on get")
+	}
+	if err := r.FailMFAChallenge(context.Background(), "hash"); err == nil {
+		t.Error("Expected error with nil db on fail")
+	}
+	if err := r.CompleteMFAChallenge(context.Background(), "hash"); err == nil {
+		t.Error("Expected error with nil db on complete")
+	}
+}
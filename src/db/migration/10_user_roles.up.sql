@@ -0,0 +1,4 @@
+ALTER TABLE users
+    ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'user',
+    ADD COLUMN disabled_at TIMESTAMPTZ,
+    ADD CONSTRAINT users_role_valid CHECK (role IN ('user', 'admin'));
@@ -0,0 +1,16 @@
+-- Personal API keys. Only the SHA-256 hash of a key is stored; prefix keeps
+-- its first characters so that users can tell their keys apart.
+CREATE TABLE api_keys (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(32) NOT NULL,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    scope VARCHAR(16) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    CONSTRAINT api_keys_scope_valid CHECK (scope IN ('read', 'read_write'))
+);
+
+CREATE INDEX api_keys_user_id ON api_keys (user_id);
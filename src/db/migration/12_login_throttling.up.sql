@@ -0,0 +1,21 @@
+-- Failed login attempts per key ("user:<name>" or "ip:<address>"), used to
+-- slow down password guessing.
+CREATE TABLE login_failures (
+    key VARCHAR(300) PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at TIMESTAMPTZ NOT NULL,
+    locked_until TIMESTAMPTZ
+);
+
+-- Security relevant events such as account lockouts.
+CREATE TABLE audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    event VARCHAR(64) NOT NULL,
+    user_id UUID,
+    username VARCHAR(255),
+    ip VARCHAR(64),
+    detail TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX audit_events_created_at ON audit_events (created_at);
@@ -0,0 +1,27 @@
+-- TOTP second factor. totp_secret is set on enrollment and only protects
+-- logins once totp_enabled_at is set; totp_last_step is the time step of
+-- the last accepted code, which may not be used again.
+ALTER TABLE users
+    ADD COLUMN totp_secret VARCHAR(64),
+    ADD COLUMN totp_enabled_at TIMESTAMPTZ,
+    ADD COLUMN totp_last_step BIGINT;
+
+-- One-time recovery codes, stored as SHA-256 hashes.
+CREATE TABLE recovery_codes (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMPTZ,
+    UNIQUE (user_id, code_hash)
+);
+
+-- Pending second steps of logins whose password was accepted.
+CREATE TABLE mfa_challenges (
+    token_hash CHAR(64) PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    failed_attempts INTEGER NOT NULL DEFAULT 0,
+    used_at TIMESTAMPTZ
+);
+
+CREATE INDEX mfa_challenges_user_id ON mfa_challenges (user_id);
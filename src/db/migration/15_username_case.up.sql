@@ -0,0 +1,11 @@
+-- Usernames are unique ignoring case. Accounts whose names differ only in
+-- case have to be renamed or merged by hand before this migration can run.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM users GROUP BY lower(username) HAVING COUNT(*) > 1) THEN
+        RAISE EXCEPTION 'users contains usernames that differ only in case';
+    END IF;
+END
+$$;
+
+CREATE UNIQUE INDEX users_username_lower ON users (lower(username));
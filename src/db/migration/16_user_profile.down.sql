@@ -0,0 +1,21 @@
+-- Anonymized subscriptions were kept on purpose and have no owner to go back
+-- to, so the downgrade stops rather than dropping them.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM subscriptions WHERE user_id IS NULL) THEN
+        RAISE EXCEPTION 'subscriptions has anonymized rows without user_id; move or delete them before downgrading';
+    END IF;
+END
+$$;
+
+DROP INDEX IF EXISTS audit_events_user_id;
+
+ALTER TABLE subscriptions ALTER COLUMN user_id SET NOT NULL;
+
+DROP INDEX IF EXISTS users_email_lower;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS timezone,
+    DROP COLUMN IF EXISTS default_currency,
+    DROP COLUMN IF EXISTS email,
+    DROP COLUMN IF EXISTS display_name;
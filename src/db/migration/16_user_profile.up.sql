@@ -0,0 +1,13 @@
+ALTER TABLE users
+    ADD COLUMN display_name VARCHAR(100),
+    ADD COLUMN email VARCHAR(255),
+    ADD COLUMN default_currency CHAR(3),
+    ADD COLUMN timezone VARCHAR(64);
+
+CREATE UNIQUE INDEX users_email_lower ON users (lower(email));
+
+-- Subscriptions of deleted accounts may be kept anonymized, without a user.
+ALTER TABLE subscriptions ALTER COLUMN user_id DROP NOT NULL;
+
+-- Account deletion and data export look up audit events by user.
+CREATE INDEX audit_events_user_id ON audit_events (user_id);
@@ -0,0 +1,11 @@
+ALTER TABLE subscriptions
+    DROP CONSTRAINT IF EXISTS subscriptions_user_id_fkey,
+    ALTER COLUMN user_id TYPE VARCHAR(255) USING user_id::text;
+
+INSERT INTO subscriptions (id, service_name, price, user_id, start_date, end_date, created_at, updated_at,
+                           currency, billing_period, billing_interval_months, billing_anchor)
+SELECT id, service_name, price, user_id, start_date, end_date, created_at, updated_at,
+       currency, billing_period, billing_interval_months, billing_anchor
+FROM orphaned_subscriptions;
+
+DROP TABLE orphaned_subscriptions;
@@ -0,0 +1,19 @@
+-- Subscriptions whose user_id names no existing user cannot satisfy the
+-- foreign key. They are moved here for review instead of being dropped.
+CREATE TABLE orphaned_subscriptions (LIKE subscriptions);
+ALTER TABLE orphaned_subscriptions ADD COLUMN quarantined_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+WITH orphans AS (
+    DELETE FROM subscriptions s
+    WHERE s.user_id IS NOT NULL
+      AND NOT EXISTS (SELECT 1 FROM users u WHERE u.id::text = lower(trim(s.user_id)))
+    RETURNING s.*
+)
+INSERT INTO orphaned_subscriptions SELECT *, NOW() FROM orphans;
+
+-- NULL user_id marks subscriptions anonymized on account deletion. Migration
+-- 22 applies the ON DELETE action chosen with DB_SUBSCRIPTION_ON_USER_DELETE.
+ALTER TABLE subscriptions
+    ALTER COLUMN user_id TYPE UUID USING lower(trim(user_id))::uuid,
+    ADD CONSTRAINT subscriptions_user_id_fkey
+        FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
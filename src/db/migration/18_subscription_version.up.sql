@@ -0,0 +1,2 @@
+-- version counts the updates of a subscription and backs its ETag.
+ALTER TABLE subscriptions ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
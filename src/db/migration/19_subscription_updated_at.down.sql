@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS subscriptions_user_id_updated_at;
+DROP TRIGGER IF EXISTS subscriptions_updated_at ON subscriptions;
+DROP FUNCTION IF EXISTS subscriptions_touch_updated_at();
@@ -0,0 +1,17 @@
+-- Keeps updated_at current for every write, including ones made outside the
+-- repository. Rows whose values do not change keep their timestamp.
+CREATE FUNCTION subscriptions_touch_updated_at() RETURNS trigger AS $$
+BEGIN
+    IF NEW IS DISTINCT FROM OLD THEN
+        NEW.updated_at := NOW();
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER subscriptions_updated_at
+    BEFORE UPDATE ON subscriptions
+    FOR EACH ROW EXECUTE FUNCTION subscriptions_touch_updated_at();
+
+-- Serves incremental pulls: updated_since filters and updated_at ordering.
+CREATE INDEX subscriptions_user_id_updated_at ON subscriptions (user_id, updated_at, id);
@@ -0,0 +1,6 @@
+-- Trashed subscriptions were deleted as far as users are concerned.
+DELETE FROM subscriptions WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS subscriptions_deleted_at;
+DROP INDEX IF EXISTS subscriptions_trash;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,5 @@
+-- Deleted subscriptions stay in the trash until the purge job removes them.
+ALTER TABLE subscriptions ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX subscriptions_trash ON subscriptions (user_id, deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX subscriptions_deleted_at ON subscriptions (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,11 @@
+DROP TRIGGER IF EXISTS audit_events_no_truncate ON audit_events;
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+DROP FUNCTION IF EXISTS audit_events_append_only();
+DROP INDEX IF EXISTS audit_events_actor_id;
+DROP INDEX IF EXISTS audit_events_user_id;
+CREATE INDEX audit_events_user_id ON audit_events (user_id);
+ALTER TABLE audit_events
+    DROP COLUMN IF EXISTS request_id,
+    DROP COLUMN IF EXISTS changes,
+    DROP COLUMN IF EXISTS subscription_id,
+    DROP COLUMN IF EXISTS actor_id;
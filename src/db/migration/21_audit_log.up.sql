@@ -0,0 +1,35 @@
+-- Audit trail of subscription and account changes. user_id is the user an
+-- event is about and actor_id the one who caused it; they differ when an
+-- admin changes another user's data. changes holds the changed members
+-- before and after the change.
+ALTER TABLE audit_events
+    ADD COLUMN actor_id UUID,
+    ADD COLUMN subscription_id BIGINT,
+    ADD COLUMN changes JSONB,
+    ADD COLUMN request_id VARCHAR(64);
+
+-- /audit pages through the events of a user, or of an actor, newest first.
+DROP INDEX IF EXISTS audit_events_user_id;
+CREATE INDEX audit_events_user_id ON audit_events (user_id, id);
+CREATE INDEX audit_events_actor_id ON audit_events (actor_id, id);
+
+-- Events are append-only. The only change allowed is the one account
+-- deletion makes: stripping the username, address and recorded values.
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND NEW.username IS NULL AND NEW.ip IS NULL
+        AND (NEW.changes IS NULL OR NEW.changes = OLD.changes)
+        AND to_jsonb(NEW) - 'username' - 'ip' - 'changes' = to_jsonb(OLD) - 'username' - 'ip' - 'changes' THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'audit_events is append-only' USING ERRCODE = 'insufficient_privilege';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+CREATE TRIGGER audit_events_no_truncate
+    BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
@@ -0,0 +1,26 @@
+-- Applies the ON DELETE action of the foreign key from subscriptions to
+-- users. InitDB passes DB_SUBSCRIPTION_ON_USER_DELETE to the migration
+-- connection as crudl.subscription_on_user_delete; without it the key keeps
+-- ON DELETE CASCADE from migration 17.
+DO $$
+DECLARE
+    action TEXT := coalesce(nullif(current_setting('crudl.subscription_on_user_delete', true), ''), 'cascade');
+    clause TEXT;
+BEGIN
+    clause := CASE action
+        WHEN 'cascade' THEN 'CASCADE'
+        WHEN 'set_null' THEN 'SET NULL'
+        WHEN 'restrict' THEN 'RESTRICT'
+    END;
+    IF clause IS NULL THEN
+        RAISE EXCEPTION 'unknown ON DELETE action %', action;
+    END IF;
+    IF clause <> 'CASCADE' THEN
+        EXECUTE format(
+            'ALTER TABLE subscriptions
+                 DROP CONSTRAINT subscriptions_user_id_fkey,
+                 ADD CONSTRAINT subscriptions_user_id_fkey
+                     FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE %s', clause);
+    END IF;
+END
+$$;
@@ -0,0 +1,13 @@
+ALTER TABLE subscriptions
+    ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'RUB';
+
+CREATE TABLE exchange_rates (
+    base_currency CHAR(3) NOT NULL,
+    quote_currency CHAR(3) NOT NULL,
+    month DATE NOT NULL,
+    rate NUMERIC(20, 8) NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (base_currency, quote_currency, month),
+    CONSTRAINT rate_positive CHECK (rate > 0),
+    CONSTRAINT distinct_currencies CHECK (base_currency <> quote_currency)
+);
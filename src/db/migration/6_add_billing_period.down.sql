@@ -0,0 +1,6 @@
+ALTER TABLE subscriptions
+    DROP CONSTRAINT IF EXISTS billing_interval_valid,
+    DROP CONSTRAINT IF EXISTS billing_period_valid,
+    DROP COLUMN IF EXISTS billing_anchor,
+    DROP COLUMN IF EXISTS billing_interval_months,
+    DROP COLUMN IF EXISTS billing_period;
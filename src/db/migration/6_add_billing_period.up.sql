@@ -0,0 +1,14 @@
+ALTER TABLE subscriptions
+    ADD COLUMN billing_period VARCHAR(16) NOT NULL DEFAULT 'monthly',
+    ADD COLUMN billing_interval_months INTEGER NULL,
+    ADD COLUMN billing_anchor DATE NULL;
+
+UPDATE subscriptions SET billing_anchor = start_date;
+
+ALTER TABLE subscriptions
+    ALTER COLUMN billing_anchor SET NOT NULL,
+    ADD CONSTRAINT billing_period_valid
+        CHECK (billing_period IN ('weekly', 'monthly', 'quarterly', 'yearly', 'custom')),
+    ADD CONSTRAINT billing_interval_valid
+        CHECK ((billing_period = 'custom') = (billing_interval_months IS NOT NULL)
+               AND (billing_interval_months IS NULL OR billing_interval_months > 0));
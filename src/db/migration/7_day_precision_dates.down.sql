@@ -0,0 +1,4 @@
+UPDATE subscriptions
+SET start_date = date_trunc('month', start_date)::date,
+    end_date = date_trunc('month', end_date)::date,
+    billing_anchor = date_trunc('month', billing_anchor)::date;
@@ -0,0 +1,5 @@
+-- End dates used to be stored as the first day of their month while meaning
+-- "until the end of that month"; store the actual inclusive last day instead.
+UPDATE subscriptions
+SET end_date = (end_date + interval '1 month - 1 day')::date
+WHERE end_date IS NOT NULL;
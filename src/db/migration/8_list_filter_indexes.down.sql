@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS subscriptions_service_name_trgm;
+DROP INDEX IF EXISTS subscriptions_user_id_service_name_prefix;
+DROP INDEX IF EXISTS subscriptions_user_id_end_date_id;
+DROP INDEX IF EXISTS subscriptions_user_id_start_date_id;
+DROP INDEX IF EXISTS subscriptions_user_id_price_id;
+DROP INDEX IF EXISTS subscriptions_user_id_service_name_id;
+
+CREATE INDEX subscriptions_service_name ON subscriptions (service_name);
+CREATE INDEX subscriptions_price ON subscriptions (price);
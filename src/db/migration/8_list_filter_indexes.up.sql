@@ -0,0 +1,16 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Every list query is scoped by user_id, so the single-column indexes from the
+-- first migration were never chosen. Replace them with indexes that lead with
+-- user_id and end with id, which serve keyset pagination for each sort order.
+DROP INDEX IF EXISTS subscriptions_service_name;
+DROP INDEX IF EXISTS subscriptions_price;
+
+CREATE INDEX subscriptions_user_id_service_name_id ON subscriptions (user_id, service_name, id);
+CREATE INDEX subscriptions_user_id_price_id ON subscriptions (user_id, price, id);
+CREATE INDEX subscriptions_user_id_start_date_id ON subscriptions (user_id, start_date, id);
+CREATE INDEX subscriptions_user_id_end_date_id ON subscriptions (user_id, end_date, id);
+
+-- Case-insensitive prefix and substring search on service names.
+CREATE INDEX subscriptions_user_id_service_name_prefix ON subscriptions (user_id, lower(service_name) text_pattern_ops);
+CREATE INDEX subscriptions_service_name_trgm ON subscriptions USING gin (lower(service_name) gin_trgm_ops);
@@ -0,0 +1,28 @@
+-- Refresh tokens are stored as SHA-256 hashes. Every login starts a family;
+-- rotating a token marks it used and issues the next one in the same family,
+-- so presenting a used token again reveals theft and revokes the family.
+CREATE TABLE refresh_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    family_id UUID NOT NULL DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE INDEX refresh_tokens_user_id ON refresh_tokens (user_id);
+
+-- Access tokens revoked before expiry, by jti. Rows are dropped once the
+-- token would have expired anyway.
+CREATE TABLE revoked_tokens (
+    jti TEXT PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX revoked_tokens_expires_at ON revoked_tokens (expires_at);
+
+-- Access tokens issued before this moment are rejected (logout everywhere).
+ALTER TABLE users ADD COLUMN tokens_valid_after TIMESTAMPTZ;
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// UpdatePassword replaces the password hash of userID.
+func (r *postgresRepository) UpdatePassword(ctx context.Context, userID, hashedPassword string) (err error) {
+	if err := r.checkDB(); err != nil {
+		return err
+	}
+	ctx, done := r.operation(ctx, "UpdatePassword", &err)
+	defer done()
+	result, err := r.conn().ExecContext(ctx, `UPDATE users SET password = $2 WHERE id = $1`, userID, hashedPassword)
+	if err != nil {
+		log.WithError(err).Error("Failed to update password")
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// CreatePasswordResetToken stores the hash of a reset token for userID.
+// Expired and used tokens of the user are dropped on the way.
+func (r *postgresRepository) CreatePasswordResetToken(ctx context.Context, userID, tokenHash string, expiresAt time.Time) (err error) {
+	if err := r.checkDB(); err != nil {
+		return err
+	}
+	ctx, done := r.operation(ctx, "CreatePasswordResetToken", &err)
+	defer done()
+	if _, err := r.conn().ExecContext(ctx,
+		`DELETE FROM password_reset_tokens WHERE user_id = $1 AND (expires_at < NOW() OR used_at IS NOT NULL)`, userID,
+	); err != nil {
+		log.WithError(err).Warn("Failed to drop stale password reset tokens")
+	}
+	_, err = r.conn().ExecContext(ctx,
+		`INSERT INTO password_reset_tokens (token_hash, user_id, expires_at) VALUES ($1, $2, $3)`,
+		tokenHash, userID, expiresAt,
+	)
+	if err != nil {
+		log.WithError(err).Error("Failed to store password reset token")
+	}
+	return err
+}
+
+// ConsumePasswordResetToken uses up the reset token with tokenHash and sets
+// the password of its user, returning the user ID. Every other outstanding
+// reset token of the user is used up as well. Unknown, expired or used
+// tokens yield ErrNotFound.
+func (r *postgresRepository) ConsumePasswordResetToken(ctx context.Context, tokenHash, hashedPassword string) (_ string, err error) {
+	if err := r.checkDB(); err != nil {
+		return "", err
+	}
+	ctx, done := r.operation(ctx, "ConsumePasswordResetToken", &err)
+	defer done()
+	tx, err := r.begin(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userID string
+	err = tx.QueryRowContext(ctx,
+		`UPDATE password_reset_tokens SET used_at = NOW()
+		 WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		 RETURNING user_id`, tokenHash,
+	).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to use password reset token")
+		return "", err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE password_reset_tokens SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL`, userID,
+	); err != nil {
+		log.WithError(err).Error("Failed to use other password reset tokens")
+		return "", err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET password = $2 WHERE id = $1`, userID, hashedPassword); err != nil {
+		log.WithError(err).Error("Failed to reset password")
+		return "", err
+	}
+	return userID, tx.Commit()
+}
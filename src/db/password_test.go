@@ -0,0 +1,28 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestUpdatePassword_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if err := r.UpdatePassword(context.Background(), "user", "hash"); err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
+
+func TestCreatePasswordResetToken_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if err := r.CreatePasswordResetToken(context.Background(), "user", "hash", time.Now()); err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
+
+func TestConsumePasswordResetToken_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if _, err := r.ConsumePasswordResetToken(context.Background(), "hash", "password"); err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
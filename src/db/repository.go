@@ -1,44 +1,168 @@
 package db
 
 import (
+	"context"
 	"crudl_service/src/types"
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
 type User struct {
 	ID       string `json:"id"`
 	Username string `json:"username"`
 	Password string `json:"password"`
+	// Role is types.RoleUser or types.RoleAdmin.
+	Role string `json:"role"`
+	// DisabledAt is set while the account is disabled by an admin.
+	DisabledAt *time.Time `json:"disabled_at"`
+	// TOTPSecret is the user's TOTP secret, or empty. Logins require a code
+	// only once TOTPEnabledAt is set.
+	TOTPSecret    string     `json:"-"`
+	TOTPEnabledAt *time.Time `json:"totp_enabled_at"`
 }
 
 type SubscriptionRepository interface {
-	Create(data *types.UserSubscription) (int64, error)
-	Get(id int64) (*types.UserSubscription, error)
-	Update(data *types.UserSubscription) error
-	Delete(id int64) error
-	List(userID string, afterID *int64, limit int) ([]types.UserSubscription, error)
-	Sum(data *types.UserSumSubscriptionRequest) (int64, error)
+	Create(ctx context.Context, data *types.UserSubscription) (int64, error)
+	Get(ctx context.Context, id int64) (*types.UserSubscription, error)
+	// Update and Delete only touch the subscription when it belongs to the
+	// given owner, data.UserId for Update, and fail with ErrNotFound
+	// otherwise. An empty owner matches anonymized subscriptions. Update
+	// also fails with ErrStale when a non-zero data.Version is outdated.
+	Update(ctx context.Context, data *types.UserSubscription) error
+	// Delete moves the subscription to the trash. Get, List, Count and Sum
+	// skip trashed subscriptions unless the list query asks for them.
+	Delete(ctx context.Context, id int64, ownerID string) error
+	GetDeleted(ctx context.Context, id int64) (*types.UserSubscription, error)
+	Restore(ctx context.Context, id int64, ownerID string) error
+	PurgeDeleted(ctx context.Context, before time.Time, limit int) (int64, error)
+	List(ctx context.Context, userID string, query *types.SubscriptionListQuery) ([]types.UserSubscription, error)
+	Count(ctx context.Context, userID string, query *types.SubscriptionListQuery) (int64, error)
+	Sum(ctx context.Context, data *types.UserSumSubscriptionRequest) (int64, error)
 }
 
 type UserRepository interface {
-	GetUserByUsername(username string) (*User, error)
-	CreateUser(username, hashedPassword string) (string, error)
+	// GetUserByUsername matches usernames ignoring case.
+	GetUserByUsername(ctx context.Context, username string) (*User, error)
+	GetUser(ctx context.Context, id string) (*User, error)
+	// ListUsers pages through users ordered by username, starting after the
+	// given username.
+	ListUsers(ctx context.Context, after string, limit int) ([]User, error)
+	// CreateUser fails with ErrConflict when the username is taken, ignoring
+	// case.
+	CreateUser(ctx context.Context, username, hashedPassword string) (string, error)
+	// UpdateUserAccess stores the role and disabled state of user.
+	UpdateUserAccess(ctx context.Context, user *User) error
+	UpdatePassword(ctx context.Context, userID, hashedPassword string) error
+	CreatePasswordResetToken(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error
+	ConsumePasswordResetToken(ctx context.Context, tokenHash, hashedPassword string) (string, error)
+}
+
+// AccountRepository serves the self-service account endpoints: the profile,
+// account deletion and the export of everything stored about a user.
+type AccountRepository interface {
+	GetProfile(ctx context.Context, userID string) (*types.UserProfile, error)
+	// UpdateProfile fails with ErrConflict when the email address is taken,
+	// ignoring case.
+	UpdateProfile(ctx context.Context, userID string, profile *types.Profile) (*types.UserProfile, error)
+	// DeleteUser deletes the subscriptions of the user, or detaches them from
+	// it when anonymizeSubscriptions is set.
+	DeleteUser(ctx context.Context, userID string, anonymizeSubscriptions bool) error
+	ExportUser(ctx context.Context, userID string) (*types.UserExport, error)
+}
+
+// TokenRepository stores refresh tokens and revoked access tokens. Tokens
+// are identified by the hex SHA-256 hash of their value.
+type TokenRepository interface {
+	CreateRefreshToken(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error
+	RotateRefreshToken(ctx context.Context, oldHash, newHash string, expiresAt time.Time) (string, error)
+	RevokeRefreshToken(ctx context.Context, userID, tokenHash string) error
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	RevokeUserTokens(ctx context.Context, userID string) error
+	AuthenticateAccessToken(ctx context.Context, jti, userID string, issuedAt time.Time) (string, error)
+}
+
+// APIKeyRepository stores personal API keys, identified by the hex SHA-256
+// hash of the key.
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, userID, keyHash string, key *types.APIKey) error
+	ListAPIKeys(ctx context.Context, userID string) ([]types.APIKey, error)
+	RenameAPIKey(ctx context.Context, userID string, id int64, name string) (*types.APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID string, id int64) error
+	AuthenticateAPIKey(ctx context.Context, keyHash string) (*APIKeyOwner, error)
+}
+
+// MFARepository stores TOTP secrets, recovery codes and pending login
+// challenges. Recovery codes and challenges are identified by hex SHA-256
+// hashes.
+type MFARepository interface {
+	SetTOTPSecret(ctx context.Context, userID, secret string) error
+	EnableTOTP(ctx context.Context, userID string, step int64, recoveryHashes []string) error
+	DisableTOTP(ctx context.Context, userID string) error
+	UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error)
+	CreateMFAChallenge(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error
+	GetMFAChallenge(ctx context.Context, tokenHash string) (string, error)
+	FailMFAChallenge(ctx context.Context, tokenHash string) error
+	CompleteMFAChallenge(ctx context.Context, tokenHash string) error
+}
+
+// LoginAttemptRepository tracks failed logins per key, a username or a
+// client address, to throttle password guessing.
+type LoginAttemptRepository interface {
+	LoginLockedUntil(ctx context.Context, keys ...string) (time.Time, error)
+	RecordLoginFailure(ctx context.Context, key string, window time.Duration) (int, error)
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	ClearLoginFailures(ctx context.Context, key string) error
+}
+
+// AuditRepository records and lists the append-only audit trail of
+// security relevant events and data changes.
+type AuditRepository interface {
+	RecordAuditEvent(ctx context.Context, event *AuditEvent) error
+	ListAuditEvents(ctx context.Context, q *types.AuditEventQuery) ([]types.AuditEventInfo, error)
+}
+
+type ExchangeRateRepository interface {
+	SetExchangeRates(ctx context.Context, month string, rates []types.ExchangeRate) error
+	ListExchangeRates(ctx context.Context, month string) ([]types.ExchangeRate, error)
 }
 
-// Repository combines subscription and user operations.
+// Repository combines subscription, user, account, token, API key, MFA,
+// login throttling, audit and exchange rate operations, optionally grouped
+// into transactions.
 type Repository interface {
 	SubscriptionRepository
 	UserRepository
+	AccountRepository
+	TokenRepository
+	APIKeyRepository
+	MFARepository
+	LoginAttemptRepository
+	AuditRepository
+	ExchangeRateRepository
+
+	// WithTx runs fn in a transaction: every operation of the Repository
+	// passed to fn sees and commits the same state.
+	WithTx(ctx context.Context, fn func(Repository) error, opts ...TxOption) error
 }
 
 type postgresRepository struct {
 	db *sql.DB
+	// tx is set on the repositories WithTx passes to its callback.
+	tx        *sql.Tx
+	timeouts  Timeouts
+	isolation sql.IsolationLevel
 }
 
-// NewPostgresRepository returns a Repository backed by PostgreSQL.
-func NewPostgresRepository(db *sql.DB) Repository {
-	return &postgresRepository{db: db}
+// NewPostgresRepository returns a Repository backed by PostgreSQL whose
+// operations are bounded by timeouts and whose WithTx transactions default
+// to isolation.
+func NewPostgresRepository(db *sql.DB, timeouts Timeouts, isolation sql.IsolationLevel) Repository {
+	return &postgresRepository{db: db, timeouts: timeouts, isolation: isolation}
 }
 
 func (r *postgresRepository) checkDB() error {
@@ -48,27 +172,110 @@ func (r *postgresRepository) checkDB() error {
 	return nil
 }
 
-func (r *postgresRepository) GetUserByUsername(username string) (*User, error) {
+// userColumns is the select list understood by scanUser.
+const userColumns = `id, username, password, role, disabled_at, COALESCE(totp_secret, ''), totp_enabled_at`
+
+func scanUser(row rowScanner, user *User) error {
+	return row.Scan(&user.ID, &user.Username, &user.Password, &user.Role, &user.DisabledAt, &user.TOTPSecret, &user.TOTPEnabledAt)
+}
+
+func (r *postgresRepository) GetUserByUsername(ctx context.Context, username string) (_ *User, err error) {
 	if err := r.checkDB(); err != nil {
 		return nil, err
 	}
+	ctx, done := r.operation(ctx, "GetUserByUsername", &err)
+	defer done()
 	user := &User{}
-	if err := r.db.QueryRow(
-		`SELECT id, username, password FROM users WHERE username = $1`, username,
-	).Scan(&user.ID, &user.Username, &user.Password); err != nil {
+	if err := scanUser(r.conn().QueryRowContext(ctx,
+		`SELECT `+userColumns+` FROM users WHERE lower(username) = lower($1)`, username,
+	), user); errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	} else if err != nil {
 		return nil, err
 	}
 	return user, nil
 }
 
-func (r *postgresRepository) CreateUser(username, hashedPassword string) (string, error) {
+func (r *postgresRepository) GetUser(ctx context.Context, id string) (_ *User, err error) {
+	if err := r.checkDB(); err != nil {
+		return nil, err
+	}
+	ctx, done := r.operation(ctx, "GetUser", &err)
+	defer done()
+	user := &User{}
+	err = scanUser(r.conn().QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, id), user)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to get user")
+		return nil, err
+	}
+	return user, nil
+}
+
+func (r *postgresRepository) ListUsers(ctx context.Context, after string, limit int) (_ []User, err error) {
+	if err := r.checkDB(); err != nil {
+		return nil, err
+	}
+	ctx, done := r.operation(ctx, "ListUsers", &err)
+	defer done()
+	rows, err := r.conn().QueryContext(ctx,
+		`SELECT `+userColumns+` FROM users WHERE username > $1 ORDER BY username LIMIT $2`, after, limit,
+	)
+	if err != nil {
+		log.WithError(err).Error("Failed to list users")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := scanUser(rows, &user); err != nil {
+			log.WithError(err).Error("Failed to scan user row")
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (r *postgresRepository) CreateUser(ctx context.Context, username, hashedPassword string) (_ string, err error) {
 	if err := r.checkDB(); err != nil {
 		return "", err
 	}
+	ctx, done := r.operation(ctx, "CreateUser", &err)
+	defer done()
 	var userID string
-	err := r.db.QueryRow(
+	err = r.conn().QueryRowContext(ctx,
 		`INSERT INTO users (username, password) VALUES ($1, $2) RETURNING id`,
 		username, hashedPassword,
 	).Scan(&userID)
-	return userID, err
+	if err != nil {
+		if err = translateError(err); !errors.Is(err, ErrConflict) {
+			log.WithError(err).Error("Failed to create user")
+		}
+		return "", err
+	}
+	return userID, nil
+}
+
+func (r *postgresRepository) UpdateUserAccess(ctx context.Context, user *User) (err error) {
+	if err := r.checkDB(); err != nil {
+		return err
+	}
+	ctx, done := r.operation(ctx, "UpdateUserAccess", &err)
+	defer done()
+	result, err := r.conn().ExecContext(ctx,
+		`UPDATE users SET role = $2, disabled_at = $3 WHERE id = $1`, user.ID, user.Role, user.DisabledAt,
+	)
+	if err != nil {
+		log.WithError(err).Error("Failed to update user access")
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
@@ -0,0 +1,51 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestGetUser_NilDB(t *testing.T) {
+	r := newNilRepo()
+	user, err := r.GetUser(context.Background(), "user")
+	if err == nil {
+		t.Error("Expected error with nil db")
+	}
+	if user != nil {
+		t.Error("Expected nil user")
+	}
+}
+
+func TestListUsers_NilDB(t *testing.T) {
+	r := newNilRepo()
+	users, err := r.ListUsers(context.Background(), "", 10)
+	if err == nil {
+		t.Error("Expected error with nil db")
+	}
+	if users != nil {
+		t.Error("Expected nil result")
+	}
+}
+
+func TestUpdateUserAccess_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if err := r.UpdateUserAccess(context.Background(), &User{ID: "user", Role: "admin"}); err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
+
+func TestTranslateError(t *testing.T) {
+	err := translateError(&pq.Error{Code: "23505", Constraint: "users_username_lower"})
+	var conflict *ConflictError
+	if !errors.Is(err, ErrConflict) || !errors.As(err, &conflict) || conflict.Constraint != "users_username_lower" {
+		t.Errorf("Expected a conflict on users_username_lower, got %v", err)
+	}
+
+	other := &pq.Error{Code: "23514"}
+	if err := translateError(other); err != other {
+		t.Errorf("Expected other errors unchanged, got %v", err)
+	}
+}
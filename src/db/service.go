@@ -1,38 +1,96 @@
 package db
 
 import (
+	"context"
 	"crudl_service/src/types"
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/lib/pq"
 	log "github.com/sirupsen/logrus"
 )
 
-var ErrNotFound = errors.New("not found")
+var (
+	ErrNotFound             = errors.New("not found")
+	ErrExchangeRateNotFound = errors.New("exchange rate not found")
+	// ErrConflict is matched by errors of writes that would duplicate a
+	// unique value, see ConflictError.
+	ErrConflict = errors.New("conflict")
+	// ErrUnknownUser is returned for a subscription of a user that does not
+	// exist.
+	ErrUnknownUser = errors.New("unknown user")
+	// ErrStale is returned for an update based on an outdated version.
+	ErrStale = errors.New("stale version")
+)
 
-func (r *postgresRepository) Create(data *types.UserSubscription) (int64, error) {
+func (r *postgresRepository) Create(ctx context.Context, data *types.UserSubscription) (_ int64, err error) {
 	if err := r.checkDB(); err != nil {
 		return 0, err
 	}
-	query := `INSERT INTO subscriptions (service_name, price, user_id, start_date, end_date)
-			  VALUES ($1, $2, $3, to_date($4, 'MM-YYYY'), CASE WHEN $5 IS NULL THEN NULL ELSE to_date($5, 'MM-YYYY') END) RETURNING id`
+	ctx, done := r.operation(ctx, "Create", &err)
+	defer done()
+	query := `INSERT INTO subscriptions (service_name, price, currency, user_id, start_date, end_date,
+			                           billing_period, billing_interval_months, billing_anchor)
+			  VALUES ($1, $2, $3, $4, $5::date, $6::date,
+			          $7, $8, COALESCE($9::date, $5::date)) RETURNING id`
 	var id int64
-	if err := r.db.QueryRow(query, data.ServiceName, data.Price, data.UserId, data.StartDate, data.EndDate).Scan(&id); err != nil {
+	if err := r.conn().QueryRowContext(ctx, query, data.ServiceName, data.Price, data.Currency, data.UserId, data.StartDate, data.EndDate,
+		data.BillingPeriod, data.BillingIntervalMonths, data.BillingAnchor).Scan(&id); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pqForeignKeyViolation {
+			return 0, ErrUnknownUser
+		}
 		log.WithError(err).Error("Failed to create subscription")
 		return 0, err
 	}
 	return id, nil
 }
 
-func (r *postgresRepository) Get(id int64) (*types.UserSubscription, error) {
+// subscriptionColumns is the select list understood by scanSubscription.
+// Anonymized subscriptions have no user and read with an empty user ID.
+const subscriptionColumns = `id, service_name, price, currency, COALESCE(user_id::text, ''),
+	to_char(start_date, 'YYYY-MM-DD'), to_char(end_date, 'YYYY-MM-DD'),
+	billing_period, billing_interval_months, to_char(billing_anchor, 'YYYY-MM-DD'), version,
+	created_at, updated_at, deleted_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSubscription(row rowScanner, sub *types.UserSubscription) error {
+	return row.Scan(&sub.Id, &sub.ServiceName, &sub.Price, &sub.Currency, &sub.UserId, &sub.StartDate, &sub.EndDate,
+		&sub.BillingPeriod, &sub.BillingIntervalMonths, &sub.BillingAnchor, &sub.Version,
+		&sub.CreatedAt, &sub.UpdatedAt, &sub.DeletedAt)
+}
+
+// Get returns the subscription identified by id unless it is in the trash.
+func (r *postgresRepository) Get(ctx context.Context, id int64) (_ *types.UserSubscription, err error) {
 	if err := r.checkDB(); err != nil {
 		return nil, err
 	}
-	query := `SELECT service_name, price, user_id, to_char(start_date, 'MM-YYYY'), to_char(end_date, 'MM-YYYY')
-			  FROM subscriptions WHERE id = $1`
-	sub := &types.UserSubscription{Id: id}
-	err := r.db.QueryRow(query, id).Scan(&sub.ServiceName, &sub.Price, &sub.UserId, &sub.StartDate, &sub.EndDate)
+	ctx, done := r.operation(ctx, "Get", &err)
+	defer done()
+	return r.getSubscription(ctx, id, "deleted_at IS NULL")
+}
+
+// GetDeleted returns the subscription identified by id if it is in the
+// trash.
+func (r *postgresRepository) GetDeleted(ctx context.Context, id int64) (_ *types.UserSubscription, err error) {
+	if err := r.checkDB(); err != nil {
+		return nil, err
+	}
+	ctx, done := r.operation(ctx, "GetDeleted", &err)
+	defer done()
+	return r.getSubscription(ctx, id, "deleted_at IS NOT NULL")
+}
+
+func (r *postgresRepository) getSubscription(ctx context.Context, id int64, cond string) (*types.UserSubscription, error) {
+	query := `SELECT ` + subscriptionColumns + ` FROM subscriptions WHERE id = $1 AND ` + cond
+	sub := &types.UserSubscription{}
+	err := scanSubscription(r.conn().QueryRowContext(ctx, query, id), sub)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrNotFound
@@ -43,37 +101,111 @@ func (r *postgresRepository) Get(id int64) (*types.UserSubscription, error) {
 	return sub, nil
 }
 
-func (r *postgresRepository) Update(data *types.UserSubscription) error {
+// Update replaces every user-editable field of the subscription identified by
+// data.Id, including its service name, provided it belongs to data.UserId and
+// is not in the trash.
+// A non-zero data.Version must match the stored version, or Update fails with
+// ErrStale. On success data.Version is the new version.
+func (r *postgresRepository) Update(ctx context.Context, data *types.UserSubscription) (err error) {
 	if err := r.checkDB(); err != nil {
 		return err
 	}
+	ctx, done := r.operation(ctx, "Update", &err)
+	defer done()
 	query := `UPDATE subscriptions
-			  SET price = $1, start_date = to_date($2, 'MM-YYYY'), end_date = CASE WHEN $3 IS NULL THEN NULL ELSE to_date($3, 'MM-YYYY') END
-			  WHERE user_id = $4 AND service_name = $5`
-	result, err := r.db.Exec(query, data.Price, data.StartDate, data.EndDate, data.UserId, data.ServiceName)
+			  SET service_name = $1, price = $2, currency = $3, start_date = $4::date, end_date = $5::date,
+			      billing_period = $6, billing_interval_months = $7, billing_anchor = COALESCE($8::date, $4::date),
+			      version = version + 1
+			  WHERE id = $9 AND user_id IS NOT DISTINCT FROM NULLIF($10, '')::uuid AND deleted_at IS NULL
+			    AND ($11 = 0 OR version = $11)
+			  RETURNING version`
+	err = r.conn().QueryRowContext(ctx, query, data.ServiceName, data.Price, data.Currency, data.StartDate, data.EndDate,
+		data.BillingPeriod, data.BillingIntervalMonths, data.BillingAnchor, data.Id, data.UserId, data.Version,
+	).Scan(&data.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		if data.Version == 0 {
+			return ErrNotFound
+		}
+		var exists bool
+		if err := r.conn().QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM subscriptions
+			 WHERE id = $1 AND user_id IS NOT DISTINCT FROM NULLIF($2, '')::uuid AND deleted_at IS NULL)`,
+			data.Id, data.UserId,
+		).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return ErrStale
+		}
+		return ErrNotFound
+	}
 	if err != nil {
 		log.WithError(err).Error("Failed to update subscription")
 		return err
 	}
-	rows, err := result.RowsAffected()
-	if err != nil {
+	return nil
+}
+
+// Delete moves the subscription identified by id to the trash, provided it
+// belongs to ownerID. PurgeDeleted removes it for good later.
+func (r *postgresRepository) Delete(ctx context.Context, id int64, ownerID string) (err error) {
+	if err := r.checkDB(); err != nil {
 		return err
 	}
-	if rows == 0 {
-		return ErrNotFound
+	ctx, done := r.operation(ctx, "Delete", &err)
+	defer done()
+	result, err := r.conn().ExecContext(ctx,
+		`UPDATE subscriptions SET deleted_at = NOW(), version = version + 1
+		 WHERE id = $1 AND user_id IS NOT DISTINCT FROM NULLIF($2, '')::uuid AND deleted_at IS NULL`, id, ownerID,
+	)
+	if err != nil {
+		log.WithError(err).Error("Failed to delete subscription")
+		return err
 	}
-	return nil
+	return requireRow(result)
 }
 
-func (r *postgresRepository) Delete(id int64) error {
+// Restore takes the subscription identified by id out of the trash, provided
+// it belongs to ownerID.
+func (r *postgresRepository) Restore(ctx context.Context, id int64, ownerID string) (err error) {
 	if err := r.checkDB(); err != nil {
 		return err
 	}
-	result, err := r.db.Exec(`DELETE FROM subscriptions WHERE id = $1`, id)
+	ctx, done := r.operation(ctx, "Restore", &err)
+	defer done()
+	result, err := r.conn().ExecContext(ctx,
+		`UPDATE subscriptions SET deleted_at = NULL, version = version + 1
+		 WHERE id = $1 AND user_id IS NOT DISTINCT FROM NULLIF($2, '')::uuid AND deleted_at IS NOT NULL`, id, ownerID,
+	)
 	if err != nil {
-		log.WithError(err).Error("Failed to delete subscription")
+		log.WithError(err).Error("Failed to restore subscription")
 		return err
 	}
+	return requireRow(result)
+}
+
+// PurgeDeleted permanently removes up to limit subscriptions that were moved
+// to the trash before the given time and returns how many it removed.
+func (r *postgresRepository) PurgeDeleted(ctx context.Context, before time.Time, limit int) (_ int64, err error) {
+	if err := r.checkDB(); err != nil {
+		return 0, err
+	}
+	ctx, done := r.operation(ctx, "PurgeDeleted", &err)
+	defer done()
+	result, err := r.conn().ExecContext(ctx,
+		`DELETE FROM subscriptions WHERE id IN (
+			 SELECT id FROM subscriptions WHERE deleted_at < $1 ORDER BY deleted_at LIMIT $2
+		 )`, before, limit,
+	)
+	if err != nil {
+		log.WithError(err).Error("Failed to purge deleted subscriptions")
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// requireRow turns a write that matched no row into ErrNotFound.
+func requireRow(result sql.Result) error {
 	rows, err := result.RowsAffected()
 	if err != nil {
 		return err
@@ -84,25 +216,135 @@ func (r *postgresRepository) Delete(id int64) error {
 	return nil
 }
 
-func (r *postgresRepository) List(userID string, afterID *int64, limit int) ([]types.UserSubscription, error) {
+// sortColumns maps the sort keys accepted by List to their column and the
+// cast applied to cursor values.
+var sortColumns = map[string]struct{ column, cast string }{
+	types.SortByID:          {"id", "::bigint"},
+	types.SortByPrice:       {"price", "::bigint"},
+	types.SortByStartDate:   {"start_date", "::date"},
+	types.SortByEndDate:     {"end_date", "::date"},
+	types.SortByServiceName: {"service_name", "::text"},
+	types.SortByUpdatedAt:   {"updated_at", "::timestamptz"},
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// queryArgs collects positional query arguments.
+type queryArgs []any
+
+// add appends v and returns its placeholder.
+func (a *queryArgs) add(v any) string {
+	*a = append(*a, v)
+	return fmt.Sprintf("$%d", len(*a))
+}
+
+// filterConditions translates the filters of q, without its cursor, into SQL
+// conditions scoped to userID.
+func filterConditions(userID string, q *types.SubscriptionListQuery, args *queryArgs) ([]string, error) {
+	conds := []string{"user_id = " + args.add(userID)}
+	if q.ServiceNamePrefix != "" {
+		conds = append(conds, "lower(service_name) LIKE lower("+args.add(likeEscaper.Replace(q.ServiceNamePrefix)+"%")+")")
+	}
+	if q.ServiceNameContains != "" {
+		conds = append(conds, "lower(service_name) LIKE lower("+args.add("%"+likeEscaper.Replace(q.ServiceNameContains)+"%")+")")
+	}
+	if q.MinPrice != nil {
+		conds = append(conds, "price >= "+args.add(*q.MinPrice))
+	}
+	if q.MaxPrice != nil {
+		conds = append(conds, "price <= "+args.add(*q.MaxPrice))
+	}
+	if q.ActiveOn != nil {
+		day := args.add(*q.ActiveOn) + "::date"
+		conds = append(conds, fmt.Sprintf("start_date <= %s AND (end_date IS NULL OR end_date >= %s)", day, day))
+	}
+	if q.UpdatedSince != nil {
+		conds = append(conds, "updated_at >= "+args.add(*q.UpdatedSince))
+	}
+	switch q.Deleted {
+	case types.DeletedExclude:
+		conds = append(conds, "deleted_at IS NULL")
+	case types.DeletedInclude:
+	case types.DeletedOnly:
+		conds = append(conds, "deleted_at IS NOT NULL")
+	default:
+		return nil, fmt.Errorf("unsupported deleted selection %q", q.Deleted)
+	}
+	switch q.Status {
+	case "":
+	case types.StatusActive:
+		conds = append(conds, "start_date <= CURRENT_DATE AND (end_date IS NULL OR end_date >= CURRENT_DATE)")
+	case types.StatusEnded:
+		conds = append(conds, "end_date < CURRENT_DATE")
+	default:
+		return nil, fmt.Errorf("unsupported status %q", q.Status)
+	}
+	return conds, nil
+}
+
+// List returns the user's subscriptions matching q, ordered by q.SortBy and
+// then id, starting after q.After. Pages are selected with keyset conditions
+// on (sort column, id), so they stay stable while rows are added or removed.
+func (r *postgresRepository) List(ctx context.Context, userID string, q *types.SubscriptionListQuery) (_ []types.UserSubscription, err error) {
 	if err := r.checkDB(); err != nil {
 		return nil, err
 	}
-	baseQuery := `SELECT id, service_name, price, user_id, to_char(start_date, 'MM-YYYY'), to_char(end_date, 'MM-YYYY')
-				  FROM subscriptions WHERE user_id = $1`
-	args := []interface{}{userID}
+	ctx, done := r.operation(ctx, "List", &err)
+	defer done()
+	if q == nil {
+		q = &types.SubscriptionListQuery{}
+	}
+	sortBy := q.SortBy
+	if sortBy == "" {
+		sortBy = types.SortByID
+	}
+	sort, ok := sortColumns[sortBy]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sort column %q", sortBy)
+	}
 
-	if afterID != nil {
-		baseQuery += " AND id > $2"
-		args = append(args, *afterID)
+	var args queryArgs
+	conds, err := filterConditions(userID, q, &args)
+	if err != nil {
+		return nil, err
 	}
-	baseQuery += " ORDER BY id ASC"
-	if limit > 0 {
-		baseQuery += fmt.Sprintf(" LIMIT $%d", len(args)+1)
-		args = append(args, limit)
+
+	cmp, dir := ">", "ASC"
+	if q.Descending {
+		cmp, dir = "<", "DESC"
+	}
+	if c := q.After; c != nil {
+		switch {
+		case sort.column == "id":
+			conds = append(conds, "id "+cmp+" "+args.add(c.ID))
+		case c.Value == nil:
+			// Only end_date is nullable. NULLs sort last ascending and first
+			// descending, matching PostgreSQL defaults and the index order.
+			if q.Descending {
+				conds = append(conds, "(end_date IS NOT NULL OR id < "+args.add(c.ID)+")")
+			} else {
+				conds = append(conds, "end_date IS NULL AND id > "+args.add(c.ID))
+			}
+		default:
+			cond := fmt.Sprintf("(%s, id) %s (%s%s, %s)", sort.column, cmp, args.add(*c.Value), sort.cast, args.add(c.ID))
+			if sort.column == "end_date" && !q.Descending {
+				cond = "(" + cond + " OR end_date IS NULL)"
+			}
+			conds = append(conds, cond)
+		}
+	}
+
+	query := `SELECT ` + subscriptionColumns + ` FROM subscriptions WHERE ` + strings.Join(conds, " AND ")
+	if sort.column == "id" {
+		query += " ORDER BY id " + dir
+	} else {
+		query += fmt.Sprintf(" ORDER BY %s %s, id %s", sort.column, dir, dir)
+	}
+	if q.Limit > 0 {
+		query += " LIMIT " + args.add(q.Limit)
 	}
 
-	rows, err := r.db.Query(baseQuery, args...)
+	rows, err := r.conn().QueryContext(ctx, query, args...)
 	if err != nil {
 		log.WithError(err).Error("Failed to list subscriptions")
 		return nil, err
@@ -112,7 +354,7 @@ func (r *postgresRepository) List(userID string, afterID *int64, limit int) ([]t
 	var subs []types.UserSubscription
 	for rows.Next() {
 		var s types.UserSubscription
-		if err := rows.Scan(&s.Id, &s.ServiceName, &s.Price, &s.UserId, &s.StartDate, &s.EndDate); err != nil {
+		if err := scanSubscription(rows, &s); err != nil {
 			log.WithError(err).Error("Failed to scan subscription row")
 			return nil, err
 		}
@@ -121,32 +363,99 @@ func (r *postgresRepository) List(userID string, afterID *int64, limit int) ([]t
 	return subs, rows.Err()
 }
 
-func (r *postgresRepository) Sum(data *types.UserSumSubscriptionRequest) (int64, error) {
+// Count returns how many of the user's subscriptions match the filters of q,
+// ignoring its cursor and limit.
+func (r *postgresRepository) Count(ctx context.Context, userID string, q *types.SubscriptionListQuery) (_ int64, err error) {
+	if err := r.checkDB(); err != nil {
+		return 0, err
+	}
+	ctx, done := r.operation(ctx, "Count", &err)
+	defer done()
+	if q == nil {
+		q = &types.SubscriptionListQuery{}
+	}
+	var args queryArgs
+	conds, err := filterConditions(userID, q, &args)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	query := `SELECT COUNT(*) FROM subscriptions WHERE ` + strings.Join(conds, " AND ")
+	if err := r.conn().QueryRowContext(ctx, query, args...).Scan(&total); err != nil {
+		log.WithError(err).Error("Failed to count subscriptions")
+		return 0, err
+	}
+	return total, nil
+}
+
+// Sum returns the total charged to the user between the requested dates,
+// converted into data.TargetCurrency. Every charge event produced by a
+// subscription's billing period and anchor that falls inside both the
+// subscription and the requested range is counted once, and converted using
+// the latest exchange rate loaded for the charge's month or earlier; a missing
+// rate yields ErrExchangeRateNotFound.
+func (r *postgresRepository) Sum(ctx context.Context, data *types.UserSumSubscriptionRequest) (_ int64, err error) {
 	if err := r.checkDB(); err != nil {
 		return 0, err
 	}
+	ctx, done := r.operation(ctx, "Sum", &err)
+	defer done()
 	if data == nil {
 		return 0, fmt.Errorf("data cannot be nil")
 	}
 	query := `WITH params AS (
-				  SELECT to_date($2, 'MM-YYYY') AS req_start, to_date($3, 'MM-YYYY') AS req_end
+				  SELECT $2::date AS req_start, $3::date AS req_end
               ), selected AS (
-				  SELECT s.price,
+				  SELECT s.price, s.currency, s.billing_anchor AS anchor,
+					     CASE s.billing_period
+							 WHEN 'weekly' THEN 0
+							 WHEN 'monthly' THEN 1
+							 WHEN 'quarterly' THEN 3
+							 WHEN 'yearly' THEN 12
+							 ELSE s.billing_interval_months
+					     END AS step_months,
+					     CASE WHEN s.billing_period = 'weekly' THEN 7 ELSE 0 END AS step_days,
 					     GREATEST(s.start_date, p.req_start) AS os,
 					     LEAST(COALESCE(s.end_date, p.req_end), p.req_end) AS oe
 				  FROM subscriptions s, params p
-				  WHERE s.user_id = $1
+				  WHERE s.user_id = $1 AND s.deleted_at IS NULL
 					AND s.start_date <= p.req_end
 					AND (s.end_date IS NULL OR s.end_date >= p.req_start)
-              ), normalized AS (
-				  SELECT price, os, oe FROM selected WHERE os <= oe
+              ), charges AS (
+				  SELECT n.price, n.currency, date_trunc('month', e.charged_on)::date AS month
+				  FROM selected n
+				  CROSS JOIN LATERAL (
+					  SELECT (n.anchor + make_interval(months => k * n.step_months, days => k * n.step_days))::date AS charged_on
+					  FROM generate_series(0, CASE
+						  WHEN n.step_days > 0 THEN (n.oe - n.anchor) / n.step_days
+						  ELSE (DATE_PART('year', age(n.oe, n.anchor))::int * 12 + DATE_PART('month', age(n.oe, n.anchor))::int) / n.step_months + 1
+					  END) k
+				  ) e
+				  WHERE e.charged_on BETWEEN n.os AND n.oe
+              ), converted AS (
+				  SELECT c.currency,
+					     CASE WHEN c.currency = $4 THEN c.price::numeric ELSE c.price * r.rate END AS amount
+				  FROM charges c
+				  LEFT JOIN LATERAL (
+					  SELECT CASE WHEN er.base_currency = c.currency THEN er.rate ELSE 1 / er.rate END AS rate
+					  FROM exchange_rates er
+					  WHERE ((er.base_currency = c.currency AND er.quote_currency = $4)
+						  OR (er.base_currency = $4 AND er.quote_currency = c.currency))
+						AND er.month <= c.month
+					  ORDER BY er.month DESC, (er.base_currency = c.currency) DESC
+					  LIMIT 1
+				  ) r ON c.currency <> $4
               )
-              SELECT COALESCE(SUM(price * ((DATE_PART('year', age(oe, os))::int * 12) + DATE_PART('month', age(oe, os))::int + 1)), 0)
-              FROM normalized`
+              SELECT COALESCE(ROUND(SUM(amount)), 0)::bigint, MIN(currency) FILTER (WHERE amount IS NULL)
+              FROM converted`
 	var total int64
-	if err := r.db.QueryRow(query, data.UserId, data.StartDate, data.EndDate).Scan(&total); err != nil {
+	var missing sql.NullString
+	if err := r.conn().QueryRowContext(ctx, query, data.UserId, data.StartDate, data.EndDate, data.TargetCurrency).Scan(&total, &missing); err != nil {
 		log.WithError(err).Error("Failed to calculate subscription sum")
 		return 0, err
 	}
+	if missing.Valid {
+		return 0, fmt.Errorf("%w: %s to %s", ErrExchangeRateNotFound, missing.String, data.TargetCurrency)
+	}
 	return total, nil
 }
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"crudl_service/src/types"
 	"testing"
 )
@@ -12,7 +13,7 @@ func newNilRepo() *postgresRepository {
 func TestCreate_NilDB(t *testing.T) {
 	r := newNilRepo()
 	startDate := "01-2023"
-	_, err := r.Create(&types.UserSubscription{
+	_, err := r.Create(context.Background(), &types.UserSubscription{
 		ServiceName: "Netflix", Price: 999, UserId: "user123", StartDate: &startDate,
 	})
 	if err == nil {
@@ -22,7 +23,7 @@ func TestCreate_NilDB(t *testing.T) {
 
 func TestGet_NilDB(t *testing.T) {
 	r := newNilRepo()
-	result, err := r.Get(1)
+	result, err := r.Get(context.Background(), 1)
 	if err == nil {
 		t.Error("Expected error with nil db")
 	}
@@ -34,7 +35,7 @@ func TestGet_NilDB(t *testing.T) {
 func TestUpdate_NilDB(t *testing.T) {
 	r := newNilRepo()
 	startDate := "01-2023"
-	err := r.Update(&types.UserSubscription{
+	err := r.Update(context.Background(), &types.UserSubscription{
 		ServiceName: "Netflix", Price: 999, UserId: "user123", StartDate: &startDate,
 	})
 	if err == nil {
@@ -44,14 +45,14 @@ func TestUpdate_NilDB(t *testing.T) {
 
 func TestDelete_NilDB(t *testing.T) {
 	r := newNilRepo()
-	if err := r.Delete(1); err == nil {
+	if err := r.Delete(context.Background(), 1, "user-1"); err == nil {
 		t.Error("Expected error with nil db")
 	}
 }
 
 func TestList_NilDB(t *testing.T) {
 	r := newNilRepo()
-	result, err := r.List("user123", nil, 10)
+	result, err := r.List(context.Background(), "user123", &types.SubscriptionListQuery{Limit: 10})
 	if err == nil {
 		t.Error("Expected error with nil db")
 	}
@@ -62,7 +63,7 @@ func TestList_NilDB(t *testing.T) {
 
 func TestSum_NilDB(t *testing.T) {
 	r := newNilRepo()
-	result, err := r.Sum(&types.UserSumSubscriptionRequest{
+	result, err := r.Sum(context.Background(), &types.UserSumSubscriptionRequest{
 		UserId: "user123", StartDate: "01-2023", EndDate: "12-2023",
 	})
 	if err == nil {
@@ -80,8 +81,19 @@ func TestSum_NilData(t *testing.T) {
 		}
 	}()
 	r := newNilRepo()
-	_, err := r.Sum(nil)
+	_, err := r.Sum(context.Background(), nil)
 	if err == nil {
 		t.Error("Expected error for nil data")
 	}
 }
+
+func TestCount_NilDB(t *testing.T) {
+	r := newNilRepo()
+	result, err := r.Count(context.Background(), "user123", &types.SubscriptionListQuery{})
+	if err == nil {
+		t.Error("Expected error with nil db")
+	}
+	if result != 0 {
+		t.Errorf("Expected 0, got %d", result)
+	}
+}
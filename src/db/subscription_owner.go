@@ -0,0 +1,133 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Actions applied to the subscriptions of a user deleted directly in the
+// database. The API deletes or anonymizes subscriptions itself before
+// deleting a user, so they only matter for manual deletes.
+const (
+	OnUserDeleteCascade  = "cascade"
+	OnUserDeleteSetNull  = "set_null"
+	OnUserDeleteRestrict = "restrict"
+)
+
+// subscriptionOwnerFK is the foreign key from subscriptions to users.
+const subscriptionOwnerFK = "subscriptions_user_id_fkey"
+
+// onDeleteCode returns the pg_constraint confdeltype code of action.
+func onDeleteCode(action string) (string, error) {
+	switch action {
+	case OnUserDeleteCascade:
+		return "c", nil
+	case OnUserDeleteSetNull:
+		return "n", nil
+	case OnUserDeleteRestrict:
+		return "r", nil
+	}
+	return "", fmt.Errorf("unknown ON DELETE action %q", action)
+}
+
+// migrationURL returns the connection URL for migrations, which passes action
+// on to migration 22 as the crudl.subscription_on_user_delete setting.
+func migrationURL(connURL, action string) string {
+	return connURL + "&options=" + url.QueryEscape("-c crudl.subscription_on_user_delete="+action)
+}
+
+// maxReportedOrphans bounds the user IDs listed in an OrphanReport.
+const maxReportedOrphans = 20
+
+// uuidRegexp matches the canonical textual form of user IDs in SQL.
+const uuidRegexp = `'^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$'`
+
+// OrphanReport describes subscriptions whose user_id names no existing
+// user. Migration 17 moves them to orphaned_subscriptions.
+type OrphanReport struct {
+	// InvalidUserIDs counts rows whose user_id is not a UUID at all,
+	// UnknownUsers rows with a well-formed ID of a user that does not exist.
+	InvalidUserIDs int64
+	UnknownUsers   int64
+	// UserIDs lists some of the offending user IDs.
+	UserIDs []string
+}
+
+// Total is the number of orphaned subscriptions.
+func (r *OrphanReport) Total() int64 {
+	return r.InvalidUserIDs + r.UnknownUsers
+}
+
+// ReportOrphanedSubscriptions counts the subscriptions that the foreign key
+// migration will quarantine. It returns nil when there is nothing to report
+// because the subscriptions or users table does not exist yet or the
+// user_id column is already a UUID.
+func ReportOrphanedSubscriptions(conn *sql.DB) (*OrphanReport, error) {
+	var dataType string
+	err := conn.QueryRow(
+		`SELECT c.data_type FROM information_schema.columns c
+		 WHERE c.table_schema = current_schema() AND c.table_name = 'subscriptions' AND c.column_name = 'user_id'
+		   AND to_regclass('users') IS NOT NULL`,
+	).Scan(&dataType)
+	if errors.Is(err, sql.ErrNoRows) || dataType == "uuid" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect subscriptions: %w", err)
+	}
+
+	const orphans = `FROM subscriptions s
+		WHERE s.user_id IS NOT NULL
+		  AND NOT EXISTS (SELECT 1 FROM users u WHERE u.id::text = lower(trim(s.user_id)))`
+	report := &OrphanReport{}
+	err = conn.QueryRow(
+		`SELECT COUNT(*) FILTER (WHERE lower(trim(s.user_id)) !~ `+uuidRegexp+`),
+		        COUNT(*) FILTER (WHERE lower(trim(s.user_id)) ~ `+uuidRegexp+`) `+orphans,
+	).Scan(&report.InvalidUserIDs, &report.UnknownUsers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count orphaned subscriptions: %w", err)
+	}
+	if report.Total() == 0 {
+		return report, nil
+	}
+	rows, err := conn.Query(`SELECT DISTINCT s.user_id `+orphans+` ORDER BY s.user_id LIMIT $1`, maxReportedOrphans)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list orphaned subscriptions: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		report.UserIDs = append(report.UserIDs, userID)
+	}
+	return report, rows.Err()
+}
+
+// CheckSubscriptionOnUserDelete warns when the foreign key from subscriptions
+// to users does not apply action, one of the OnUserDelete constants. The
+// action is only set by migration 22, so changing it later takes a new
+// migration.
+func CheckSubscriptionOnUserDelete(conn *sql.DB, action string) error {
+	code, err := onDeleteCode(action)
+	if err != nil {
+		return err
+	}
+	var current string
+	err = conn.QueryRow(
+		`SELECT confdeltype FROM pg_constraint WHERE conname = $1 AND conrelid = 'subscriptions'::regclass`,
+		subscriptionOwnerFK,
+	).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", subscriptionOwnerFK, err)
+	}
+	if current != code {
+		log.WithField("on_delete", action).Warn("ON DELETE action of subscriptions differs from DB_SUBSCRIPTION_ON_USER_DELETE; it is only applied by migration 22")
+	}
+	return nil
+}
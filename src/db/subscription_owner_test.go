@@ -0,0 +1,44 @@
+package db
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestOnDeleteCode(t *testing.T) {
+	tests := []struct {
+		action, code string
+	}{
+		{OnUserDeleteCascade, "c"},
+		{OnUserDeleteSetNull, "n"},
+		{OnUserDeleteRestrict, "r"},
+	}
+
+	for _, tt := range tests {
+		code, err := onDeleteCode(tt.action)
+		if err != nil || code != tt.code {
+			t.Errorf("onDeleteCode(%q): expected %s, got %s (%v)", tt.action, tt.code, code, err)
+		}
+	}
+	if _, err := onDeleteCode("drop"); err == nil {
+		t.Error("Expected an error for an unknown action")
+	}
+}
+
+func TestMigrationURL(t *testing.T) {
+	got := migrationURL("postgres://u:p@db:5432/app?sslmode=disable", OnUserDeleteSetNull)
+	parsed, err := url.Parse(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Query().Get("sslmode") != "disable" || parsed.Query().Get("options") != "-c crudl.subscription_on_user_delete=set_null" {
+		t.Errorf("Expected the action to be passed as a setting, got %s", got)
+	}
+}
+
+func TestOrphanReport_Total(t *testing.T) {
+	report := &OrphanReport{InvalidUserIDs: 2, UnknownUsers: 3}
+	if report.Total() != 5 {
+		t.Errorf("Expected 5 orphans, got %d", report.Total())
+	}
+}
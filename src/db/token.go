@@ -0,0 +1,208 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrRefreshTokenReused is returned when an already rotated or revoked refresh
+// token is presented again. Its whole family has been revoked by then.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+// CreateRefreshToken stores the hash of a refresh token starting a new token
+// family for userID. Expired tokens of the user are dropped on the way.
+func (r *postgresRepository) CreateRefreshToken(ctx context.Context, userID, tokenHash string, expiresAt time.Time) (err error) {
+	if err := r.checkDB(); err != nil {
+		return err
+	}
+	ctx, done := r.operation(ctx, "CreateRefreshToken", &err)
+	defer done()
+	if _, err := r.conn().ExecContext(ctx,
+		`DELETE FROM refresh_tokens WHERE user_id = $1 AND expires_at < NOW()`, userID,
+	); err != nil {
+		log.WithError(err).Warn("Failed to drop expired refresh tokens")
+	}
+	_, err = r.conn().ExecContext(ctx,
+		`INSERT INTO refresh_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`,
+		userID, tokenHash, expiresAt,
+	)
+	if err != nil {
+		log.WithError(err).Error("Failed to store refresh token")
+	}
+	return err
+}
+
+// RotateRefreshToken marks the token with oldHash used and stores newHash as
+// its successor in the same family, returning the owner's user ID. An unknown
+// or expired token yields ErrNotFound; a token that was already used or
+// revoked revokes its family and yields ErrRefreshTokenReused.
+func (r *postgresRepository) RotateRefreshToken(ctx context.Context, oldHash, newHash string, expiresAt time.Time) (_ string, err error) {
+	if err := r.checkDB(); err != nil {
+		return "", err
+	}
+	ctx, done := r.operation(ctx, "RotateRefreshToken", &err)
+	defer done()
+	tx, err := r.begin(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userID, familyID string
+	err = tx.QueryRowContext(ctx,
+		`UPDATE refresh_tokens SET used_at = NOW()
+		 WHERE token_hash = $1 AND used_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()
+		 RETURNING user_id, family_id`, oldHash,
+	).Scan(&userID, &familyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", r.revokeReusedFamily(ctx, tx, oldHash)
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to rotate refresh token")
+		return "", err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at) VALUES ($1, $2, $3, $4)`,
+		userID, familyID, newHash, expiresAt,
+	); err != nil {
+		log.WithError(err).Error("Failed to store rotated refresh token")
+		return "", err
+	}
+	return userID, tx.Commit()
+}
+
+// revokeReusedFamily handles a refresh token that could not be rotated: if it
+// exists and was used or revoked before, its family is revoked and committed.
+func (r *postgresRepository) revokeReusedFamily(ctx context.Context, tx transaction, tokenHash string) error {
+	var familyID string
+	err := tx.QueryRowContext(ctx,
+		`SELECT family_id FROM refresh_tokens
+		 WHERE token_hash = $1 AND (used_at IS NOT NULL OR revoked_at IS NOT NULL)`, tokenHash,
+	).Scan(&familyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL`, familyID,
+	); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.WithField("family_id", familyID).Warn("Refresh token reused, token family revoked")
+	return ErrRefreshTokenReused
+}
+
+// RevokeRefreshToken revokes the family of the given refresh token if it
+// belongs to userID. Unknown tokens are ignored.
+func (r *postgresRepository) RevokeRefreshToken(ctx context.Context, userID, tokenHash string) (err error) {
+	if err := r.checkDB(); err != nil {
+		return err
+	}
+	ctx, done := r.operation(ctx, "RevokeRefreshToken", &err)
+	defer done()
+	_, err = r.conn().ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = NOW()
+		 WHERE revoked_at IS NULL AND family_id IN (
+		     SELECT family_id FROM refresh_tokens WHERE token_hash = $1 AND user_id = $2
+		 )`, tokenHash, userID,
+	)
+	if err != nil {
+		log.WithError(err).Error("Failed to revoke refresh token")
+	}
+	return err
+}
+
+// RevokeAccessToken puts the access token jti on the revocation list until
+// expiresAt, and drops entries of tokens that have expired since.
+func (r *postgresRepository) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) (err error) {
+	if err := r.checkDB(); err != nil {
+		return err
+	}
+	ctx, done := r.operation(ctx, "RevokeAccessToken", &err)
+	defer done()
+	if _, err := r.conn().ExecContext(ctx,
+		`INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING`,
+		jti, expiresAt,
+	); err != nil {
+		log.WithError(err).Error("Failed to revoke access token")
+		return err
+	}
+	if _, err := r.conn().ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expires_at < NOW()`); err != nil {
+		log.WithError(err).Warn("Failed to drop expired revoked tokens")
+	}
+	return nil
+}
+
+// RevokeUserTokens revokes every refresh token of userID and rejects all of
+// its access tokens issued so far. Access tokens carry their issue time with
+// microsecond precision, like tokens_valid_after, so a token issued in the
+// same second as the call is still rejected.
+func (r *postgresRepository) RevokeUserTokens(ctx context.Context, userID string) (err error) {
+	if err := r.checkDB(); err != nil {
+		return err
+	}
+	ctx, done := r.operation(ctx, "RevokeUserTokens", &err)
+	defer done()
+	tx, err := r.begin(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, userID,
+	); err != nil {
+		log.WithError(err).Error("Failed to revoke refresh tokens")
+		return err
+	}
+	result, err := tx.ExecContext(ctx,
+		`UPDATE users SET tokens_valid_after = clock_timestamp() WHERE id = $1`, userID,
+	)
+	if err != nil {
+		log.WithError(err).Error("Failed to revoke access tokens")
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+// AuthenticateAccessToken returns the current role of userID for its access
+// token jti issued at issuedAt. It yields ErrNotFound when the token was
+// revoked, was issued no later than the user's last logout from all
+// sessions, or the user no longer exists or is disabled.
+func (r *postgresRepository) AuthenticateAccessToken(ctx context.Context, jti, userID string, issuedAt time.Time) (_ string, err error) {
+	if err := r.checkDB(); err != nil {
+		return "", err
+	}
+	ctx, done := r.operation(ctx, "AuthenticateAccessToken", &err)
+	defer done()
+	var role string
+	err = r.conn().QueryRowContext(ctx,
+		`SELECT role FROM users
+		 WHERE id = $2 AND disabled_at IS NULL
+		   AND (tokens_valid_after IS NULL OR tokens_valid_after < $3)
+		   AND NOT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)`,
+		jti, userID, issuedAt,
+	).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to authenticate access token")
+		return "", err
+	}
+	return role, nil
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCreateRefreshToken_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if err := r.CreateRefreshToken(context.Background(), "user", "hash", time.Now()); err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
+
+func TestRotateRefreshToken_NilDB(t *testing.T) {
+	r := newNilRepo()
+	userID, err := r.RotateRefreshToken(context.Background(), "old", "new", time.Now())
+	if err == nil {
+		t.Error("Expected error with nil db")
+	}
+	if userID != "" {
+		t.Error("Expected empty user ID")
+	}
+}
+
+func TestRevokeRefreshToken_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if err := r.RevokeRefreshToken(context.Background(), "user", "hash"); err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
+
+func TestRevokeAccessToken_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if err := r.RevokeAccessToken(context.Background(), "jti", time.Now()); err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
+
+func TestRevokeUserTokens_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if err := r.RevokeUserTokens(context.Background(), "user"); err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
+
+func TestAuthenticateAccessToken_NilDB(t *testing.T) {
+	r := newNilRepo()
+	if _, err := r.AuthenticateAccessToken(context.Background(), "jti", "user", time.Now()); err == nil {
+		t.Error("Expected error with nil db")
+	}
+}
@@ -0,0 +1,178 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrTxConflict is matched by errors of transactions that kept failing
+// because of concurrent transactions, after WithTx gave up retrying them.
+var ErrTxConflict = errors.New("transaction conflicts with concurrent transactions")
+
+const (
+	// txAttempts is how often WithTx runs a transaction that fails with a
+	// serialization failure or deadlock.
+	txAttempts = 3
+	// txRetryDelay is the base of the jittered delay between attempts.
+	txRetryDelay = 20 * time.Millisecond
+)
+
+// querier runs statements, either directly on the pool or inside a
+// transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// transaction is a querier whose statements take effect together on Commit.
+type transaction interface {
+	querier
+	Commit() error
+	Rollback() error
+}
+
+// ParseIsolation maps the isolation level names accepted by DB_TX_ISOLATION
+// to their sql.IsolationLevel.
+func ParseIsolation(name string) (sql.IsolationLevel, error) {
+	switch name {
+	case "read_committed":
+		return sql.LevelReadCommitted, nil
+	case "repeatable_read":
+		return sql.LevelRepeatableRead, nil
+	case "serializable":
+		return sql.LevelSerializable, nil
+	}
+	return 0, fmt.Errorf("unknown transaction isolation level %q", name)
+}
+
+// TxOption adjusts a transaction started by WithTx.
+type TxOption func(*sql.TxOptions)
+
+// WithIsolation runs the transaction at level instead of the repository's
+// default isolation level.
+func WithIsolation(level sql.IsolationLevel) TxOption {
+	return func(opts *sql.TxOptions) { opts.Isolation = level }
+}
+
+// ReadOnly starts a read-only transaction.
+func ReadOnly() TxOption {
+	return func(opts *sql.TxOptions) { opts.ReadOnly = true }
+}
+
+// conn returns where statements of r run: its transaction, if any, or the
+// pool.
+func (r *postgresRepository) conn() querier {
+	if r.tx != nil {
+		return r.tx
+	}
+	return r.db
+}
+
+// begin starts a transaction for a single repository method. Inside WithTx
+// it opens a savepoint of the surrounding transaction instead, so that the
+// method's statements still succeed or fail together; opts then has no
+// effect.
+func (r *postgresRepository) begin(ctx context.Context, opts *sql.TxOptions) (transaction, error) {
+	if r.tx == nil {
+		return r.db.BeginTx(ctx, opts)
+	}
+	if _, err := r.tx.ExecContext(ctx, `SAVEPOINT repository_operation`); err != nil {
+		return nil, err
+	}
+	return &savepoint{Tx: r.tx, ctx: ctx}, nil
+}
+
+// savepoint is a transaction nested in a WithTx transaction.
+type savepoint struct {
+	*sql.Tx
+	ctx  context.Context
+	done bool
+}
+
+func (s *savepoint) Commit() error {
+	return s.end(`RELEASE SAVEPOINT repository_operation`)
+}
+
+func (s *savepoint) Rollback() error {
+	return s.end(`ROLLBACK TO SAVEPOINT repository_operation`)
+}
+
+func (s *savepoint) end(statement string) error {
+	if s.done {
+		return sql.ErrTxDone
+	}
+	s.done = true
+	_, err := s.Tx.ExecContext(s.ctx, statement)
+	return err
+}
+
+// WithTx runs fn in a transaction and commits it when fn returns nil. The
+// Repository passed to fn runs every operation in that transaction; calling
+// WithTx on it joins the transaction instead of starting another one.
+//
+// Transactions use the repository's default isolation level unless opts say
+// otherwise. Transactions failing with a serialization failure or deadlock are
+// rolled back and run again, so fn must not have side effects outside the
+// transaction. Once the attempts are used up the error matches
+// ErrTxConflict.
+func (r *postgresRepository) WithTx(ctx context.Context, fn func(Repository) error, opts ...TxOption) error {
+	if err := r.checkDB(); err != nil {
+		return err
+	}
+	if r.tx != nil {
+		return fn(r)
+	}
+	txOpts := &sql.TxOptions{Isolation: r.isolation}
+	for _, opt := range opts {
+		opt(txOpts)
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = r.runTx(ctx, txOpts, fn); err == nil || !isRetryable(err) {
+			return err
+		}
+		if attempt == txAttempts {
+			return fmt.Errorf("%w: %w", ErrTxConflict, err)
+		}
+		log.WithError(err).WithField("attempt", attempt).Debug("Retrying transaction")
+		delay := time.Duration(attempt) * txRetryDelay
+		delay += rand.N(delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return contextError(ctx, err)
+		}
+	}
+}
+
+func (r *postgresRepository) runTx(ctx context.Context, opts *sql.TxOptions, fn func(Repository) error) error {
+	tx, err := r.db.BeginTx(ctx, opts)
+	if err != nil {
+		return contextError(ctx, fmt.Errorf("failed to begin transaction: %w", err))
+	}
+	defer tx.Rollback()
+
+	if err := fn(&postgresRepository{db: r.db, tx: tx, timeouts: r.timeouts, isolation: r.isolation}); err != nil {
+		return err
+	}
+	return contextError(ctx, tx.Commit())
+}
+
+// isRetryable reports whether err is a serialization failure or deadlock,
+// after which the whole transaction may succeed when run again.
+func isRetryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == pqSerializationFailure || pqErr.Code == pqDeadlockDetected
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestWithTx_NilDB(t *testing.T) {
+	r := newNilRepo()
+	called := false
+	err := r.WithTx(context.Background(), func(Repository) error {
+		called = true
+		return nil
+	})
+	if err == nil {
+		t.Error("Expected error with nil db")
+	}
+	if called {
+		t.Error("Expected the callback not to run")
+	}
+}
+
+func TestParseIsolation(t *testing.T) {
+	tests := map[string]sql.IsolationLevel{
+		"read_committed":  sql.LevelReadCommitted,
+		"repeatable_read": sql.LevelRepeatableRead,
+		"serializable":    sql.LevelSerializable,
+	}
+	for name, want := range tests {
+		if got, err := ParseIsolation(name); err != nil || got != want {
+			t.Errorf("ParseIsolation(%q) = %v, %v; expected %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseIsolation("snapshot"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+}
+
+func TestTxOptions(t *testing.T) {
+	opts := &sql.TxOptions{Isolation: sql.LevelSerializable}
+	WithIsolation(sql.LevelReadCommitted)(opts)
+	ReadOnly()(opts)
+	if opts.Isolation != sql.LevelReadCommitted || !opts.ReadOnly {
+		t.Errorf("Expected a read-only read committed transaction, got %+v", opts)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"Serialization failure", &pq.Error{Code: pqSerializationFailure}, true},
+		{"Deadlock", fmt.Errorf("%w: %w", ErrTimeout, &pq.Error{Code: pqDeadlockDetected}), true},
+		{"Unique violation", &pq.Error{Code: pqUniqueViolation}, false},
+		{"Not found", ErrNotFound, false},
+		{"Other", errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+// Package notify delivers messages, such as password reset links, to users.
+package notify
+
+import (
+	"crudl_service/src/config"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Message is addressed to a user by username, the only contact detail the
+// service stores.
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Notifier delivers messages. Implementations must be safe for concurrent
+// use.
+type Notifier interface {
+	Notify(msg Message) error
+}
+
+// New returns the notifier selected by cfg.
+func New(cfg *config.NotifyConfig) (Notifier, error) {
+	switch cfg.Sink {
+	case "log":
+		return LogNotifier{}, nil
+	case "file":
+		return NewFileNotifier(cfg.File), nil
+	default:
+		return nil, fmt.Errorf("unknown notification sink %q", cfg.Sink)
+	}
+}
+
+// LogNotifier writes messages to the application log. It is meant for
+// development, as messages may carry secrets such as reset tokens.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(msg Message) error {
+	log.WithFields(log.Fields{"to": msg.To, "subject": msg.Subject}).Info(msg.Body)
+	return nil
+}
+
+// FileNotifier appends messages as JSON lines to a file.
+type FileNotifier struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+func (n *FileNotifier) Notify(msg Message) error {
+	line, err := json.Marshal(struct {
+		Message
+		SentAt time.Time `json:"sent_at"`
+	}{msg, time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	f, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,43 @@
+package notify
+
+import (
+	"crudl_service/src/config"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	if n, err := New(&config.NotifyConfig{Sink: "log"}); err != nil || n == nil {
+		t.Errorf("Expected a log notifier, got %v", err)
+	}
+	if _, err := New(&config.NotifyConfig{Sink: "smtp"}); err == nil {
+		t.Error("Expected an error for an unknown sink")
+	}
+}
+
+func TestFileNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.jsonl")
+	n := NewFileNotifier(path)
+
+	for _, to := range []string{"alice", "bob"} {
+		if err := n.Notify(Message{To: to, Subject: "Password reset", Body: "token"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(lines))
+	}
+	var msg Message
+	if err := json.Unmarshal([]byte(lines[1]), &msg); err != nil || msg.To != "bob" || msg.Body != "token" {
+		t.Errorf("Expected bob's message, got %+v (%v)", msg, err)
+	}
+}
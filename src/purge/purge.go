@@ -0,0 +1,54 @@
+// Package purge permanently removes subscriptions that stayed in the trash
+// longer than the retention period.
+package purge
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// BatchSize bounds how many subscriptions a single statement removes, so
+// that a large backlog does not hold locks for long.
+const BatchSize = 1000
+
+// Repository removes trashed subscriptions, see db.SubscriptionRepository.
+type Repository interface {
+	PurgeDeleted(ctx context.Context, before time.Time, limit int) (int64, error)
+}
+
+// Once removes every subscription moved to the trash before now minus
+// retention and returns how many it removed.
+func Once(ctx context.Context, repo Repository, now time.Time, retention time.Duration) (int64, error) {
+	before := now.Add(-retention)
+	var total int64
+	for {
+		n, err := repo.PurgeDeleted(ctx, before, BatchSize)
+		total += n
+		if err != nil || n < BatchSize {
+			return total, err
+		}
+	}
+}
+
+// Run purges the trash right away and then every interval until ctx is
+// done. Failures are logged and retried at the next interval.
+func Run(ctx context.Context, repo Repository, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := Once(ctx, repo, time.Now(), retention)
+		if err != nil && ctx.Err() == nil {
+			log.WithError(err).Error("Failed to purge deleted subscriptions")
+		}
+		if n > 0 {
+			log.WithField("count", n).Info("Purged deleted subscriptions")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeRepository holds the deletion times of trashed subscriptions.
+type fakeRepository struct {
+	deleted []time.Time
+	calls   int
+	err     error
+}
+
+func (f *fakeRepository) PurgeDeleted(ctx context.Context, before time.Time, limit int) (int64, error) {
+	f.calls++
+	if f.err != nil {
+		return 0, f.err
+	}
+	var kept []time.Time
+	var removed int64
+	for _, at := range f.deleted {
+		if at.Before(before) && removed < int64(limit) {
+			removed++
+			continue
+		}
+		kept = append(kept, at)
+	}
+	f.deleted = kept
+	return removed, nil
+}
+
+func TestOnce(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	repo := &fakeRepository{}
+	for i := 0; i < BatchSize+5; i++ {
+		repo.deleted = append(repo.deleted, now.Add(-48*time.Hour))
+	}
+	repo.deleted = append(repo.deleted, now.Add(-time.Hour))
+
+	n, err := Once(context.Background(), repo, now, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != BatchSize+5 || len(repo.deleted) != 1 {
+		t.Errorf("Expected %d purged and 1 kept, got %d purged and %d kept", BatchSize+5, n, len(repo.deleted))
+	}
+	if repo.calls != 2 {
+		t.Errorf("Expected 2 batches, got %d", repo.calls)
+	}
+}
+
+func TestOnce_Error(t *testing.T) {
+	repo := &fakeRepository{err: errors.New("connection refused")}
+	if _, err := Once(context.Background(), repo, time.Now(), time.Hour); err == nil {
+		t.Error("Expected the repository error")
+	}
+}
+
+func TestRun_StopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	repo := &fakeRepository{}
+	stopped := make(chan struct{})
+	go func() {
+		Run(ctx, repo, time.Hour, time.Hour)
+		close(stopped)
+	}()
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not stop after cancellation")
+	}
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	BillingWeekly    = "weekly"
+	BillingMonthly   = "monthly"
+	BillingQuarterly = "quarterly"
+	BillingYearly    = "yearly"
+	// BillingCustom charges every N months, N being the subscription's
+	// billing interval.
+	BillingCustom = "custom"
+)
+
+// MaxBillingIntervalMonths bounds custom billing intervals.
+const MaxBillingIntervalMonths = 120
+
+// NormalizeBillingPeriod lower-cases period, defaulting to monthly, and checks
+// that intervalMonths is set exactly when the period is custom.
+func NormalizeBillingPeriod(period string, intervalMonths *int) (string, error) {
+	period = strings.ToLower(strings.TrimSpace(period))
+	if period == "" {
+		period = BillingMonthly
+	}
+	switch period {
+	case BillingWeekly, BillingMonthly, BillingQuarterly, BillingYearly:
+		if intervalMonths != nil {
+			return "", fmt.Errorf("billing interval is only allowed for the %s period", BillingCustom)
+		}
+	case BillingCustom:
+		if intervalMonths == nil || *intervalMonths < 1 || *intervalMonths > MaxBillingIntervalMonths {
+			return "", fmt.Errorf("billing interval must be between 1 and %d months", MaxBillingIntervalMonths)
+		}
+	default:
+		return "", fmt.Errorf("unknown billing period %q", period)
+	}
+	return period, nil
+}
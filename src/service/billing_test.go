@@ -0,0 +1,36 @@
+package service
+
+import "testing"
+
+func TestNormalizeBillingPeriod(t *testing.T) {
+	zero, three, tooMany := 0, 3, MaxBillingIntervalMonths+1
+	tests := []struct {
+		name     string
+		period   string
+		interval *int
+		expected string
+		wantErr  bool
+	}{
+		{"Default", "", nil, BillingMonthly, false},
+		{"Case insensitive", "Yearly", nil, BillingYearly, false},
+		{"Weekly", "weekly", nil, BillingWeekly, false},
+		{"Custom", "custom", &three, BillingCustom, false},
+		{"Custom without interval", "custom", nil, "", true},
+		{"Custom zero interval", "custom", &zero, "", true},
+		{"Custom interval too large", "custom", &tooMany, "", true},
+		{"Interval on fixed period", "monthly", &three, "", true},
+		{"Unknown", "daily", nil, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			period, err := NormalizeBillingPeriod(tt.period, tt.interval)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if period != tt.expected {
+				t.Errorf("Expected period '%s', got '%s'", tt.expected, period)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package service
+
+import "testing"
+
+func TestNormalizeCurrency(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		ok       bool
+	}{
+		{"", DefaultCurrency, true},
+		{"usd", "USD", true},
+		{" EUR ", "EUR", true},
+		{"XYZ", "XYZ", false},
+		{"US", "US", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			code, ok := NormalizeCurrency(tt.input)
+			if code != tt.expected || ok != tt.ok {
+				t.Errorf("Expected (%s, %v), got (%s, %v)", tt.expected, tt.ok, code, ok)
+			}
+		})
+	}
+}
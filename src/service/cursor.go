@@ -0,0 +1,27 @@
+package service
+
+import (
+	"crudl_service/src/types"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// EncodeCursor turns a list cursor into an opaque URL-safe token.
+func EncodeCursor(c types.ListCursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// DecodeCursor parses a token produced by EncodeCursor.
+func DecodeCursor(token string) (*types.ListCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("malformed cursor: %w", err)
+	}
+	var c types.ListCursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, fmt.Errorf("malformed cursor: %w", err)
+	}
+	return &c, nil
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"crudl_service/src/types"
+	"testing"
+)
+
+func TestCursor_RoundTrip(t *testing.T) {
+	value := "2024-01-17"
+	original := types.ListCursor{SortBy: types.SortByStartDate, Descending: true, Value: &value, ID: 42}
+
+	decoded, err := DecodeCursor(EncodeCursor(original))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if decoded.SortBy != original.SortBy || decoded.Descending != original.Descending ||
+		decoded.ID != original.ID || decoded.Value == nil || *decoded.Value != value {
+		t.Errorf("Expected %+v, got %+v", original, decoded)
+	}
+}
+
+func TestCursor_NullValue(t *testing.T) {
+	decoded, err := DecodeCursor(EncodeCursor(types.ListCursor{SortBy: types.SortByEndDate, ID: 7}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if decoded.Value != nil {
+		t.Errorf("Expected nil value, got %q", *decoded.Value)
+	}
+}
+
+func TestDecodeCursor_Malformed(t *testing.T) {
+	for _, token := range []string{"not base64!", "bm90IGpzb24"} {
+		if _, err := DecodeCursor(token); err == nil {
+			t.Errorf("Expected error for %q", token)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// DateFormat is the ISO-8601 calendar date format used in responses.
+	DateFormat = "2006-01-02"
+	// MonthFormat is the legacy MM-YYYY format, still accepted on input.
+	MonthFormat = "01-2006"
+)
+
+// ParseDate parses an ISO-8601 date (optionally with a time part, which is
+// dropped) or a legacy MM-YYYY month, which resolves to its first day.
+func ParseDate(value string) (time.Time, error) {
+	if t, err := time.Parse(DateFormat, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+	}
+	if t, err := time.Parse(MonthFormat, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("incorrect date %q, expected YYYY-MM-DD or MM-YYYY", value)
+}
+
+// ParsePeriodEnd is like ParseDate, but a legacy MM-YYYY month resolves to its
+// last day so that an inclusive end given as a month covers the whole month.
+func ParsePeriodEnd(value string) (time.Time, error) {
+	if t, err := time.Parse(MonthFormat, value); err == nil {
+		return t.AddDate(0, 1, -1), nil
+	}
+	return ParseDate(value)
+}
+
+// NormalizeDate rewrites *value in DateFormat. parse selects whether a
+// MM-YYYY month stands for its first or last day. A nil value is left as is.
+func NormalizeDate(value *string, parse func(string) (time.Time, error)) error {
+	if value == nil {
+		return nil
+	}
+	t, err := parse(*value)
+	if err != nil {
+		return err
+	}
+	*value = t.Format(DateFormat)
+	return nil
+}
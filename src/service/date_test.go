@@ -0,0 +1,70 @@
+package service
+
+import "testing"
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		wantErr  bool
+	}{
+		{"2024-03-17", "2024-03-17", false},
+		{"2024-03-17T15:04:05+03:00", "2024-03-17", false},
+		{"03-2024", "2024-03-01", false},
+		{"17-03-2024", "", true},
+		{"2024-02-30", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseDate(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if err == nil && got.Format(DateFormat) != tt.expected {
+				t.Errorf("Expected '%s', got '%s'", tt.expected, got.Format(DateFormat))
+			}
+		})
+	}
+}
+
+func TestParsePeriodEnd(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"02-2024", "2024-02-29"},
+		{"12-2023", "2023-12-31"},
+		{"2024-02-10", "2024-02-10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParsePeriodEnd(tt.input)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got.Format(DateFormat) != tt.expected {
+				t.Errorf("Expected '%s', got '%s'", tt.expected, got.Format(DateFormat))
+			}
+		})
+	}
+}
+
+func TestNormalizeDate(t *testing.T) {
+	value := "05-2024"
+	if err := NormalizeDate(&value, ParseDate); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if value != "2024-05-01" {
+		t.Errorf("Expected '2024-05-01', got '%s'", value)
+	}
+	if err := NormalizeDate(nil, ParseDate); err != nil {
+		t.Errorf("Expected nil value to be accepted, got %v", err)
+	}
+	bad := "May 2024"
+	if err := NormalizeDate(&bad, ParseDate); err == nil {
+		t.Error("Expected error for unsupported format")
+	}
+}
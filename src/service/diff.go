@@ -0,0 +1,77 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+)
+
+// Changes lists the top-level members of a JSON document that a change
+// touched, with their values before and after it. A member missing on one
+// side is recorded as null there.
+type Changes struct {
+	Before map[string]any `json:"before,omitempty"`
+	After  map[string]any `json:"after,omitempty"`
+}
+
+// Diff compares the JSON forms of before and after and returns the Changes
+// between them as JSON. Either may be nil: creations only have an after and
+// removals only a before. Members named in ignore are left out. Diff returns
+// nil when nothing changed.
+func Diff(before, after any, ignore ...string) ([]byte, error) {
+	old, err := jsonMembers(before)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := jsonMembers(after)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range ignore {
+		delete(old, name)
+		delete(updated, name)
+	}
+
+	changes := Changes{Before: old, After: updated}
+	if old != nil && updated != nil {
+		changes = Changes{Before: map[string]any{}, After: map[string]any{}}
+		for name := range merge(old, updated) {
+			if !reflect.DeepEqual(old[name], updated[name]) {
+				changes.Before[name] = old[name]
+				changes.After[name] = updated[name]
+			}
+		}
+	}
+	if len(changes.Before) == 0 && len(changes.After) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(changes)
+}
+
+// jsonMembers returns the members of the JSON object v encodes to, or nil
+// when v encodes to null. Numbers are kept as written.
+func jsonMembers(v any) (map[string]any, error) {
+	document, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+	var members map[string]any
+	if err := decoder.Decode(&members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// merge returns the union of the member names of a and b.
+func merge(a, b map[string]any) map[string]struct{} {
+	names := make(map[string]struct{}, len(a)+len(b))
+	for name := range a {
+		names[name] = struct{}{}
+	}
+	for name := range b {
+		names[name] = struct{}{}
+	}
+	return names
+}
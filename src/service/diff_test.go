@@ -0,0 +1,39 @@
+package service
+
+import "testing"
+
+func TestDiff(t *testing.T) {
+	type item struct {
+		Name  string  `json:"name"`
+		Price int64   `json:"price"`
+		Note  *string `json:"note,omitempty"`
+		Stamp int     `json:"stamp"`
+	}
+	note := "gift"
+	tests := []struct {
+		name          string
+		before, after *item
+		want          string
+	}{
+		{"changed members", &item{Name: "a", Price: 100, Stamp: 1}, &item{Name: "a", Price: 9007199254740993, Stamp: 2},
+			`{"before":{"price":100},"after":{"price":9007199254740993}}`},
+		{"member added", &item{Name: "a"}, &item{Name: "a", Note: &note},
+			`{"before":{"note":null},"after":{"note":"gift"}}`},
+		{"created", nil, &item{Name: "a", Price: 1},
+			`{"after":{"name":"a","price":1}}`},
+		{"removed", &item{Name: "a", Price: 1}, nil,
+			`{"before":{"name":"a","price":1}}`},
+		{"unchanged", &item{Name: "a", Stamp: 1}, &item{Name: "a", Stamp: 2}, ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Diff(tt.before, tt.after, "stamp")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ETag returns the strong entity tag of a resource at version.
+func ETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// MatchesIfMatch reports whether the If-Match header value matches etag.
+// If-Match compares strongly, so weak tags never match; "*" matches any
+// existing resource.
+func MatchesIfMatch(header, etag string) bool {
+	return matchETag(header, etag, false)
+}
+
+// MatchesIfNoneMatch reports whether the If-None-Match header value matches
+// etag, comparing weakly as RFC 9110 requires.
+func MatchesIfNoneMatch(header, etag string) bool {
+	return matchETag(header, etag, true)
+}
+
+func matchETag(header, etag string, weak bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = tag[2:]
+		}
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package service
+
+import "testing"
+
+func TestETag(t *testing.T) {
+	if got := ETag(3); got != `"3"` {
+		t.Errorf(`Expected "3" quoted, got %s`, got)
+	}
+}
+
+func TestMatchETag(t *testing.T) {
+	tests := []struct {
+		header               string
+		ifMatch, ifNoneMatch bool
+	}{
+		{`"3"`, true, true},
+		{`"2", "3"`, true, true},
+		{`*`, true, true},
+		{`W/"3"`, false, true},
+		{`"2"`, false, false},
+		{`3`, false, false},
+		{``, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := MatchesIfMatch(tt.header, ETag(3)); got != tt.ifMatch {
+				t.Errorf("If-Match: expected %v, got %v", tt.ifMatch, got)
+			}
+			if got := MatchesIfNoneMatch(tt.header, ETag(3)); got != tt.ifNoneMatch {
+				t.Errorf("If-None-Match: expected %v, got %v", tt.ifNoneMatch, got)
+			}
+		})
+	}
+}
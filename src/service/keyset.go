@@ -0,0 +1,202 @@
+package service
+
+import (
+	"crudl_service/src/config"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minRSAKeyBits is the smallest RSA modulus accepted for token signing.
+const minRSAKeyBits = 2048
+
+// SigningKey is one JWT key. Keys without a private part only verify tokens
+// signed before they were rotated out.
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+// KeySet signs tokens with its active key and verifies tokens signed by any
+// of its keys, selected by the kid header. An optional legacy HS256 secret
+// verifies tokens without a kid; it is used for signing only when the set has
+// no asymmetric keys, and is never published.
+type KeySet struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+	secret []byte
+}
+
+// NewHMACKeySet returns a key set that signs and verifies with an HS256
+// secret only.
+func NewHMACKeySet(secret string) *KeySet {
+	return &KeySet{keys: map[string]*SigningKey{}, secret: []byte(secret)}
+}
+
+// LoadKeySet reads the PEM key files configured in cfg. A key file holds a
+// PKCS#8 or PKCS#1 private key, or, for keys that only verify, a PKIX public
+// key. RSA keys sign with RS256 and Ed25519 keys with EdDSA.
+func LoadKeySet(cfg *config.JWTConfig) (*KeySet, error) {
+	set := NewHMACKeySet(cfg.SecretKey)
+	for kid, path := range cfg.KeyFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT key %s: %w", kid, err)
+		}
+		key, err := ParseSigningKey(kid, data)
+		if err != nil {
+			return nil, err
+		}
+		set.keys[kid] = key
+	}
+	if cfg.ActiveKeyID != "" {
+		active, ok := set.keys[cfg.ActiveKeyID]
+		if !ok || active.Private == nil {
+			return nil, fmt.Errorf("active JWT key %s has no private key", cfg.ActiveKeyID)
+		}
+		set.active = active
+	}
+	return set, nil
+}
+
+// ParseSigningKey parses a single PEM encoded key named kid.
+func ParseSigningKey(kid string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("JWT key %s is not PEM encoded", kid)
+	}
+	var (
+		parsed any
+		err    error
+	)
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("JWT key %s has unsupported PEM type %q", kid, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT key %s: %w", kid, err)
+	}
+
+	key := &SigningKey{ID: kid}
+	if signer, ok := parsed.(crypto.Signer); ok {
+		key.Private = signer
+		parsed = signer.Public()
+	}
+	switch public := parsed.(type) {
+	case *rsa.PublicKey:
+		if public.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("JWT key %s is shorter than %d bits", kid, minRSAKeyBits)
+		}
+		key.Method, key.Public = jwt.SigningMethodRS256, public
+	case ed25519.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodEdDSA, public
+	default:
+		return nil, fmt.Errorf("JWT key %s must be an RSA or Ed25519 key", kid)
+	}
+	return key, nil
+}
+
+// Sign signs claims with the active key, naming it in the kid header.
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if s.active == nil {
+		if len(s.secret) == 0 {
+			return "", errors.New("no JWT signing key is configured")
+		}
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	}
+	token := jwt.NewWithClaims(s.active.Method, claims)
+	token.Header["kid"] = s.active.ID
+	return token.SignedString(s.active.Private)
+}
+
+// Keyfunc resolves the verification key of token for jwt.Parse. The token's
+// algorithm must be the one of its key, so that a public key can never be
+// used as an HMAC secret.
+func (s *KeySet) Keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if len(s.secret) == 0 || token.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("token has no key ID")
+		}
+		return s.secret, nil
+	}
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("key %q does not sign with %s", kid, token.Method.Alg())
+	}
+	return key.Public, nil
+}
+
+// ValidMethods lists the algorithms of the keys in the set.
+func (s *KeySet) ValidMethods() []string {
+	methods := map[string]struct{}{}
+	if len(s.secret) > 0 {
+		methods[jwt.SigningMethodHS256.Alg()] = struct{}{}
+	}
+	for _, key := range s.keys {
+		methods[key.Method.Alg()] = struct{}{}
+	}
+	algs := make([]string, 0, len(methods))
+	for alg := range methods {
+		algs = append(algs, alg)
+	}
+	sort.Strings(algs)
+	return algs
+}
+
+// JWK is the RFC 7517 JSON Web Key form of a public signing key.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set, ordered by key ID.
+func (s *KeySet) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range s.keys {
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Method.Alg()}
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType, jwk.Curve = "OKP", "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+	return set
+}
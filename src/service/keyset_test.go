@@ -0,0 +1,161 @@
+package service
+
+import (
+	"crudl_service/src/config"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func writeKey(t *testing.T, dir, name, pemType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name+".pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testKeyFiles writes an RSA and an Ed25519 private key plus the public half
+// of another Ed25519 key, keyed by kid.
+func testKeyFiles(t *testing.T) map[string]string {
+	t.Helper()
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	oldPublic, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	edDER, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	oldDER, _ := x509.MarshalPKIXPublicKey(oldPublic)
+	return map[string]string{
+		"rsa": writeKey(t, dir, "rsa", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)),
+		"ed":  writeKey(t, dir, "ed", "PRIVATE KEY", edDER),
+		"old": writeKey(t, dir, "old", "PUBLIC KEY", oldDER),
+	}
+}
+
+func testClaims() jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{Subject: "user123", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))}
+}
+
+func verify(set *KeySet, token string) error {
+	_, err := jwt.ParseWithClaims(token, &jwt.RegisteredClaims{}, set.Keyfunc, jwt.WithValidMethods(set.ValidMethods()))
+	return err
+}
+
+func TestKeySet_SignAndRotate(t *testing.T) {
+	files := testKeyFiles(t)
+
+	for _, active := range []string{"rsa", "ed"} {
+		t.Run(active, func(t *testing.T) {
+			set, err := LoadKeySet(&config.JWTConfig{KeyFiles: files, ActiveKeyID: active})
+			if err != nil {
+				t.Fatalf("Failed to load key set: %v", err)
+			}
+			token, err := set.Sign(testClaims())
+			if err != nil {
+				t.Fatalf("Failed to sign: %v", err)
+			}
+			parsed, _, _ := jwt.NewParser().ParseUnverified(token, &jwt.RegisteredClaims{})
+			if parsed.Header["kid"] != active {
+				t.Errorf("Expected kid %s, got %v", active, parsed.Header["kid"])
+			}
+			if err := verify(set, token); err != nil {
+				t.Errorf("Expected token to verify: %v", err)
+			}
+		})
+	}
+
+	oldSet, _ := LoadKeySet(&config.JWTConfig{KeyFiles: files, ActiveKeyID: "rsa"})
+	token, _ := oldSet.Sign(testClaims())
+	rotated, _ := LoadKeySet(&config.JWTConfig{KeyFiles: files, ActiveKeyID: "ed"})
+	if err := verify(rotated, token); err != nil {
+		t.Errorf("Expected tokens of a rotated out key to verify: %v", err)
+	}
+}
+
+func TestKeySet_RejectsForgedTokens(t *testing.T) {
+	files := testKeyFiles(t)
+	set, err := LoadKeySet(&config.JWTConfig{KeyFiles: files, ActiveKeyID: "ed", SecretKey: "legacy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unknown := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	unknown.Header["kid"] = "missing"
+	unknownToken, _ := unknown.SignedString([]byte("legacy"))
+
+	confused := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	confused.Header["kid"] = "rsa"
+	confusedToken, _ := confused.SignedString([]byte("anything"))
+
+	wrongSecret, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims()).SignedString([]byte("guess"))
+	legacy, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims()).SignedString([]byte("legacy"))
+
+	for name, token := range map[string]string{"Unknown kid": unknownToken, "Algorithm confusion": confusedToken, "Wrong secret": wrongSecret} {
+		if err := verify(set, token); err == nil {
+			t.Errorf("%s: expected token to be rejected", name)
+		}
+	}
+	if err := verify(set, legacy); err != nil {
+		t.Errorf("Expected legacy HS256 token to verify: %v", err)
+	}
+}
+
+func TestLoadKeySet_Errors(t *testing.T) {
+	files := testKeyFiles(t)
+	short, _ := rsa.GenerateKey(rand.Reader, 1024)
+	files["short"] = writeKey(t, t.TempDir(), "short", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(short))
+
+	tests := []struct {
+		name string
+		cfg  config.JWTConfig
+	}{
+		{"Public key cannot sign", config.JWTConfig{KeyFiles: map[string]string{"old": files["old"]}, ActiveKeyID: "old"}},
+		{"Missing file", config.JWTConfig{KeyFiles: map[string]string{"x": filepath.Join(t.TempDir(), "none.pem")}}},
+		{"Short RSA key", config.JWTConfig{KeyFiles: map[string]string{"short": files["short"]}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadKeySet(&tt.cfg); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestKeySet_JWKS(t *testing.T) {
+	set, err := LoadKeySet(&config.JWTConfig{KeyFiles: testKeyFiles(t), ActiveKeyID: "rsa", SecretKey: "legacy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := set.JWKS().Keys
+	if len(keys) != 3 {
+		t.Fatalf("Expected 3 public keys, got %d", len(keys))
+	}
+	for _, key := range keys {
+		switch key.KeyID {
+		case "rsa":
+			if key.KeyType != "RSA" || key.Algorithm != "RS256" || key.N == "" || key.E != "AQAB" {
+				t.Errorf("Unexpected RSA key: %+v", key)
+			}
+		case "ed", "old":
+			if key.KeyType != "OKP" || key.Curve != "Ed25519" || key.Algorithm != "EdDSA" || key.X == "" {
+				t.Errorf("Unexpected Ed25519 key: %+v", key)
+			}
+		default:
+			t.Errorf("Unexpected key %s", key.KeyID)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package service
+
+import "time"
+
+// LoginThrottle slows down password guessing against one key, a username or
+// a client address. After FreeAttempts failed logins every further failure
+// locks the key for a delay that doubles each time, up to MaxDelay.
+type LoginThrottle struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	// Window is how long failures are remembered after the last one.
+	Window time.Duration
+}
+
+var (
+	// UserLoginThrottle applies to a single username.
+	UserLoginThrottle = LoginThrottle{FreeAttempts: 5, BaseDelay: time.Second, MaxDelay: 15 * time.Minute, Window: time.Hour}
+	// IPLoginThrottle applies to a single client address, which may be shared
+	// by many users and so gets more attempts.
+	IPLoginThrottle = LoginThrottle{FreeAttempts: 50, BaseDelay: time.Second, MaxDelay: 15 * time.Minute, Window: time.Hour}
+)
+
+// Delay returns how long a key stays locked after its failures-th failed
+// login in a row; zero means it is not locked.
+func (t LoginThrottle) Delay(failures int) time.Duration {
+	n := failures - t.FreeAttempts
+	if n <= 0 {
+		return 0
+	}
+	delay := t.BaseDelay
+	for i := 1; i < n; i++ {
+		delay *= 2
+		if delay >= t.MaxDelay {
+			return t.MaxDelay
+		}
+	}
+	return min(delay, t.MaxDelay)
+}
+
+// LockedOut reports whether failures have reached the longest delay, which
+// counts as a lockout of the key.
+func (t LoginThrottle) LockedOut(failures int) bool {
+	return t.Delay(failures) == t.MaxDelay
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginThrottle_Delay(t *testing.T) {
+	throttle := LoginThrottle{FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{7, 8 * time.Second},
+		{8, 10 * time.Second},
+		{1000, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := throttle.Delay(tt.failures); got != tt.expected {
+			t.Errorf("Delay(%d): expected %v, got %v", tt.failures, tt.expected, got)
+		}
+	}
+	if throttle.LockedOut(7) || !throttle.LockedOut(8) {
+		t.Error("Expected a lockout once the delay reaches MaxDelay")
+	}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"bufio"
+	"crudl_service/src/config"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxPasswordBytes is the longest password bcrypt accepts. Longer passwords
+// are rejected rather than silently truncated.
+const MaxPasswordBytes = 72
+
+// PasswordPolicy decides which new passwords are acceptable.
+type PasswordPolicy struct {
+	minLength int
+	// breached holds known breached passwords, lowercased.
+	breached map[string]struct{}
+}
+
+// NewPasswordPolicy returns a policy requiring minLength characters and
+// rejecting the given breached passwords, compared case-insensitively.
+func NewPasswordPolicy(minLength int, breached ...string) *PasswordPolicy {
+	p := &PasswordPolicy{minLength: minLength, breached: make(map[string]struct{}, len(breached))}
+	for _, password := range breached {
+		p.breached[strings.ToLower(password)] = struct{}{}
+	}
+	return p
+}
+
+// LoadPasswordPolicy builds the policy described by cfg, reading its
+// breached password list.
+func LoadPasswordPolicy(cfg *config.PasswordConfig) (*PasswordPolicy, error) {
+	policy := NewPasswordPolicy(cfg.MinLength)
+	if cfg.BreachedListFile == "" {
+		return policy, nil
+	}
+	f, err := os.Open(cfg.BreachedListFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if password := strings.TrimRight(scanner.Text(), "\r"); password != "" {
+			policy.breached[strings.ToLower(password)] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached password list: %w", err)
+	}
+	return policy, nil
+}
+
+// Check returns a validation problem for field when password breaks the
+// policy.
+func (p *PasswordPolicy) Check(field, password string) error {
+	var violation *FieldError
+	_, breached := p.breached[strings.ToLower(password)]
+	switch {
+	case utf8.RuneCountInString(password) < p.minLength:
+		violation = &FieldError{Code: "too_short", Message: "Length must be at least " + strconv.Itoa(p.minLength)}
+	case len(password) > MaxPasswordBytes:
+		violation = checkMaxBytes(password, MaxPasswordBytes)
+	case breached:
+		violation = &FieldError{Code: "breached_password", Message: "Password appears in a list of breached passwords"}
+	default:
+		return nil
+	}
+	violation.Field = field
+	return ValidationProblem(*violation)
+}
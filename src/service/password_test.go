@@ -0,0 +1,61 @@
+package service
+
+import (
+	"crudl_service/src/config"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicy_Check(t *testing.T) {
+	policy := NewPasswordPolicy(8, "Password123")
+	tests := []struct {
+		name     string
+		password string
+		code     string
+	}{
+		{"Valid", "correct horse", ""},
+		{"Too short", "short", "too_short"},
+		{"Multibyte length counts characters", "пароль12", ""},
+		{"Over 72 bytes", strings.Repeat("я", 37), "too_long"},
+		{"Breached", "password123", "breached_password"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check("password", tt.password)
+			if tt.code == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+			var problem *Problem
+			if !errors.As(err, &problem) || len(problem.Errors) != 1 {
+				t.Fatalf("Expected a validation problem, got %v", err)
+			}
+			if fe := problem.Errors[0]; fe.Field != "password" || fe.Code != tt.code {
+				t.Errorf("Expected password %s, got %+v", tt.code, fe)
+			}
+		})
+	}
+}
+
+func TestLoadPasswordPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	os.WriteFile(path, []byte("qwertyuiop\r\niloveyou123\n\n"), 0o600)
+
+	policy, err := LoadPasswordPolicy(&config.PasswordConfig{MinLength: 8, BreachedListFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.Check("password", "QWERTYUIOP") == nil || policy.Check("password", "iloveyou123") == nil {
+		t.Error("Expected passwords from the list to be rejected")
+	}
+
+	if _, err := LoadPasswordPolicy(&config.PasswordConfig{MinLength: 8, BreachedListFile: path + ".missing"}); err == nil {
+		t.Error("Expected an error for a missing list")
+	}
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// MergePatchContentType is the media type of RFC 7396 JSON Merge Patch bodies.
+const MergePatchContentType = "application/merge-patch+json"
+
+// MergePatch applies an RFC 7396 JSON Merge Patch to the original document:
+// object members in patch replace those in original, null members remove
+// them, and any non-object patch replaces the document as a whole.
+func MergePatch(original, patch []byte) ([]byte, error) {
+	var target, changes any
+	if err := json.Unmarshal(original, &target); err != nil {
+		return nil, fmt.Errorf("invalid original document: %w", err)
+	}
+	if err := json.Unmarshal(patch, &changes); err != nil {
+		return nil, fmt.Errorf("invalid merge patch: %w", err)
+	}
+	return json.Marshal(mergeValue(target, changes))
+}
+
+func mergeValue(target, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = map[string]any{}
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergeValue(targetObj[key], value)
+	}
+	return targetObj
+}
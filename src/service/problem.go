@@ -0,0 +1,113 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ProblemContentType is the media type of RFC 7807 problem details.
+const ProblemContentType = "application/problem+json"
+
+// Stable machine-readable problem codes. Clients should branch on these, never
+// on titles or details.
+const (
+	CodeInvalidBody          = "invalid_body"
+	CodeInvalidParameter     = "invalid_parameter"
+	CodeValidationFailed     = "validation_failed"
+	CodeUnauthorized         = "unauthorized"
+	CodeInvalidCredentials   = "invalid_credentials"
+	CodeRefreshTokenReused   = "refresh_token_reused"
+	CodeAccountDisabled      = "account_disabled"
+	CodeTooManyAttempts      = "too_many_attempts"
+	CodeForbidden            = "forbidden"
+	CodeInsufficientScope    = "insufficient_scope"
+	CodeNotFound             = "not_found"
+	CodeConflict             = "conflict"
+	CodePreconditionFailed   = "precondition_failed"
+	CodePreconditionRequired = "precondition_required"
+	CodeUsernameTaken        = "username_taken"
+	CodeEmailTaken           = "email_taken"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeBodyTooLarge         = "body_too_large"
+	CodeExchangeRateMissing  = "exchange_rate_missing"
+	CodeRequestCanceled      = "request_canceled"
+	CodeUnavailable          = "service_unavailable"
+	CodeInternal             = "internal_error"
+)
+
+// FieldError describes why a single request field or parameter was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Problem is an RFC 7807 problem details object extended with a stable Code
+// and field-level Errors. It implements error so that it can be returned
+// through ordinary error paths and written unchanged by the API error helpers.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+	return p.Title
+}
+
+// NewProblem returns a problem of the generic "about:blank" type, whose title
+// is the status text as RFC 7807 recommends.
+func NewProblem(status int, code, detail string) *Problem {
+	title := http.StatusText(status)
+	if status == 499 {
+		title = "Client Closed Request"
+	}
+	return &Problem{
+		Type:   "about:blank",
+		Title:  title,
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// ValidationProblem reports every rejected field of a request body at once.
+func ValidationProblem(errs ...FieldError) *Problem {
+	p := NewProblem(http.StatusBadRequest, CodeValidationFailed, "Request validation failed")
+	p.Errors = errs
+	return p
+}
+
+// InvalidParameter reports a malformed query or path parameter.
+func InvalidParameter(name, message string) *Problem {
+	p := NewProblem(http.StatusBadRequest, CodeInvalidParameter, "Invalid parameter "+name)
+	p.Errors = []FieldError{{Field: name, Code: CodeInvalidParameter, Message: message}}
+	return p
+}
+
+// WriteProblem writes p as an application/problem+json response for r.
+func WriteProblem(w http.ResponseWriter, r *http.Request, p *Problem) {
+	problem := *p
+	if problem.Instance == "" && r != nil {
+		problem.Instance = r.URL.Path
+	}
+	body, err := json.Marshal(problem)
+	if err != nil {
+		log.WithError(err).Error("Failed to marshal problem details")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	w.Write(body)
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteProblem(t *testing.T) {
+	req := httptest.NewRequest("GET", "/subscription/1", nil)
+	w := httptest.NewRecorder()
+
+	WriteProblem(w, req, ValidationProblem(
+		FieldError{Field: "start_date", Code: "required", Message: "Start date is required"},
+		FieldError{Field: "currency", Code: "invalid_currency", Message: "Expected an ISO 4217 currency code"},
+	))
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != ProblemContentType {
+		t.Errorf("Expected content type %s, got %s", ProblemContentType, ct)
+	}
+	var problem Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("Failed to unmarshal problem: %v", err)
+	}
+	if problem.Code != CodeValidationFailed || problem.Status != http.StatusBadRequest || problem.Type != "about:blank" {
+		t.Errorf("Unexpected problem: %+v", problem)
+	}
+	if problem.Title != "Bad Request" || problem.Instance != "/subscription/1" {
+		t.Errorf("Unexpected title or instance: %+v", problem)
+	}
+	if len(problem.Errors) != 2 || problem.Errors[1].Field != "currency" {
+		t.Errorf("Expected both field errors, got %+v", problem.Errors)
+	}
+}
+
+func TestWriteProblem_DoesNotMutateProblem(t *testing.T) {
+	p := NewProblem(http.StatusNotFound, CodeNotFound, "Subscription not found")
+	WriteProblem(httptest.NewRecorder(), httptest.NewRequest("GET", "/a", nil), p)
+
+	if p.Instance != "" {
+		t.Errorf("Expected shared problem to stay untouched, got instance %q", p.Instance)
+	}
+}
+
+func TestReadUserData_ProblemResponse(t *testing.T) {
+	req := httptest.NewRequest("POST", "/test", nil)
+	w := httptest.NewRecorder()
+
+	var result TestStruct
+	ReadUserData(w, req, &result)
+
+	var problem Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("Failed to unmarshal problem: %v", err)
+	}
+	if problem.Code != CodeInvalidBody {
+		t.Errorf("Expected code %s, got %s", CodeInvalidBody, problem.Code)
+	}
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"net/mail"
+	"strings"
+	"time"
+
+	// Embedded zone data keeps timezone validation independent of the host.
+	_ "time/tzdata"
+)
+
+// NormalizeEmail returns the form in which email addresses are stored:
+// trimmed, with a lowercased domain.
+func NormalizeEmail(email string) string {
+	email = strings.TrimSpace(email)
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		email = email[:at] + strings.ToLower(email[at:])
+	}
+	return email
+}
+
+// ValidEmail reports whether email is a bare address such as
+// alice@example.com, without a display name or angle brackets.
+func ValidEmail(email string) bool {
+	email = strings.TrimSpace(email)
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Name == "" && address.Address == email && strings.Contains(email[strings.LastIndex(email, "@"):], ".")
+}
+
+// ValidTimezone reports whether name is an IANA time zone such as
+// Europe/Moscow. UTC is accepted; the empty name and "Local" are not.
+func ValidTimezone(name string) bool {
+	if name == "" || name == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
@@ -0,0 +1,49 @@
+package service
+
+import "testing"
+
+func TestNormalizeEmail(t *testing.T) {
+	if got := NormalizeEmail(" Alice@Example.COM "); got != "Alice@example.com" {
+		t.Errorf("Expected 'Alice@example.com', got '%s'", got)
+	}
+}
+
+func TestValidEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		valid bool
+	}{
+		{"alice@example.com", true},
+		{" alice.smith+subs@mail.example.org ", true},
+		{"alice", false},
+		{"alice@localhost", false},
+		{"Alice <alice@example.com>", false},
+		{"alice@@example.com", false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidEmail(tt.email); got != tt.valid {
+			t.Errorf("ValidEmail(%q): expected %v, got %v", tt.email, tt.valid, got)
+		}
+	}
+}
+
+func TestValidTimezone(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"Europe/Moscow", true},
+		{"UTC", true},
+		{"America/Argentina/Buenos_Aires", true},
+		{"Local", false},
+		{"Mars/Olympus_Mons", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidTimezone(tt.name); got != tt.valid {
+			t.Errorf("ValidTimezone(%q): expected %v, got %v", tt.name, tt.valid, got)
+		}
+	}
+}
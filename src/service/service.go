@@ -2,22 +2,109 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	log "github.com/sirupsen/logrus"
 )
 
-func ReadUserData(w http.ResponseWriter, r *http.Request, requestStruct any) bool {
-	if err := json.NewDecoder(r.Body).Decode(requestStruct); err != nil {
-		log.WithError(err).Error("Failed to unmarshal JSON data")
-		http.Error(w, "Incorrect input data format", http.StatusBadRequest)
+// MaxBodyBytes caps the size of JSON request bodies.
+const MaxBodyBytes = 1 << 20
+
+// Check validates a decoded request body against rules that cannot be
+// declared in tags, e.g. because they depend on configuration. It returns a
+// validation problem or nil.
+type Check func() error
+
+// ReadUserData decodes the JSON body of r into requestStruct and validates it
+// against its declared rules and checks, writing a problem response and
+// returning false when the body is oversized, malformed, has unknown fields
+// or is invalid. Violations found by checks are reported together with those
+// of the declared rules, except for fields the latter already rejected.
+func ReadUserData(w http.ResponseWriter, r *http.Request, requestStruct any, checks ...Check) bool {
+	err := DecodeJSON(http.MaxBytesReader(w, r.Body, MaxBodyBytes), requestStruct)
+	if err == nil {
+		err = validateWith(requestStruct, checks)
+	}
+	if err != nil {
+		var problem *Problem
+		errors.As(err, &problem)
+		WriteProblem(w, r, problem)
 		return false
 	}
 	return true
 }
 
+func validateWith(v any, checks []Check) error {
+	var errs []FieldError
+	rejected := map[string]bool{}
+	if err := Validate(v); err != nil {
+		errs = err.(*Problem).Errors
+		for _, e := range errs {
+			rejected[e.Field] = true
+		}
+	}
+	for _, check := range checks {
+		err := check()
+		if err == nil {
+			continue
+		}
+		var problem *Problem
+		if !errors.As(err, &problem) {
+			log.WithError(err).Error("Request check failed")
+			return NewProblem(http.StatusInternalServerError, CodeInternal, "Internal server error")
+		}
+		for _, e := range problem.Errors {
+			if !rejected[e.Field] {
+				errs = append(errs, e)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return ValidationProblem(errs...)
+	}
+	return nil
+}
+
+// DecodeJSON strictly decodes a single JSON value from body into v: unknown
+// fields and trailing data are rejected. Errors are problems naming the
+// offending field where possible.
+func DecodeJSON(body io.Reader, v any) error {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err == nil && decoder.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("unexpected data after JSON value")
+	}
+	if err == nil {
+		return nil
+	}
+	log.WithError(err).Error("Failed to unmarshal JSON data")
+
+	var maxBytesErr *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return NewProblem(http.StatusRequestEntityTooLarge, CodeBodyTooLarge,
+			fmt.Sprintf("Request body must not exceed %d bytes", maxBytesErr.Limit))
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		p := NewProblem(http.StatusBadRequest, CodeInvalidBody, "Incorrect input data format")
+		p.Errors = []FieldError{{Field: typeErr.Field, Code: "invalid_type", Message: "Expected a value of type " + typeErr.Type.String()}}
+		return p
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		p := NewProblem(http.StatusBadRequest, CodeInvalidBody, "Incorrect input data format")
+		p.Errors = []FieldError{{Field: field, Code: "unknown_field", Message: "Field is not supported"}}
+		return p
+	}
+	return NewProblem(http.StatusBadRequest, CodeInvalidBody, "Incorrect input data format")
+}
+
 func GetIDRequest(r *http.Request) (int64, error) {
 	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	if err != nil {
@@ -2,8 +2,10 @@ package service
 
 import (
 	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -53,3 +55,75 @@ func TestReadUserData_NotPointer(t *testing.T) {
 		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
 	}
 }
+
+func TestReadUserData_StrictDecoding(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		field  string
+	}{
+		{"Unknown field", `{"name":"John","nickname":"J"}`, http.StatusBadRequest, "nickname"},
+		{"Wrong type", `{"name":"John","age":"thirty"}`, http.StatusBadRequest, "age"},
+		{"Trailing data", `{"name":"John"} {"name":"Jane"}`, http.StatusBadRequest, ""},
+		{"Oversized body", `{"name":"` + strings.Repeat("a", MaxBodyBytes) + `"}`, http.StatusRequestEntityTooLarge, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/test", bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+
+			var result TestStruct
+			if ReadUserData(w, req, &result) {
+				t.Fatal("Expected the body to be rejected")
+			}
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+			var problem Problem
+			json.Unmarshal(w.Body.Bytes(), &problem)
+			if tt.field != "" && (len(problem.Errors) != 1 || problem.Errors[0].Field != tt.field) {
+				t.Errorf("Expected a violation for %s, got %+v", tt.field, problem.Errors)
+			}
+		})
+	}
+}
+
+func TestReadUserData_RunsValidation(t *testing.T) {
+	req := httptest.NewRequest("POST", "/test", bytes.NewBufferString(`{"name":""}`))
+	w := httptest.NewRecorder()
+
+	var result struct {
+		Name string `json:"name" validate:"required"`
+	}
+	if ReadUserData(w, req, &result) {
+		t.Fatal("Expected validation to fail")
+	}
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestReadUserData_RunsChecks(t *testing.T) {
+	req := httptest.NewRequest("POST", "/test", bytes.NewBufferString(`{"name":"","secret":"x"}`))
+	w := httptest.NewRecorder()
+
+	var result struct {
+		Name   string `json:"name" validate:"required"`
+		Secret string `json:"secret"`
+	}
+	check := func(field string) Check {
+		return func() error {
+			return ValidationProblem(FieldError{Field: field, Code: "weak", Message: "Too weak"})
+		}
+	}
+	if ReadUserData(w, req, &result, check("secret"), check("name")) {
+		t.Fatal("Expected validation to fail")
+	}
+	var problem Problem
+	json.Unmarshal(w.Body.Bytes(), &problem)
+	if len(problem.Errors) != 2 || problem.Errors[0].Code != "required" || problem.Errors[1].Field != "secret" {
+		t.Errorf("Expected the tag violation and the secret check, got %+v", problem.Errors)
+	}
+}
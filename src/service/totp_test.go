@@ -0,0 +1,70 @@
+package service
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 test key of RFC 6238, "12345678901234567890".
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode_RFC6238(t *testing.T) {
+	tests := []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		code, err := TOTPCode(rfcSecret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.expected {
+			t.Errorf("At %d: expected %s, got %s", tt.unix, tt.expected, code)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	step := TOTPStep(now)
+	previous, _ := TOTPCode(rfcSecret, step-1)
+	stale, _ := TOTPCode(rfcSecret, step-2)
+
+	if got, ok := VerifyTOTP(rfcSecret, "081804", now); !ok || got != step {
+		t.Errorf("Expected the current code to match step %d, got %d %v", step, got, ok)
+	}
+	if got, ok := VerifyTOTP(rfcSecret, previous, now); !ok || got != step-1 {
+		t.Error("Expected the previous step to be accepted for clock drift")
+	}
+	for _, code := range []string{stale, "000000", "81804", ""} {
+		if _, ok := VerifyTOTP(rfcSecret, code, now); ok {
+			t.Errorf("Expected %q to be rejected", code)
+		}
+	}
+}
+
+func TestNewTOTPSecret(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TOTPCode(secret, 1); err != nil {
+		t.Errorf("Expected a usable secret, got %v", err)
+	}
+
+	uri, err := url.Parse(TOTPProvisioningURI(secret, "CRUDL Service", "alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || !strings.HasSuffix(uri.Path, ":alice") || uri.Query().Get("secret") != secret {
+		t.Errorf("Unexpected provisioning URI %s", uri)
+	}
+}
@@ -0,0 +1,23 @@
+package service
+
+import (
+	"regexp"
+	"strings"
+)
+
+// usernamePattern is the format of normalized usernames: 3 to 64 lowercase
+// letters, digits, dots, dashes and underscores, starting with a letter or
+// digit.
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{2,63}$`)
+
+// NormalizeUsername returns the canonical form of username, under which it
+// is stored and compared: trimmed and lowercased.
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// ValidUsername reports whether username is acceptable for a new account
+// once normalized.
+func ValidUsername(username string) bool {
+	return usernamePattern.MatchString(NormalizeUsername(username))
+}
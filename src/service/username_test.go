@@ -0,0 +1,34 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeUsername(t *testing.T) {
+	if got := NormalizeUsername("  Alice.Smith "); got != "alice.smith" {
+		t.Errorf("Expected 'alice.smith', got '%s'", got)
+	}
+}
+
+func TestValidUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		valid    bool
+	}{
+		{"alice", true},
+		{"Alice_99", true},
+		{" bob-smith ", true},
+		{"al", false},
+		{"_alice", false},
+		{"alice smith", false},
+		{"алиса", false},
+		{strings.Repeat("a", 65), false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidUsername(tt.username); got != tt.valid {
+			t.Errorf("ValidUsername(%q): expected %v, got %v", tt.username, tt.valid, got)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validate checks v, a pointer to a request struct, against the rules declared
+// in its `validate` struct tags and returns a validation problem listing every
+// violation, or nil. Fields are reported by their JSON names; slices of
+// structs tagged with "dive" are validated element by element.
+//
+// Rules are comma-separated and checked in order, stopping at the first
+// violation of a field:
+//
+//	required                      value must be present (non-nil, non-blank)
+//	min=N, max=N                  bounds a number, or the length of a string or slice
+//	maxbytes=N                    string must be at most N bytes long in UTF-8
+//	gt=N                          number must be greater than N
+//	oneof=a b c                   value must be one of the listed words
+//	date                          ISO-8601 date or legacy MM-YYYY month
+//	currency                      ISO 4217 currency code
+//	billing_period                known billing period
+//	username                      username format, see ValidUsername
+//	email                         bare email address, see ValidEmail
+//	timezone                      IANA time zone name
+//	gtefield=F                    date or number must not be before field F
+//	nefield=F                     value must differ from field F
+//	required_if=F v               required when field F equals v
+//	excluded_unless=F v           must be absent unless field F equals v
+//	dive                          validate each element of a slice of structs
+//
+// All rules but required and required_if accept an absent value (nil pointer
+// or empty string); combine them with required to demand one.
+func Validate(v any) error {
+	errs := validateStruct(reflect.Indirect(reflect.ValueOf(v)), "")
+	if len(errs) > 0 {
+		return ValidationProblem(errs...)
+	}
+	return nil
+}
+
+// rule is one parsed entry of a validate tag.
+type rule struct {
+	name  string
+	param string
+}
+
+func parseRules(tag string) []rule {
+	var rules []rule
+	for _, entry := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(strings.TrimSpace(entry), "=")
+		if name != "" {
+			rules = append(rules, rule{name: name, param: param})
+		}
+	}
+	return rules
+}
+
+func validateStruct(v reflect.Value, prefix string) []FieldError {
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	var errs []FieldError
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup("validate")
+		if !ok || !field.IsExported() {
+			continue
+		}
+		name := prefix + jsonName(field)
+		value := v.Field(i)
+		for _, r := range parseRules(tag) {
+			if r.name == "dive" {
+				for j := 0; j < value.Len(); j++ {
+					errs = append(errs, validateStruct(reflect.Indirect(value.Index(j)), fmt.Sprintf("%s[%d].", name, j))...)
+				}
+				continue
+			}
+			if fe := checkRule(v, value, r); fe != nil {
+				fe.Field = name
+				errs = append(errs, *fe)
+				break
+			}
+		}
+	}
+	return errs
+}
+
+// jsonName returns the name a field has in request bodies.
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+// present reports whether value carries data: a non-nil pointer, a non-blank
+// string, a non-empty slice or any number.
+func present(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return !value.IsNil()
+	case reflect.String:
+		return strings.TrimSpace(value.String()) != ""
+	case reflect.Slice, reflect.Map:
+		return value.Len() > 0
+	}
+	return true
+}
+
+func checkRule(parent, value reflect.Value, r rule) *FieldError {
+	switch r.name {
+	case "required":
+		if !present(value) {
+			return &FieldError{Code: "required", Message: "Value is required"}
+		}
+		return nil
+	case "required_if":
+		if fieldEquals(parent, r.param) && !present(value) {
+			return &FieldError{Code: "required", Message: "Value is required when " + describeCondition(parent, r.param)}
+		}
+		return nil
+	case "excluded_unless":
+		if !fieldEquals(parent, r.param) && present(value) {
+			return &FieldError{Code: "not_allowed", Message: "Value is only allowed when " + describeCondition(parent, r.param)}
+		}
+		return nil
+	}
+
+	if !present(value) {
+		return nil
+	}
+	value = reflect.Indirect(value)
+	switch r.name {
+	case "min", "max", "gt":
+		return checkBound(value, r)
+	case "maxbytes":
+		limit, err := strconv.Atoi(r.param)
+		if err != nil {
+			panic("service: bad parameter for validation rule " + r.name)
+		}
+		return checkMaxBytes(value.String(), limit)
+	case "oneof":
+		for _, option := range strings.Fields(r.param) {
+			if value.String() == option {
+				return nil
+			}
+		}
+		return &FieldError{Code: "not_allowed", Message: "Expected one of: " + strings.Join(strings.Fields(r.param), ", ")}
+	case "date":
+		if _, err := ParseDate(value.String()); err != nil {
+			return &FieldError{Code: "invalid_date", Message: err.Error()}
+		}
+	case "currency":
+		if _, ok := NormalizeCurrency(value.String()); !ok {
+			return &FieldError{Code: "invalid_currency", Message: "Expected an ISO 4217 currency code"}
+		}
+	case "billing_period":
+		if !knownBillingPeriod(value.String()) {
+			return &FieldError{Code: "invalid_billing_period", Message: fmt.Sprintf("unknown billing period %q", value.String())}
+		}
+	case "username":
+		if !ValidUsername(value.String()) {
+			return &FieldError{Code: "invalid_username", Message: "Expected 3 to 64 letters, digits, dots, dashes or underscores, starting with a letter or digit"}
+		}
+	case "email":
+		if !ValidEmail(value.String()) {
+			return &FieldError{Code: "invalid_email", Message: "Expected an email address such as alice@example.com"}
+		}
+	case "timezone":
+		if !ValidTimezone(value.String()) {
+			return &FieldError{Code: "invalid_timezone", Message: "Expected an IANA time zone such as Europe/Moscow"}
+		}
+	case "gtefield":
+		return checkOrder(value, reflect.Indirect(parent.FieldByName(r.param)), fieldLabel(parent, r.param))
+	case "nefield":
+		other := reflect.Indirect(parent.FieldByName(r.param))
+		if other.Kind() == reflect.String && strings.EqualFold(strings.TrimSpace(value.String()), strings.TrimSpace(other.String())) {
+			return &FieldError{Code: "same_value", Message: "Value must differ from " + fieldLabel(parent, r.param)}
+		}
+	default:
+		panic("service: unknown validation rule " + r.name)
+	}
+	return nil
+}
+
+func checkBound(value reflect.Value, r rule) *FieldError {
+	limit, err := strconv.ParseFloat(r.param, 64)
+	if err != nil {
+		panic("service: bad parameter for validation rule " + r.name)
+	}
+	var n float64
+	sized := true
+	switch value.Kind() {
+	case reflect.String:
+		n = float64(utf8.RuneCountInString(value.String()))
+	case reflect.Slice, reflect.Map:
+		n = float64(value.Len())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, sized = float64(value.Int()), false
+	case reflect.Float32, reflect.Float64:
+		n, sized = value.Float(), false
+	default:
+		panic("service: validation rule " + r.name + " does not apply to " + value.Kind().String())
+	}
+
+	switch {
+	case r.name == "gt" && n <= limit:
+		if limit == 0 {
+			return &FieldError{Code: "not_positive", Message: "Value must be positive"}
+		}
+		return &FieldError{Code: "out_of_range", Message: "Value must be greater than " + r.param}
+	case r.name == "min" && n < limit && sized:
+		return &FieldError{Code: "too_short", Message: "Length must be at least " + r.param}
+	case r.name == "min" && n < limit:
+		return &FieldError{Code: "out_of_range", Message: "Value must be at least " + r.param}
+	case r.name == "max" && n > limit && sized:
+		return &FieldError{Code: "too_long", Message: "Length must be at most " + r.param}
+	case r.name == "max" && n > limit:
+		return &FieldError{Code: "out_of_range", Message: "Value must be at most " + r.param}
+	}
+	return nil
+}
+
+// checkMaxBytes reports s being longer than limit bytes. Passwords are
+// limited this way, as bcrypt counts bytes rather than characters.
+func checkMaxBytes(s string, limit int) *FieldError {
+	if len(s) > limit {
+		return &FieldError{Code: "too_long", Message: "Length must be at most " + strconv.Itoa(limit) + " bytes"}
+	}
+	return nil
+}
+
+// checkOrder reports value being before other. Strings are compared as dates,
+// a legacy MM-YYYY value standing for the end of its month and other for the
+// start of its month. Unparsable or absent sides are left to their own rules.
+func checkOrder(value, other reflect.Value, otherName string) *FieldError {
+	if !other.IsValid() || !present(other) {
+		return nil
+	}
+	var before bool
+	switch value.Kind() {
+	case reflect.String:
+		end, errEnd := ParsePeriodEnd(value.String())
+		start, errStart := ParseDate(other.String())
+		if errEnd != nil || errStart != nil {
+			return nil
+		}
+		before = end.Before(start)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		before = value.Int() < other.Int()
+	default:
+		panic("service: validation rule gtefield does not apply to " + value.Kind().String())
+	}
+	if before {
+		return &FieldError{Code: "out_of_order", Message: "Value must not be before " + otherName}
+	}
+	return nil
+}
+
+// fieldEquals evaluates a "Field value" condition case-insensitively.
+func fieldEquals(parent reflect.Value, condition string) bool {
+	name, want, _ := strings.Cut(condition, " ")
+	field := reflect.Indirect(parent.FieldByName(name))
+	if !field.IsValid() || field.Kind() != reflect.String {
+		return false
+	}
+	return strings.EqualFold(strings.TrimSpace(field.String()), want)
+}
+
+func describeCondition(parent reflect.Value, condition string) string {
+	name, want, _ := strings.Cut(condition, " ")
+	return fieldLabel(parent, name) + " is " + want
+}
+
+// fieldLabel names a sibling field by its JSON name.
+func fieldLabel(parent reflect.Value, name string) string {
+	if field, ok := parent.Type().FieldByName(name); ok {
+		return jsonName(field)
+	}
+	return name
+}
+
+func knownBillingPeriod(period string) bool {
+	switch strings.ToLower(strings.TrimSpace(period)) {
+	case BillingWeekly, BillingMonthly, BillingQuarterly, BillingYearly, BillingCustom:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type validatedItem struct {
+	Code string `json:"code" validate:"required,currency"`
+}
+
+type validatedRequest struct {
+	Name     string          `json:"name" validate:"required,min=2,max=5"`
+	Count    int             `json:"count" validate:"min=1,max=10"`
+	Amount   float64         `json:"amount" validate:"gt=0"`
+	Kind     string          `json:"kind" validate:"oneof=a b"`
+	Start    *string         `json:"start" validate:"required,date"`
+	End      *string         `json:"end" validate:"date,gtefield=Start"`
+	Mode     string          `json:"mode"`
+	Interval *int            `json:"interval" validate:"required_if=Mode custom,excluded_unless=Mode custom"`
+	Other    string          `json:"other" validate:"nefield=Name"`
+	Items    []validatedItem `json:"items" validate:"max=2,dive"`
+	Email    *string         `json:"email" validate:"email"`
+	Timezone string          `json:"timezone" validate:"timezone"`
+	Secret   string          `json:"secret" validate:"maxbytes=4"`
+	Ignored  string          `json:"ignored"`
+}
+
+func strPtr(s string) *string { return &s }
+
+func validRequest() validatedRequest {
+	return validatedRequest{Name: "abc", Count: 1, Amount: 1, Kind: "a", Start: strPtr("2024-01-15")}
+}
+
+func violations(t *testing.T, err error) map[string]string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var problem *Problem
+	if !errors.As(err, &problem) || problem.Code != CodeValidationFailed {
+		t.Fatalf("Expected a validation problem, got %v", err)
+	}
+	got := map[string]string{}
+	for _, e := range problem.Errors {
+		got[e.Field] = e.Code
+	}
+	return got
+}
+
+func TestValidate(t *testing.T) {
+	interval := 3
+	tests := []struct {
+		name   string
+		modify func(*validatedRequest)
+		want   map[string]string
+	}{
+		{"Valid", func(r *validatedRequest) {}, nil},
+		{"Missing required", func(r *validatedRequest) { r.Name, r.Start = "  ", nil },
+			map[string]string{"name": "required", "start": "required"}},
+		{"String length", func(r *validatedRequest) { r.Name = "abcdef" }, map[string]string{"name": "too_long"}},
+		{"Number range", func(r *validatedRequest) { r.Count = 11 }, map[string]string{"count": "out_of_range"}},
+		{"Not positive", func(r *validatedRequest) { r.Amount = 0 }, map[string]string{"amount": "not_positive"}},
+		{"Oneof", func(r *validatedRequest) { r.Kind = "c" }, map[string]string{"kind": "not_allowed"}},
+		{"Bad date", func(r *validatedRequest) { r.Start = strPtr("15.01.2024") }, map[string]string{"start": "invalid_date"}},
+		{"End before start", func(r *validatedRequest) { r.End = strPtr("2024-01-14") }, map[string]string{"end": "out_of_order"}},
+		{"Month end covers the month", func(r *validatedRequest) { r.End = strPtr("01-2024") }, nil},
+		{"Required if", func(r *validatedRequest) { r.Mode = "Custom" }, map[string]string{"interval": "required"}},
+		{"Excluded unless", func(r *validatedRequest) { r.Interval = &interval }, map[string]string{"interval": "not_allowed"}},
+		{"Same value", func(r *validatedRequest) { r.Other = "ABC" }, map[string]string{"other": "same_value"}},
+		{"Dive", func(r *validatedRequest) { r.Items = []validatedItem{{Code: "usd"}, {Code: "XYZ"}} },
+			map[string]string{"items[1].code": "invalid_currency"}},
+		{"Email", func(r *validatedRequest) { r.Email = strPtr("alice@") }, map[string]string{"email": "invalid_email"}},
+		{"Timezone", func(r *validatedRequest) { r.Timezone = "Europe/Atlantis" }, map[string]string{"timezone": "invalid_timezone"}},
+		{"Bytes", func(r *validatedRequest) { r.Secret = "яяя" }, map[string]string{"secret": "too_long"}},
+		{"Bytes within limit", func(r *validatedRequest) { r.Secret = "яя" }, nil},
+		{"Slice length", func(r *validatedRequest) { r.Items = make([]validatedItem, 3) }, map[string]string{"items": "too_long"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := validRequest()
+			tt.modify(&req)
+			if got := violations(t, Validate(&req)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected violations %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestValidate_UnknownRulePanics(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(r.(string), "unknown validation rule") {
+			t.Errorf("Expected a panic for an unknown rule, got %v", r)
+		}
+	}()
+	Validate(&struct {
+		Name string `validate:"hostname"`
+	}{Name: "x"})
+}
@@ -1,12 +1,111 @@
 package types
 
+import (
+	"encoding/json"
+	"time"
+)
+
+// UserSubscription is both the stored subscription and the create/replace
+// request body; the validate tags declare the rules checked by
+// service.Validate. UserId is empty for subscriptions anonymized when their
+// owner deleted the account.
 type UserSubscription struct {
 	Id          int64   `json:"id"`
-	ServiceName string  `json:"service_name"`
-	Price       int64   `json:"price"`
+	ServiceName string  `json:"service_name" validate:"required,max=255"`
+	Price       int64   `json:"price" validate:"gt=0"`
+	Currency    string  `json:"currency" validate:"currency"`
 	UserId      string  `json:"user_id"`
-	StartDate   *string `json:"start_date"`
-	EndDate     *string `json:"end_date"`
+	StartDate   *string `json:"start_date" validate:"required,date"`
+	// EndDate is inclusive; a legacy MM-YYYY end date covers its whole month.
+	EndDate *string `json:"end_date" validate:"date,gtefield=StartDate"`
+	// BillingPeriod is one of weekly, monthly, quarterly, yearly or custom;
+	// custom periods charge every BillingIntervalMonths months.
+	BillingPeriod         string `json:"billing_period" validate:"billing_period"`
+	BillingIntervalMonths *int   `json:"billing_interval_months,omitempty" validate:"required_if=BillingPeriod custom,excluded_unless=BillingPeriod custom,min=1,max=120"`
+	// BillingAnchor is the date of the first charge; it defaults to StartDate.
+	BillingAnchor *string `json:"billing_anchor" validate:"date"`
+	// Version starts at 1 and grows with every update; the ETag of the
+	// subscription is derived from it. Versions sent by clients are ignored.
+	Version int64 `json:"version"`
+	// CreatedAt and UpdatedAt are maintained by the database; values sent by
+	// clients are ignored.
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	// DeletedAt is set while the subscription is in the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// Sort columns accepted by SubscriptionListQuery.SortBy.
+const (
+	SortByID          = "id"
+	SortByPrice       = "price"
+	SortByStartDate   = "start_date"
+	SortByEndDate     = "end_date"
+	SortByServiceName = "service_name"
+	SortByUpdatedAt   = "updated_at"
+)
+
+// Subscription statuses accepted by SubscriptionListQuery.Status.
+const (
+	StatusActive = "active"
+	StatusEnded  = "ended"
+)
+
+// Selections of deleted subscriptions accepted by
+// SubscriptionListQuery.Deleted. Deleted subscriptions are excluded by
+// default.
+const (
+	DeletedExclude = ""
+	DeletedInclude = "include"
+	DeletedOnly    = "only"
+)
+
+// SubscriptionListQuery filters, orders and pages a user's subscriptions.
+// Zero values disable the corresponding filter.
+type SubscriptionListQuery struct {
+	// ServiceNamePrefix and ServiceNameContains match case-insensitively.
+	ServiceNamePrefix   string
+	ServiceNameContains string
+	MinPrice            *int64
+	MaxPrice            *int64
+	// ActiveOn (YYYY-MM-DD) keeps subscriptions running on that day.
+	ActiveOn *string
+	// Status is StatusActive (running today) or StatusEnded.
+	Status string
+	// UpdatedSince keeps subscriptions updated at or after that time.
+	UpdatedSince *time.Time
+	// Deleted is DeletedExclude, DeletedInclude or DeletedOnly.
+	Deleted    string
+	SortBy     string
+	Descending bool
+	After      *ListCursor
+	Limit      int
+}
+
+// ListCursor points at the last row of a page: its sort key and id. Value is
+// nil when the sort key is NULL (only possible for end_date).
+type ListCursor struct {
+	SortBy     string  `json:"s"`
+	Descending bool    `json:"d,omitempty"`
+	Value      *string `json:"v,omitempty"`
+	ID         int64   `json:"id"`
+}
+
+// SubscriptionListResponse is one page of subscriptions. NextCursor is set
+// only when HasMore is true; Total is set only when requested.
+type SubscriptionListResponse struct {
+	Data        []SubscriptionListItem `json:"data"`
+	HasMore     bool                   `json:"has_more"`
+	NextCursor  *string                `json:"next_cursor"`
+	NextAfterID *int64                 `json:"next_after_id"`
+	Total       *int64                 `json:"total,omitempty"`
+}
+
+// SubscriptionListItem is a listed subscription together with the ETag a
+// read of it would return, for use in If-Match.
+type SubscriptionListItem struct {
+	UserSubscription
+	ETag string `json:"etag"`
 }
 
 type UserSubscriptionData struct {
@@ -19,14 +118,29 @@ type UserRequest struct {
 }
 
 type UserSumSubscriptionRequest struct {
-	UserId    string `json:"user_id"`
-	StartDate string `json:"start_date"`
-	EndDate   string `json:"end_date"`
+	UserId         string `json:"user_id"`
+	StartDate      string `json:"start_date" validate:"required,date"`
+	EndDate        string `json:"end_date" validate:"required,date,gtefield=StartDate"`
+	TargetCurrency string `json:"target_currency" validate:"currency"`
 }
 
 type UserSubscriptionSumResponse struct {
 	UserId     string `json:"user_id"`
 	CurrentSum int64  `json:"current_sum"`
+	Currency   string `json:"currency"`
+}
+
+// ExchangeRate says that one unit of BaseCurrency costs Rate units of
+// QuoteCurrency starting from Month (MM-YYYY) until a newer rate is loaded.
+type ExchangeRate struct {
+	BaseCurrency  string  `json:"base_currency" validate:"required,currency"`
+	QuoteCurrency string  `json:"quote_currency" validate:"required,currency,nefield=BaseCurrency"`
+	Month         string  `json:"month"`
+	Rate          float64 `json:"rate" validate:"gt=0"`
+}
+
+type ExchangeRatesRequest struct {
+	Rates []ExchangeRate `json:"rates" validate:"required,max=1000,dive"`
 }
 
 type CreateSubscriptionResponse struct {
@@ -34,17 +148,224 @@ type CreateSubscriptionResponse struct {
 	SubscriptionId int64  `json:"subscription_id"`
 }
 
+// New passwords are checked against the configured password policy on top
+// of the rules declared here.
 type UserRegisterRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username string `json:"username" validate:"required,username"`
+	Password string `json:"password" validate:"required"`
 }
 
 type UserLoginRequest struct {
+	Username string `json:"username" validate:"required,max=255"`
+	Password string `json:"password" validate:"required,maxbytes=72"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required,maxbytes=72"`
+	NewPassword     string `json:"new_password" validate:"required"`
+}
+
+type PasswordResetRequest struct {
+	Username string `json:"username" validate:"required,max=255"`
+}
+
+type PasswordResetConfirmRequest struct {
+	Token       string `json:"token" validate:"required,max=128"`
+	NewPassword string `json:"new_password" validate:"required"`
+}
+
+// MFAChallengeResponse is returned by /login instead of tokens when the
+// account has two-factor authentication enabled. MFAToken must be sent with a
+// code to /login/mfa within ExpiresIn seconds.
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// MFALoginRequest completes a login with a TOTP code or a recovery code.
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required,max=128"`
+	Code     string `json:"code" validate:"required,max=32"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" validate:"required,max=32"`
+}
+
+type TOTPEnrollmentResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// RecoveryCodesResponse lists one-time recovery codes. They are only shown
+// once.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// User roles. Admins may read and change any user's subscriptions and manage
+// user accounts.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// UserInfo is a user account as shown to admins.
+type UserInfo struct {
+	ID         string     `json:"id"`
+	Username   string     `json:"username"`
+	Role       string     `json:"role"`
+	DisabledAt *time.Time `json:"disabled_at"`
+}
+
+// Profile holds the optional, user-editable details of an account. Absent
+// fields are not set.
+type Profile struct {
+	DisplayName *string `json:"display_name,omitempty" validate:"max=100"`
+	Email       *string `json:"email,omitempty" validate:"max=255,email"`
+	// DefaultCurrency is used by clients as the default target currency.
+	DefaultCurrency *string `json:"default_currency,omitempty" validate:"currency"`
+	// Timezone is an IANA time zone name such as Europe/Moscow.
+	Timezone *string `json:"timezone,omitempty" validate:"timezone"`
+}
+
+// UserProfile is the account of the authenticated user as returned by /me.
+type UserProfile struct {
+	ID       string `json:"id"`
 	Username string `json:"username"`
-	Password string `json:"password"`
+	Role     string `json:"role"`
+	Profile
+	MFAEnabled bool `json:"mfa_enabled"`
+}
+
+// Account deletion modes for the subscriptions of the deleted user.
+const (
+	SubscriptionsDelete    = "delete"
+	SubscriptionsAnonymize = "anonymize"
+)
+
+// DeleteAccountRequest confirms deleting the caller's account. Subscriptions
+// are deleted with the account unless anonymize is asked for, which keeps
+// them without any link to the user.
+type DeleteAccountRequest struct {
+	Password      string `json:"password" validate:"required,maxbytes=72"`
+	Subscriptions string `json:"subscriptions" validate:"oneof=delete anonymize"`
+}
+
+// SessionInfo describes a refresh token of the user.
+type SessionInfo struct {
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// AuditEventInfo is an audit trail entry. UserID is the user the event is
+// about and ActorID the user who caused it; they differ when an admin acts on
+// another user's data. Changes lists the changed members before and after
+// the change.
+type AuditEventInfo struct {
+	ID             int64           `json:"id"`
+	Event          string          `json:"event"`
+	UserID         *string         `json:"user_id"`
+	ActorID        *string         `json:"actor_id"`
+	Username       *string         `json:"username,omitempty"`
+	SubscriptionID *int64          `json:"subscription_id,omitempty"`
+	Changes        json.RawMessage `json:"changes,omitempty"`
+	RequestID      *string         `json:"request_id"`
+	IP             *string         `json:"ip"`
+	Detail         *string         `json:"detail"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// AuditEventQuery selects a page of audit events, newest first. Zero values
+// disable the corresponding filter.
+type AuditEventQuery struct {
+	UserID  string
+	ActorID string
+	Event   string
+	// Before keeps events older than the event with that ID.
+	Before int64
+	Limit  int
+}
+
+// AuditEventListResponse is one page of audit events, newest first.
+// NextBefore is the before parameter of the next page and is set only when
+// HasMore is true.
+type AuditEventListResponse struct {
+	Data       []AuditEventInfo `json:"data"`
+	HasMore    bool             `json:"has_more"`
+	NextBefore *int64           `json:"next_before"`
 }
 
+// UserExport is everything the service stores about a user, except secrets
+// such as the password hash, TOTP secret and token hashes.
+type UserExport struct {
+	ExportedAt    time.Time          `json:"exported_at"`
+	User          UserProfile        `json:"user"`
+	Subscriptions []UserSubscription `json:"subscriptions"`
+	APIKeys       []APIKey           `json:"api_keys"`
+	Sessions      []SessionInfo      `json:"sessions"`
+	AuditEvents   []AuditEventInfo   `json:"audit_events"`
+}
+
+// UserListResponse is one page of users ordered by username. NextAfter is the
+// after parameter of the next page and is set only when HasMore is true.
+type UserListResponse struct {
+	Data      []UserInfo `json:"data"`
+	HasMore   bool       `json:"has_more"`
+	NextAfter *string    `json:"next_after"`
+}
+
+// UpdateUserAccessRequest changes the role or disabled state of a user;
+// absent fields are left unchanged.
+type UpdateUserAccessRequest struct {
+	Role     *string `json:"role" validate:"oneof=user admin"`
+	Disabled *bool   `json:"disabled"`
+}
+
+// API key scopes. Read-only keys may call endpoints that change nothing.
+const (
+	APIKeyScopeRead      = "read"
+	APIKeyScopeReadWrite = "read_write"
+)
+
+// APIKey describes a personal API key. The key itself is only shown once, in
+// CreateAPIKeyResponse.
+type APIKey struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scope      string     `json:"scope"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name  string `json:"name" validate:"required,max=100"`
+	Scope string `json:"scope" validate:"required,oneof=read read_write"`
+}
+
+type RenameAPIKeyRequest struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+type CreateAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// AuthResponse carries a short-lived access token in Token and the refresh
+// token that obtains the next pair from /token/refresh.
 type AuthResponse struct {
-	Token  string `json:"token"`
-	UserID string `json:"user_id"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	// ExpiresIn is the access token lifetime in seconds.
+	ExpiresIn int64  `json:"expires_in"`
+	UserID    string `json:"user_id"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required,max=128"`
 }